@@ -23,10 +23,13 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
 	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/prometheus/config"
 	prom_discovery "github.com/prometheus/prometheus/discovery"
@@ -38,12 +41,15 @@ import (
 	"tkestack.io/kvass/pkg/prom"
 	"tkestack.io/kvass/pkg/shard"
 	"tkestack.io/kvass/pkg/shard/static"
+	"tkestack.io/kvass/pkg/state"
+	"tkestack.io/kvass/pkg/utils/types"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
 	"tkestack.io/kvass/pkg/coordinator"
 	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/election"
 	"tkestack.io/kvass/pkg/explore"
 	"tkestack.io/kvass/pkg/scrape"
 	k8s_shard "tkestack.io/kvass/pkg/shard/kubernetes"
@@ -57,12 +63,54 @@ var cdCfg = struct {
 	shardPort                 int
 	shardMaxHeadSeries        int64
 	shardMaxProcessSeries     int64
+	shardMaxMemoryBytes       int64
+	shardMaxSamplesRate       float64
+	shardMaxTargets           int64
+	shardMaxScrapeDuration    time.Duration
 	shardMinShard             int32
 	shardMaxShard             int32
 	shardMaxIdleTime          time.Duration
+	shardFailoverGrace        time.Duration
 	shardDisableAlleviate     bool
+	alleviateThresholds       string
+	alleviateHysteresis       float64
+	alleviateCooldown         time.Duration
 	shardDeletePVC            bool
+	shardBytesPerSeries       int64
+	shardMemoryLimitRatio     float64
+	shardPromContainer        string
+	scheduler                 string
+	affinityFile              string
+	quotaFile                 string
+	poolsFile                 string
+	tenantsFile               string
+	dryRun                    bool
+	maxEvents                 int
+	mirrorReplicas            bool
+	eventTrigger              bool
+	triggerSeriesChange       float64
+	minInterval               time.Duration
+	triggerDebounce           time.Duration
+	shardWatchInterval        time.Duration
+	maxTransferTargets        int
+	maxTransferSeries         int64
+	maxShardTransferTargets   int
+	maxShardTransferSeries    int64
+	electionEnabled           bool
+	electionLeaseName         string
+	electionLockFile          string
+	electionLeaseDuration     time.Duration
+	electionRenewDeadline     time.Duration
+	electionRetryPeriod       time.Duration
+	stateFile                 string
+	stateConfigMap            string
+	stateInterval             time.Duration
+	stateExploreTTL           time.Duration
 	exploreMaxCon             int
+	exploreCacheFile          string
+	exploreBySidecar          bool
+	exploreCacheTTL           time.Duration
+	exploreCacheInterval      time.Duration
 	scrapeKeepAliveDisable    bool
 	discoveryKeepAliveDisable bool
 	webAddress                string
@@ -75,6 +123,12 @@ var cdCfg = struct {
 func init() {
 	coordinatorCmd.Flags().BoolVar(&cdCfg.shardDisableAlleviate, "shard.disable-alleviate", false,
 		"disable shard alleviation when shard is overload")
+	coordinatorCmd.Flags().StringVar(&cdCfg.alleviateThresholds, "shard.alleviate-thresholds", "",
+		"tiers of shard alleviation formatted as maxSeriesRate:expectSeriesRate, e.g. 1.8:0,1.6:0.2,1.4:0.5,1.1:1 (the default)")
+	coordinatorCmd.Flags().Float64Var(&cdCfg.alleviateHysteresis, "shard.alleviate-hysteresis", 0,
+		"rate of max series that head series must drop below an alleviation tier before shard leave it")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.alleviateCooldown, "shard.alleviate-cooldown", 0,
+		"min interval between two alleviations of one shard")
 	coordinatorCmd.Flags().StringVar(&cdCfg.shardType, "shard.type", "k8s",
 		"type of shard deploy: 'k8s'(default), 'static'")
 	coordinatorCmd.Flags().StringVar(&cdCfg.shardStaticFile, "shard.static-file", "static-shards.yaml",
@@ -89,6 +143,14 @@ func init() {
 		"max head series of per shard, skipped if 0")
 	coordinatorCmd.Flags().Int64Var(&cdCfg.shardMaxProcessSeries, "shard.max-process-series", 1000000,
 		"max head series of per shard, can not be 0")
+	coordinatorCmd.Flags().Int64Var(&cdCfg.shardMaxMemoryBytes, "shard.max-memory-bytes", 0,
+		"max resident memory of per shard Prometheus, skipped if 0")
+	coordinatorCmd.Flags().Float64Var(&cdCfg.shardMaxSamplesRate, "shard.max-samples-per-second", 0,
+		"max ingested samples per second of per shard, head series of target divided by scrape interval of job, skipped if 0")
+	coordinatorCmd.Flags().Int64Var(&cdCfg.shardMaxTargets, "shard.max-targets", 0,
+		"max targets number of per shard, skipped if 0")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.shardMaxScrapeDuration, "shard.max-scrape-duration", 0,
+		"max cumulative last scrape duration of targets of per shard, skipped if 0")
 	coordinatorCmd.Flags().Int32Var(&cdCfg.shardMaxShard, "shard.max-shard", 999999,
 		"max shard number")
 	coordinatorCmd.Flags().Int32Var(&cdCfg.shardMinShard, "shard.min-shard", 0,
@@ -96,10 +158,90 @@ func init() {
 	coordinatorCmd.Flags().DurationVar(&cdCfg.shardMaxIdleTime, "shard.max-idle-time", 0,
 		"wait time before shard is removed after shard become idle,"+
 			"scale down is disabled if this flag is 0")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.shardFailoverGrace, "shard.failover-grace-period", 0,
+		"how long a shard can be unhealthy (not ready, unreachable or config not updated) "+
+			"before its targets are assigned to other shards, failover is disabled if 0")
 	coordinatorCmd.Flags().BoolVar(&cdCfg.shardDeletePVC, "shard.delete-pvc", true,
 		"kvass will delete pvc when shard is removed")
+	coordinatorCmd.Flags().Int64Var(&cdCfg.shardBytesPerSeries, "shard.bytes-per-series", 0,
+		"if shard.type=k8s, max head series of shard is memory limit of Prometheus container divided by this value, "+
+			"skipped if 0")
+	coordinatorCmd.Flags().Float64Var(&cdCfg.shardMemoryLimitRatio, "shard.memory-limit-ratio", 0,
+		"if shard.type=k8s, max memory of shard is memory limit of Prometheus container multiplied by this value, "+
+			"it takes precedence over shard.max-memory-bytes for shards whose container has memory limit, skipped if 0")
+	coordinatorCmd.Flags().StringVar(&cdCfg.shardPromContainer, "shard.prometheus-container", "prometheus",
+		"the name of Prometheus container in shard pod, which memory limit is used by shard.bytes-per-series and shard.memory-limit-ratio")
+	coordinatorCmd.Flags().StringVar(&cdCfg.scheduler, "coordinator.scheduler", "",
+		fmt.Sprintf("strategy to choose shard for targets: %s. "+
+			"if empty, 'first-fit' is used when shard.max-idle-time != 0, otherwise 'weighted-random' is used",
+			strings.Join(coordinator.Schedulers, ", ")))
+	coordinatorCmd.Flags().StringVar(&cdCfg.affinityFile, "coordinator.affinity-file", "",
+		"yaml file contains target affinity and anti-affinity rules, no rule is used if it is empty")
+	coordinatorCmd.Flags().StringVar(&cdCfg.quotaFile, "coordinator.quota-file", "",
+		"yaml file contains head series and targets quota rules of jobs or label values, "+
+			"no quota is used if it is empty, quotas in extra config take precedence")
+	coordinatorCmd.Flags().IntVar(&cdCfg.maxTransferTargets, "coordinator.max-transfer-targets", 0,
+		"max number of targets transferred to one replica during one coordinating, skipped if 0")
+	coordinatorCmd.Flags().Int64Var(&cdCfg.maxTransferSeries, "coordinator.max-transfer-series", 0,
+		"max head series of targets transferred to one replica during one coordinating, skipped if 0")
+	coordinatorCmd.Flags().IntVar(&cdCfg.maxShardTransferTargets, "coordinator.max-shard-transfer-targets", 0,
+		"max number of targets transferred from or to one shard during one coordinating, skipped if 0")
+	coordinatorCmd.Flags().Int64Var(&cdCfg.maxShardTransferSeries, "coordinator.max-shard-transfer-series", 0,
+		"max head series of targets transferred from or to one shard during one coordinating, skipped if 0")
+	coordinatorCmd.Flags().BoolVar(&cdCfg.dryRun, "coordinator.dry-run", false,
+		"compute all coordinating decisions without applying them to shards, see /api/v1/plan")
+	coordinatorCmd.Flags().BoolVar(&cdCfg.mirrorReplicas, "coordinator.mirror-replicas", false,
+		"place targets to the shard with the same index in every replica of a shard pool")
+	coordinatorCmd.Flags().IntVar(&cdCfg.maxEvents, "coordinator.max-events", 1000,
+		"max number of coordinating events kept in memory, see /api/v1/events")
+	coordinatorCmd.Flags().BoolVar(&cdCfg.eventTrigger, "coordinator.event-trigger", false,
+		"also coordinate when active targets are updated, targets are explored the first time or their series changed, or shards changed")
+	coordinatorCmd.Flags().Float64Var(&cdCfg.triggerSeriesChange, "coordinator.trigger-series-change", 0.2,
+		"explored target triggers coordinating if its series changed more than this ratio [coordinator.event-trigger must be true]")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.minInterval, "coordinator.min-interval", time.Second*5,
+		"min interval between two coordinating, triggered coordinating will wait for it")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.triggerDebounce, "coordinator.trigger-debounce", time.Second*2,
+		"triggered coordinating waits until no more trigger in this duration")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.shardWatchInterval, "coordinator.shard-watch-interval", time.Second*10,
+		"interval of checking shards changes (added, removed, readiness), coordinating is triggered if changed, skipped if 0 [coordinator.event-trigger must be true]")
+	coordinatorCmd.Flags().BoolVar(&cdCfg.electionEnabled, "election.enabled", false,
+		"enable leader election, only the leader coordinates shards, followers serve read-only APIs")
+	coordinatorCmd.Flags().StringVar(&cdCfg.electionLeaseName, "election.lease-name", "kvass-coordinator",
+		"name of Lease used for leader election in shard.namespace [shard.type must be 'k8s']")
+	coordinatorCmd.Flags().StringVar(&cdCfg.electionLockFile, "election.lock-file", "kvass-coordinator.lock",
+		"file locked by leader, should be shared by all coordinators [shard.type must be 'static']")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.electionLeaseDuration, "election.lease-duration", time.Second*15,
+		"duration that followers will wait to force acquire leadership")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.electionRenewDeadline, "election.renew-deadline", time.Second*10,
+		"duration that the leader will retry refreshing leadership before giving up")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.electionRetryPeriod, "election.retry-period", time.Second*2,
+		"duration between every acquiring or renewing of leadership")
+	coordinatorCmd.Flags().StringVar(&cdCfg.stateFile, "state.file", "",
+		"file that coordinator state is saved to and restored from, state is not persisted if it and state.configmap are empty")
+	coordinatorCmd.Flags().StringVar(&cdCfg.stateConfigMap, "state.configmap", "",
+		"ConfigMap in shard.namespace that coordinator state is saved to and restored from [shard.type must be 'k8s'], "+
+			"compressed state is limited to 1000KiB, scraping status, plan and explored results are dropped in turn if it is too large")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.stateInterval, "state.interval", time.Minute,
+		"the interval of saving coordinator state")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.stateExploreTTL, "state.explore-ttl", time.Hour*24,
+		"explored results in state explored before it are not restored, never expire if 0, "+
+			"restored targets are assigned without exploring and explored again in the background")
+	coordinatorCmd.Flags().StringVar(&cdCfg.poolsFile, "coordinator.pools-file", "",
+		"yaml file contains dedicated shard pools of jobs, all targets are placed to the default pool if it is empty")
+	coordinatorCmd.Flags().StringVar(&cdCfg.tenantsFile, "coordinator.tenants-file", "",
+		"yaml file contains tenants, each tenant has its own config file, shards, limits and apis under /tenants/{name}, "+
+			"config.file is used as the only tenant if it is empty")
 	coordinatorCmd.Flags().IntVar(&cdCfg.exploreMaxCon, "explore.concurrence", 200,
 		"max explore concurrence")
+	coordinatorCmd.Flags().BoolVar(&cdCfg.exploreBySidecar, "explore.by-sidecar", false,
+		"explore targets by sidecars of ready shards in turn, targets are explored locally if no sidecar is available")
+	coordinatorCmd.Flags().StringVar(&cdCfg.exploreCacheFile, "explore.cache-file", "",
+		"file that explored results of targets are saved to and loaded from, "+
+			"cached targets are assigned without exploring and explored again in the background, cache is disabled if it is empty")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.exploreCacheTTL, "explore.cache-ttl", time.Hour*24,
+		"explored results explored before it are dropped from cache, never expire if 0")
+	coordinatorCmd.Flags().DurationVar(&cdCfg.exploreCacheInterval, "explore.cache-interval", time.Minute,
+		"the interval of saving explore cache")
 	coordinatorCmd.Flags().BoolVar(&cdCfg.scrapeKeepAliveDisable, "scrape.disable-keep-alive", false,
 		"disable http keep alive")
 	coordinatorCmd.Flags().BoolVar(&cdCfg.discoveryKeepAliveDisable, "discovery.disable-keep-alive", false,
@@ -135,144 +277,423 @@ distribution targets to shards`,
 			return fmt.Errorf("shard.max-process-series can not be 0")
 		}
 
+		if cdCfg.shardMemoryLimitRatio < 0 || cdCfg.shardMemoryLimitRatio > 1 {
+			return fmt.Errorf("shard.memory-limit-ratio must be in [0, 1]")
+		}
+
+		if cdCfg.scheduler != "" && !types.FindString(cdCfg.scheduler, coordinator.Schedulers...) {
+			return fmt.Errorf("unknown coordinator.scheduler %s", cdCfg.scheduler)
+		}
+
+		var affinity []coordinator.AffinityRule
+		if cdCfg.affinityFile != "" {
+			rules, err := coordinator.LoadAffinityRules(cdCfg.affinityFile)
+			if err != nil {
+				return err
+			}
+			affinity = rules
+		}
+
+		var quotas []prom.QuotaRule
+		if cdCfg.quotaFile != "" {
+			rules, err := coordinator.LoadQuotaRules(cdCfg.quotaFile)
+			if err != nil {
+				return err
+			}
+			quotas = rules
+		}
+
+		thresholds, err := coordinator.ParseAlleviateThresholds(cdCfg.alleviateThresholds)
+		if err != nil {
+			return err
+		}
+
+		var pools []coordinator.PoolOption
+		if cdCfg.poolsFile != "" {
+			ps, err := coordinator.LoadPools(cdCfg.poolsFile)
+			if err != nil {
+				return err
+			}
+			pools = ps
+		}
+
 		level := &promlog.AllowedLevel{}
 		level.Set("info")
 		format := &promlog.AllowedFormat{}
 		format.Set("logfmt")
 
+		lg := logrus.New()
 		opt := make([]config_util.HTTPClientOption, 0)
 		if cdCfg.discoveryKeepAliveDisable {
 			opt = append(opt, config_util.WithKeepAlivesDisabled())
 		}
 
 		var (
-			lg     = logrus.New()
-			logger = promlog.New(&promlog.Config{
-				Level:  level,
-				Format: format,
-			})
-
-			scrapeManager          = scrape.New(cdCfg.scrapeKeepAliveDisable, lg.WithField("component", "scrape discovery"))
-			discoveryManagerScrape = prom_discovery.NewManager(context.Background(), log.With(logger, "component", "discovery manager scrape"), prom_discovery.Name("scrape"),
-				prom_discovery.HTTPClientOptions(opt...))
-			targetDiscovery = discovery.New(lg.WithField("component", "target discovery"))
-			exp             = explore.New(scrapeManager, promRegistry, lg.WithField("component", "explore"))
-			cfgManager      = prom.NewConfigManager()
-
-			cd = coordinator.NewCoordinator(
-				&coordinator.Option{
-					MaxHeadSeries:    cdCfg.shardMaxHeadSeries,
-					MaxProcessSeries: cdCfg.shardMaxProcessSeries,
-					MaxShard:         cdCfg.shardMaxShard,
-					MinShard:         cdCfg.shardMinShard,
-					MaxIdleTime:      cdCfg.shardMaxIdleTime,
-					Period:           cdCfg.syncInterval,
-					DisableAlleviate: cdCfg.shardDisableAlleviate,
-				},
-				getReplicasManager(lg),
-				cfgManager.ConfigInfo,
-				exp.Get,
-				targetDiscovery.ActiveTargetsByHash,
-				promRegistry,
-				lg.WithField("component", "coordinator"))
+			isLeader  func() bool
+			getLeader func() string
 		)
+		elector := getElector(lg)
+		if elector != nil {
+			isLeader = elector.IsLeader
+			getLeader = elector.Leader
+		}
 
-		cfgManager.AddReloadCallbacks(
-			func(cfg *prom.ConfigInfo) error {
-				return configInject(cfg.Config, &cdCfg.configInject)
-			},
-			scrapeManager.ApplyConfig,
-			exp.ApplyConfig,
-			targetDiscovery.ApplyConfig,
-			func(cfg *prom.ConfigInfo) error {
-				c := make(map[string]prom_discovery.Configs)
-				for _, v := range cfg.Config.ScrapeConfigs {
-					c[v.JobName] = v.ServiceDiscoveryConfigs
-				}
-				return discoveryManagerScrape.ApplyConfig(c)
-			},
-		)
+		logger := promlog.New(&promlog.Config{
+			Level:  level,
+			Format: format,
+		})
 
-		svc := coordinator.NewService(
-			cdCfg.configFile,
-			cfgManager,
-			cd.LastScrapeStatistics,
-			cd.LastGlobalScrapeStatus,
-			targetDiscovery.ActiveTargets,
-			targetDiscovery.DropTargets,
-			promRegistry,
-			lg.WithField("component", "web"),
-		)
+		option := &coordinator.Option{
+			MaxHeadSeries:     cdCfg.shardMaxHeadSeries,
+			MaxProcessSeries:  cdCfg.shardMaxProcessSeries,
+			MaxMemoryBytes:    cdCfg.shardMaxMemoryBytes,
+			MaxSamplesRate:    cdCfg.shardMaxSamplesRate,
+			MaxTargets:        cdCfg.shardMaxTargets,
+			MaxScrapeDuration: cdCfg.shardMaxScrapeDuration,
+			MaxShard:          cdCfg.shardMaxShard,
+			MinShard:          cdCfg.shardMinShard,
+			MaxIdleTime:       cdCfg.shardMaxIdleTime,
+			Period:            cdCfg.syncInterval,
+			DisableAlleviate:  cdCfg.shardDisableAlleviate,
+			Scheduler:         cdCfg.scheduler,
+			Affinity:          affinity,
+			Quotas:            quotas,
+			Pools:             pools,
+			DryRun:            cdCfg.dryRun,
+			MaxEvents:         cdCfg.maxEvents,
+			MirrorReplicas:    cdCfg.mirrorReplicas,
+
+			EventTrigger:       cdCfg.eventTrigger,
+			MinInterval:        cdCfg.minInterval,
+			TriggerDebounce:    cdCfg.triggerDebounce,
+			ShardWatchInterval: cdCfg.shardWatchInterval,
+
+			FailoverGracePeriod: cdCfg.shardFailoverGrace,
+
+			MaxTransferTargets:      cdCfg.maxTransferTargets,
+			MaxTransferSeries:       cdCfg.maxTransferSeries,
+			MaxShardTransferTargets: cdCfg.maxShardTransferTargets,
+			MaxShardTransferSeries:  cdCfg.maxShardTransferSeries,
+
+			Alleviate: prom.AlleviateConfig{
+				Thresholds: thresholds,
+				Hysteresis: cdCfg.alleviateHysteresis,
+				Cooldown:   model.Duration(cdCfg.alleviateCooldown),
+			},
+		}
 
-		if err := cfgManager.ReloadFromFile(cdCfg.configFile); err != nil {
-			panic(err)
+		tenants := []coordinator.TenantOption{{
+			ConfigFile:     cdCfg.configFile,
+			StateFile:      cdCfg.stateFile,
+			StateConfigMap: cdCfg.stateConfigMap,
+
+			ExploreCacheFile: cdCfg.exploreCacheFile,
+		}}
+		if cdCfg.tenantsFile != "" {
+			ts, err := coordinator.LoadTenants(cdCfg.tenantsFile)
+			if err != nil {
+				return err
+			}
+			tenants = ts
 		}
 
 		g := errgroup.Group{}
 		ctx := context.Background()
 
-		g.Go(func() error {
-			lg.Infof("SD start")
-			return discoveryManagerScrape.Run()
-		})
+		services := map[string]*coordinator.Service{}
+		for i := range tenants {
+			t := &tenants[i]
+			tlg := logrus.FieldLogger(lg)
+			svcRegistry := promRegistry
+			if cdCfg.tenantsFile != "" {
+				tlg = lg.WithField("tenant", t.Name)
+				// every tenant api server has its own http metrics, which are served at /tenants/{name}/metrics
+				svcRegistry = prometheus.NewRegistry()
+			}
+			tOption, err := t.Option(option)
+			if err != nil {
+				return err
+			}
+			services[t.Name] = runTenant(ctx, &g, t, tOption, isLeader, getLeader, opt, svcRegistry, logger, tlg)
+		}
 
-		g.Go(func() error {
-			lg.Infof("targetDiscovery start")
-			return targetDiscovery.Run(ctx, discoveryManagerScrape.SyncCh())
-		})
+		if elector != nil {
+			g.Go(func() error {
+				lg.Infof("leader election start")
+				return elector.Run(ctx)
+			})
+		}
 
 		g.Go(func() error {
-			for {
-				ts := <-targetDiscovery.ActiveTargetsChan()
-				exp.UpdateTargets(ts)
+			lg.Infof("api start at %s", cdCfg.webAddress)
+			if cdCfg.tenantsFile == "" {
+				return services[""].Run(cdCfg.webAddress)
 			}
+			return coordinator.NewTenantsService(services, promRegistry, lg.WithField("component", "web")).Run(cdCfg.webAddress)
 		})
 
-		g.Go(func() error {
-			lg.Infof("explore start")
-			return exp.Run(ctx, cdCfg.exploreMaxCon)
-		})
+		return g.Wait()
+	},
+}
 
-		tCtx, cancel := context.WithTimeout(ctx, cdCfg.sdInitTimeout)
-		defer cancel()
-		if err := targetDiscovery.WaitInit(tCtx); err != nil {
-			panic(err)
+// runTenant build the whole coordinating pipeline of one Prometheus configuration and start it in "g"
+// the api server of this tenant is returned, which should be started by caller
+func runTenant(
+	ctx context.Context,
+	g *errgroup.Group,
+	tenant *coordinator.TenantOption,
+	option *coordinator.Option,
+	isLeader func() bool,
+	getLeader func() string,
+	opt []config_util.HTTPClientOption,
+	svcRegistry *prometheus.Registry,
+	logger log.Logger,
+	lg logrus.FieldLogger,
+) *coordinator.Service {
+	sdName := "scrape"
+	if tenant.Name != "" {
+		sdName = "scrape-" + tenant.Name
+	}
+
+	selector := cdCfg.shardSelector
+	if tenant.ShardSelector != "" {
+		selector = tenant.ShardSelector
+	}
+
+	staticFile := cdCfg.shardStaticFile
+	if tenant.ShardStaticFile != "" {
+		staticFile = tenant.ShardStaticFile
+	}
+
+	// coordinator metrics of every tenant are distinguished by the "tenant" label
+	cdRegisterer := prometheus.Registerer(promRegistry)
+	if tenant.Name != "" {
+		cdRegisterer = prometheus.WrapRegistererWith(prometheus.Labels{"tenant": tenant.Name}, promRegistry)
+	}
+
+	var (
+		scrapeManager          = scrape.New(cdCfg.scrapeKeepAliveDisable, lg.WithField("component", "scrape discovery"))
+		discoveryManagerScrape = prom_discovery.NewManager(ctx, log.With(logger, "component", "discovery manager scrape"), prom_discovery.Name(sdName),
+			prom_discovery.HTTPClientOptions(opt...))
+		targetDiscovery = discovery.New(lg.WithField("component", "target discovery"))
+		exp             = explore.New(scrapeManager, cdRegisterer, lg.WithField("component", "explore"))
+		cfgManager      = prom.NewConfigManager()
+
+		cd = coordinator.NewCoordinator(
+			option,
+			getReplicasManager(selector, staticFile, lg),
+			cfgManager.ConfigInfo,
+			exp.Get,
+			targetDiscovery.ActiveTargetsByHash,
+			isLeader,
+			cdRegisterer,
+			lg.WithField("component", "coordinator"))
+	)
+
+	cfgManager.AddReloadCallbacks(
+		func(cfg *prom.ConfigInfo) error {
+			return configInject(cfg.Config, &cdCfg.configInject)
+		},
+		scrapeManager.ApplyConfig,
+		exp.ApplyConfig,
+		targetDiscovery.ApplyConfig,
+		func(cfg *prom.ConfigInfo) error {
+			c := make(map[string]prom_discovery.Configs)
+			for _, v := range cfg.Config.ScrapeConfigs {
+				c[v.JobName] = v.ServiceDiscoveryConfigs
+			}
+			return discoveryManagerScrape.ApplyConfig(c)
+		},
+	)
+
+	exp.SetChangeRatio(cdCfg.triggerSeriesChange)
+	exp.AddExploredCallbacks(func(hash uint64) {
+		cd.Trigger("target explored")
+	})
+
+	if cdCfg.exploreBySidecar {
+		exp.SetDelegate(cd.ExploreBySidecar)
+	}
+
+	stateManager := coordinator.NewStateManager(
+		getStateStore(tenant.StateFile, tenant.StateConfigMap),
+		cd,
+		exp.Results,
+		exp.Restore,
+		cdCfg.stateInterval,
+		cdCfg.stateExploreTTL,
+		lg.WithField("component", "state"),
+	)
+
+	svc := coordinator.NewService(coordinator.ServiceOption{
+		ConfigFile:              tenant.ConfigFile,
+		ConfigManager:           cfgManager,
+		PromRegistry:            svcRegistry,
+		IsLeader:                isLeader,
+		GetLeader:               getLeader,
+		GetLastScrapeStatistics: cd.LastScrapeStatistics,
+		GetScrapeStatus:         cd.LastGlobalScrapeStatus,
+		GetActiveTargets:        targetDiscovery.ActiveTargets,
+		GetDropTargets:          targetDiscovery.DropTargets,
+		GetUnassigned:           cd.LastUnassigned,
+		GetPlan:                 cd.LastPlan,
+		GetEvents:               cd.Events,
+		ExportState:             stateManager.Export,
+		ImportState:             stateManager.Import,
+		MoveTarget:              cd.MoveTarget,
+		UnpinTarget:             cd.UnpinTarget,
+		GetPins:                 cd.Pins,
+		CordonShard:             cd.CordonShard,
+		UncordonShard:           cd.UncordonShard,
+		GetCordons:              cd.Cordons,
+	}, lg.WithField("component", "web"))
+
+	if err := cfgManager.ReloadFromFile(tenant.ConfigFile); err != nil {
+		panic(err)
+	}
+
+	if err := stateManager.Restore(); err != nil {
+		lg.Errorf("restore state failed: %s", err.Error())
+	}
+
+	if tenant.ExploreCacheFile != "" {
+		cache := explore.NewCache(state.NewFileStore(tenant.ExploreCacheFile),
+			exp,
+			cdCfg.exploreCacheTTL,
+			cdCfg.exploreCacheInterval,
+			lg.WithField("component", "explore cache"))
+		if err := cache.Load(); err != nil {
+			lg.Errorf("load explore cache failed: %s", err.Error())
 		}
 
 		g.Go(func() error {
-			lg.Infof("coordinator start")
-			return cd.Run(ctx)
+			lg.Infof("explore cache start")
+			return cache.Run(ctx)
 		})
+	}
 
-		g.Go(func() error {
-			lg.Infof("api start at %s", cdCfg.webAddress)
-			return svc.Run(cdCfg.webAddress)
-		})
+	g.Go(func() error {
+		lg.Infof("SD start")
+		return discoveryManagerScrape.Run()
+	})
+
+	g.Go(func() error {
+		lg.Infof("targetDiscovery start")
+		return targetDiscovery.Run(ctx, discoveryManagerScrape.SyncCh())
+	})
+
+	g.Go(func() error {
+		for {
+			ts := <-targetDiscovery.ActiveTargetsChan()
+			exp.UpdateTargets(ts)
+			cd.Trigger("active targets updated")
+		}
+	})
 
-		return g.Wait()
-	},
+	g.Go(func() error {
+		lg.Infof("explore start")
+		return exp.Run(ctx, cdCfg.exploreMaxCon)
+	})
+
+	tCtx, cancel := context.WithTimeout(ctx, cdCfg.sdInitTimeout)
+	defer cancel()
+	if err := targetDiscovery.WaitInit(tCtx); err != nil {
+		panic(err)
+	}
+
+	g.Go(func() error {
+		lg.Infof("coordinator start")
+		return cd.Run(ctx)
+	})
+
+	g.Go(func() error {
+		lg.Infof("state manager start")
+		return stateManager.Run(ctx)
+	})
+	return svc
+}
+
+func getKubernetesClient() kubernetes.Interface {
+	kcfg, err := rest.InClusterConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	cli, err := kubernetes.NewForConfig(kcfg)
+	if err != nil {
+		panic(err)
+	}
+	return cli
 }
 
-func getReplicasManager(lg logrus.FieldLogger) shard.ReplicasManager {
+func getElector(lg logrus.FieldLogger) election.Elector {
+	if !cdCfg.electionEnabled {
+		return nil
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		panic(err)
+	}
+
 	switch cdCfg.shardType {
 	case "k8s":
-		kcfg, err := rest.InClusterConfig()
+		e, err := election.NewLeaseElector(getKubernetesClient(),
+			cdCfg.shardNamespace,
+			cdCfg.electionLeaseName,
+			identity,
+			&election.LeaseOption{
+				LeaseDuration: cdCfg.electionLeaseDuration,
+				RenewDeadline: cdCfg.electionRenewDeadline,
+				RetryPeriod:   cdCfg.electionRetryPeriod,
+			},
+			promRegistry,
+			lg.WithField("component", "election"))
 		if err != nil {
 			panic(err)
 		}
+		return e
+	case "static":
+		return election.NewFileElector(cdCfg.electionLockFile,
+			identity,
+			cdCfg.electionRetryPeriod,
+			promRegistry,
+			lg.WithField("component", "election"))
+	default:
+		panic(fmt.Sprintf("unknown shard.type %s", cdCfg.shardType))
+	}
+}
 
-		cli, err := kubernetes.NewForConfig(kcfg)
-		if err != nil {
-			panic(err)
-		}
-		return k8s_shard.NewReplicasManager(cli, cdCfg.shardNamespace,
-			cdCfg.shardSelector,
+func getStateStore(file, configMap string) state.Store {
+	if file != "" {
+		return state.NewFileStore(file)
+	}
+
+	if configMap != "" {
+		return state.NewConfigMapStore(getKubernetesClient(), cdCfg.shardNamespace, configMap)
+	}
+	return nil
+}
+
+func getReplicasManager(selector, staticFile string, lg logrus.FieldLogger) shard.ReplicasManager {
+	switch cdCfg.shardType {
+	case "k8s":
+		m := k8s_shard.NewReplicasManager(getKubernetesClient(), cdCfg.shardNamespace,
+			selector,
 			cdCfg.shardPort,
 			cdCfg.shardDeletePVC,
 			lg.WithField("component", "shard manager"))
+		if cdCfg.shardBytesPerSeries != 0 || cdCfg.shardMemoryLimitRatio != 0 {
+			m.SetMemoryCapacity(&k8s_shard.MemoryCapacity{
+				Container:      cdCfg.shardPromContainer,
+				BytesPerSeries: cdCfg.shardBytesPerSeries,
+				LimitRatio:     cdCfg.shardMemoryLimitRatio,
+			})
+		}
+		return m
 
 	case "static":
-		return static.NewReplicasManager(cdCfg.shardStaticFile, lg.WithField("component", "shard manager"))
+		return static.NewReplicasManager(staticFile, lg.WithField("component", "shard manager"))
 	default:
 		panic(fmt.Sprintf("unknown shard.type %s", cdCfg.shardType))
 	}
@@ -39,6 +39,7 @@ var sidecarCfg = struct {
 	storePath              string
 	injectProxyURL         string
 	fetchHeadSeries        bool
+	fetchMemory            bool
 	configInject           configInjectOption
 	scrapeKeepAliveDisable bool
 	shardMonitor           bool
@@ -61,6 +62,8 @@ func init() {
 		"proxy url to inject to all job")
 	sidecarCmd.Flags().StringVar(&sidecarCfg.configInject.kubernetes.serviceAccountPath, "inject.kubernetes-sa-path", "",
 		"change default service account token path")
+	sidecarCmd.Flags().BoolVar(&sidecarCfg.fetchMemory, "shard.fetch-memory", true,
+		"if true, resident and heap memory of prometheus got from its /metrics will be reported in runtimeinfo")
 	sidecarCmd.Flags().BoolVar(&sidecarCfg.fetchHeadSeries, "shard.fetch-head-series", true,
 		"if true, prometheus head series will be used as runtimeinfo.HeadSeries."+
 			"otherwise, the sum of all scraping targets series will be used."+
@@ -143,8 +146,15 @@ var sidecarCmd = &cobra.Command{
 
 				return ts.HeadStats.NumSeries, nil
 			},
+			func() (*prom.MemoryInfo, error) {
+				if !sidecarCfg.fetchMemory {
+					return &prom.MemoryInfo{}, nil
+				}
+				return promCli.MemoryInfo()
+			},
 			configManager,
 			targetManager,
+			scrapeManager.GetJob,
 			promRegistry,
 			log.WithField("component", "web"),
 		)
@@ -81,6 +81,8 @@ const (
 	ErrorBadData ErrorType = "bad_data"
 	// ErrorInternal indicate that result is failed because the request data may be right but the server is something wrong
 	ErrorInternal ErrorType = "internal"
+	// ErrorNotLeader indicate that result is failed because the request must be handled by the leader
+	ErrorNotLeader ErrorType = "not_leader"
 )
 
 // Helper provider some function to build a service
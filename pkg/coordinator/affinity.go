@@ -0,0 +1,190 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/target"
+	"tkestack.io/kvass/pkg/utils/types"
+)
+
+// AffinityRule group targets by label values
+// targets in the same group will be placed to the same shard or spread to different shards in preference
+type AffinityRule struct {
+	// Jobs limit this rule to targets of these jobs, targets of all jobs are selected if it is empty
+	Jobs []string `yaml:"jobs,omitempty" json:"jobs,omitempty"`
+	// Labels is the label names used to group targets, targets with same values of all labels are in the same group
+	// target that has not all of these labels is not affected by this rule
+	Labels []string `yaml:"labels" json:"labels"`
+	// Anti indicate that targets in the same group should be spread to different shards
+	// otherwise, targets in the same group should be placed to the same shard
+	Anti bool `yaml:"anti,omitempty" json:"anti,omitempty"`
+}
+
+// LoadAffinityRules load affinity rules from a yaml file
+func LoadAffinityRules(file string) ([]AffinityRule, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read affinity file")
+	}
+
+	rules := make([]AffinityRule, 0)
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, errors.Wrapf(err, "wrong format of affinity file")
+	}
+
+	for i, r := range rules {
+		if len(r.Labels) == 0 {
+			return nil, errors.Errorf("labels of affinity rule %d is empty", i)
+		}
+	}
+	return rules, nil
+}
+
+type affinityKey struct {
+	rule  int
+	value string
+}
+
+// affinity record which shards targets of each group are placed to during one coordination
+type affinity struct {
+	rules      []AffinityRule
+	targetKeys map[uint64][]affinityKey
+	members    map[affinityKey]map[*shardInfo]int
+}
+
+func newAffinity(rules []AffinityRule) *affinity {
+	return &affinity{
+		rules:      rules,
+		targetKeys: map[uint64][]affinityKey{},
+		members:    map[affinityKey]map[*shardInfo]int{},
+	}
+}
+
+// reset rebuild groups of all active targets according to current scraping targets of shards
+func (a *affinity) reset(shards []*shardInfo, active map[uint64]*discovery.SDTargets) {
+	a.targetKeys = map[uint64][]affinityKey{}
+	a.members = map[affinityKey]map[*shardInfo]int{}
+	if len(a.rules) == 0 {
+		return
+	}
+
+	for hash, tar := range active {
+		if keys := a.keys(tar); len(keys) != 0 {
+			a.targetKeys[hash] = keys
+		}
+	}
+
+	for _, s := range shards {
+		for hash, st := range s.scraping {
+			// in_transfer target is leaving this shard
+			if st.TargetState == target.StateInTransfer {
+				continue
+			}
+			a.add(s, hash)
+		}
+	}
+}
+
+func (a *affinity) keys(tar *discovery.SDTargets) []affinityKey {
+	ret := make([]affinityKey, 0)
+l1:
+	for i, r := range a.rules {
+		if len(r.Jobs) != 0 && !types.FindString(tar.Job, r.Jobs...) {
+			continue
+		}
+
+		values := make([]string, 0, len(r.Labels))
+		for _, name := range r.Labels {
+			v := tar.ShardTarget.Labels.Get(name)
+			if v == "" {
+				continue l1
+			}
+			values = append(values, v)
+		}
+		ret = append(ret, affinityKey{rule: i, value: strings.Join(values, "\xff")})
+	}
+	return ret
+}
+
+// add mark that target is placed to shard s
+func (a *affinity) add(s *shardInfo, hash uint64) {
+	for _, k := range a.targetKeys[hash] {
+		if a.members[k] == nil {
+			a.members[k] = map[*shardInfo]int{}
+		}
+		a.members[k][s]++
+	}
+}
+
+// remove mark that target is not placed to shard s any more
+func (a *affinity) remove(s *shardInfo, hash uint64) {
+	for _, k := range a.targetKeys[hash] {
+		if a.members[k][s] == 0 {
+			continue
+		}
+
+		a.members[k][s]--
+		if a.members[k][s] == 0 {
+			delete(a.members[k], s)
+		}
+	}
+}
+
+// filter return the candidates that target should be placed to according to rules
+// rules are preferences, candidates will not be filtered to empty
+func (a *affinity) filter(candidates []*shardInfo, hash uint64) []*shardInfo {
+	for _, k := range a.targetKeys[hash] {
+		if len(candidates) == 0 {
+			return candidates
+		}
+
+		members := a.members[k]
+		ret := make([]*shardInfo, 0, len(candidates))
+		if a.rules[k.rule].Anti {
+			for _, s := range candidates {
+				if members[s] == 0 {
+					ret = append(ret, s)
+				}
+			}
+		} else {
+			max := 0
+			for _, s := range candidates {
+				if members[s] > max {
+					max = members[s]
+				}
+			}
+
+			for _, s := range candidates {
+				if max != 0 && members[s] == max {
+					ret = append(ret, s)
+				}
+			}
+		}
+
+		if len(ret) != 0 {
+			candidates = ret
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,208 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func TestLoadAffinityRules(t *testing.T) {
+	var cases = []struct {
+		name        string
+		content     string
+		wantRules   int
+		wantErr     bool
+		notFoundErr bool
+	}{
+		{
+			name: "success",
+			content: `
+- labels: [node]
+- jobs: [job1]
+  labels: [service]
+  anti: true
+`,
+			wantRules: 2,
+		},
+		{
+			name:    "empty labels",
+			content: `- jobs: [job1]`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong format",
+			content: `a: b`,
+			wantErr: true,
+		},
+		{
+			name:        "file not exist",
+			wantErr:     true,
+			notFoundErr: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			file := t.TempDir() + "/affinity.yaml"
+			if !cs.notFoundErr {
+				r.NoError(ioutil.WriteFile(file, []byte(cs.content), 0644))
+			}
+
+			rules, err := LoadAffinityRules(file)
+			if cs.wantErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			r.Equal(cs.wantRules, len(rules))
+		})
+	}
+}
+
+func TestAffinity_Filter(t *testing.T) {
+	sdTarget := func(hash uint64, job string, lbs ...string) *discovery.SDTargets {
+		return &discovery.SDTargets{
+			Job: job,
+			ShardTarget: &target.Target{
+				Hash:   hash,
+				Labels: labels.FromStrings(lbs...),
+			},
+		}
+	}
+
+	active := map[uint64]*discovery.SDTargets{
+		1: sdTarget(1, "job1", "node", "n1"),
+		2: sdTarget(2, "job2", "node", "n1"),
+		3: sdTarget(3, "job1", "node", "n2"),
+		4: sdTarget(4, "job1"),
+	}
+
+	var cases = []struct {
+		name      string
+		rules     []AffinityRule
+		hash      uint64
+		wantIndex []int
+	}{
+		{
+			name:      "no rules",
+			hash:      2,
+			wantIndex: []int{0, 1, 2},
+		},
+		{
+			name:      "affinity, place with same group",
+			rules:     []AffinityRule{{Labels: []string{"node"}}},
+			hash:      2,
+			wantIndex: []int{0},
+		},
+		{
+			name:      "affinity, no member placed",
+			rules:     []AffinityRule{{Labels: []string{"node"}}},
+			hash:      3,
+			wantIndex: []int{0, 1, 2},
+		},
+		{
+			name:      "affinity, label not exist",
+			rules:     []AffinityRule{{Labels: []string{"node"}}},
+			hash:      4,
+			wantIndex: []int{0, 1, 2},
+		},
+		{
+			name:      "affinity, job not selected",
+			rules:     []AffinityRule{{Labels: []string{"node"}, Jobs: []string{"job1"}}},
+			hash:      2,
+			wantIndex: []int{0, 1, 2},
+		},
+		{
+			name:      "anti affinity, spread from same group",
+			rules:     []AffinityRule{{Labels: []string{"node"}, Anti: true}},
+			hash:      2,
+			wantIndex: []int{1, 2},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			shards := []*shardInfo{
+				newTestingShardInfo("0", 0),
+				newTestingShardInfo("1", 0),
+				newTestingShardInfo("2", 0),
+			}
+			for _, s := range shards {
+				s.scraping = map[uint64]*target.ScrapeStatus{}
+			}
+			shards[0].scraping[1] = target.NewScrapeStatus(0, 0)
+
+			a := newAffinity(cs.rules)
+			a.reset(shards, active)
+			want := make([]*shardInfo, 0)
+			for _, i := range cs.wantIndex {
+				want = append(want, shards[i])
+			}
+			r.Equal(want, a.filter(shards, cs.hash))
+		})
+	}
+}
+
+func TestAffinity_FilterNotEmpty(t *testing.T) {
+	r := require.New(t)
+	shards := []*shardInfo{newTestingShardInfo("0", 0)}
+	shards[0].scraping = map[uint64]*target.ScrapeStatus{1: target.NewScrapeStatus(0, 0)}
+	active := map[uint64]*discovery.SDTargets{
+		1: {ShardTarget: &target.Target{Hash: 1, Labels: labels.FromStrings("node", "n1")}},
+		2: {ShardTarget: &target.Target{Hash: 2, Labels: labels.FromStrings("node", "n1")}},
+	}
+
+	a := newAffinity([]AffinityRule{{Labels: []string{"node"}, Anti: true}})
+	a.reset(shards, active)
+	// anti affinity is a preference, the only candidate is still returned
+	r.Equal(shards, a.filter(shards, 2))
+}
+
+func TestAffinity_Remove(t *testing.T) {
+	r := require.New(t)
+	shards := []*shardInfo{newTestingShardInfo("0", 0), newTestingShardInfo("1", 0)}
+	shards[0].scraping = map[uint64]*target.ScrapeStatus{1: target.NewScrapeStatus(0, 0)}
+	shards[1].scraping = map[uint64]*target.ScrapeStatus{}
+	active := map[uint64]*discovery.SDTargets{
+		1: {ShardTarget: &target.Target{Hash: 1, Labels: labels.FromStrings("node", "n1")}},
+		2: {ShardTarget: &target.Target{Hash: 2, Labels: labels.FromStrings("node", "n1")}},
+	}
+
+	a := newAffinity([]AffinityRule{{Labels: []string{"node"}}})
+	a.reset(shards, active)
+	r.Equal(shards[:1], a.filter(shards, 2))
+
+	// target 1 is transferred to shard 1, shard 0 does not hold the group any more
+	a.remove(shards[0], 1)
+	a.add(shards[1], 1)
+	r.Equal(shards[1:], a.filter(shards, 2))
+
+	// in_transfer target is not counted
+	shards[0].scraping[1].TargetState = target.StateInTransfer
+	shards[1].scraping[1] = target.NewScrapeStatus(0, 0)
+	a.reset(shards, active)
+	r.Equal(shards[1:], a.filter(shards, 2))
+}
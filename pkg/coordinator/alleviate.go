@@ -0,0 +1,140 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"tkestack.io/kvass/pkg/prom"
+)
+
+// DefaultAlleviateThresholds is the default tiers of shard alleviation
+var DefaultAlleviateThresholds = []prom.AlleviateThreshold{
+	{MaxSeriesRate: 1.8, ExpectSeriesRate: 0},
+	{MaxSeriesRate: 1.6, ExpectSeriesRate: 0.2},
+	{MaxSeriesRate: 1.4, ExpectSeriesRate: 0.5},
+	{MaxSeriesRate: 1.1, ExpectSeriesRate: 1},
+}
+
+// ParseAlleviateThresholds parse alleviation tiers from string like "1.8:0,1.6:0.2"
+// every tier is formatted as "maxSeriesRate:expectSeriesRate"
+func ParseAlleviateThresholds(s string) ([]prom.AlleviateThreshold, error) {
+	ret := make([]prom.AlleviateThreshold, 0)
+	if strings.TrimSpace(s) == "" {
+		return ret, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		rates := strings.Split(strings.TrimSpace(item), ":")
+		if len(rates) != 2 {
+			return nil, errors.Errorf("wrong format of alleviate threshold %s", item)
+		}
+
+		maxRate, err := strconv.ParseFloat(rates[0], 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "wrong max series rate of %s", item)
+		}
+
+		expRate, err := strconv.ParseFloat(rates[1], 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "wrong expect series rate of %s", item)
+		}
+
+		if expRate >= maxRate {
+			return nil, errors.Errorf("expect series rate must be less than max series rate, %s", item)
+		}
+
+		ret = append(ret, prom.AlleviateThreshold{MaxSeriesRate: maxRate, ExpectSeriesRate: expRate})
+	}
+	return ret, nil
+}
+
+// alleviateState is the alleviation state of one shard, kept between coordinating
+type alleviateState struct {
+	// maxSeriesRate is the MaxSeriesRate of the tier shard in, 0 if shard is not in any tier
+	maxSeriesRate float64
+	// lastAlleviateAt is the last time shard is alleviated
+	lastAlleviateAt time.Time
+}
+
+// alleviateConfig return the config of shard alleviation, config from ExtraConfig is preferred
+// tiers are sorted by MaxSeriesRate in descending order
+func (c *Coordinator) alleviateConfig() *prom.AlleviateConfig {
+	ret := c.option.Alleviate
+	if c.getConfig != nil {
+		if cfg := c.getConfig(); cfg != nil && cfg.ExtraConfig != nil && cfg.ExtraConfig.Alleviate != nil {
+			ret = *cfg.ExtraConfig.Alleviate
+		}
+	}
+
+	if len(ret.Thresholds) == 0 {
+		ret.Thresholds = DefaultAlleviateThresholds
+	}
+
+	ret.Thresholds = append([]prom.AlleviateThreshold{}, ret.Thresholds...)
+	sort.Slice(ret.Thresholds, func(i, j int) bool {
+		return ret.Thresholds[i].MaxSeriesRate > ret.Thresholds[j].MaxSeriesRate
+	})
+	return &ret
+}
+
+// markAlleviating find the alleviation tier of every shard
+// shard stays in its last tier until head series drop below the hysteresis band
+// shard in any tier or in cooldown receives no new target
+func (c *Coordinator) markAlleviating(shards []*shardInfo, cfg *prom.AlleviateConfig) {
+	if c.alleviateStates == nil {
+		c.alleviateStates = map[string]*alleviateState{}
+	}
+
+	for _, s := range shards {
+		st := c.alleviateStates[s.shard.ID]
+		if st == nil {
+			st = &alleviateState{}
+			c.alleviateStates[s.shard.ID] = st
+		}
+
+		s.threshold = nil
+		if s.maxHeadSeries != 0 {
+			for i := range cfg.Thresholds {
+				t := &cfg.Thresholds[i]
+				rate := t.MaxSeriesRate
+				if rate <= st.maxSeriesRate {
+					rate -= cfg.Hysteresis
+				}
+
+				if s.runtime.HeadSeries >= seriesWithRate(s.maxHeadSeries, rate) {
+					s.threshold = t
+					break
+				}
+			}
+		}
+
+		st.maxSeriesRate = 0
+		if s.threshold != nil {
+			st.maxSeriesRate = s.threshold.MaxSeriesRate
+		}
+		c.metrics.setGauge(c.metrics.shardAlleviateTier, st.maxSeriesRate, s.shard.ID)
+
+		cooling := cfg.Cooldown != 0 && time.Since(st.lastAlleviateAt) < time.Duration(cfg.Cooldown)
+		s.alleviating = !c.option.DisableAlleviate && (s.threshold != nil || cooling)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/prom"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func TestParseAlleviateThresholds(t *testing.T) {
+	var cases = []struct {
+		name    string
+		value   string
+		want    []prom.AlleviateThreshold
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  []prom.AlleviateThreshold{},
+		},
+		{
+			name:  "success",
+			value: "1.8:0, 1.2:0.9",
+			want: []prom.AlleviateThreshold{
+				{MaxSeriesRate: 1.8, ExpectSeriesRate: 0},
+				{MaxSeriesRate: 1.2, ExpectSeriesRate: 0.9},
+			},
+		},
+		{
+			name:    "wrong format",
+			value:   "1.8",
+			wantErr: true,
+		},
+		{
+			name:    "not number",
+			value:   "a:1",
+			wantErr: true,
+		},
+		{
+			name:    "expect rate too big",
+			value:   "1.1:1.2",
+			wantErr: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			ret, err := ParseAlleviateThresholds(cs.value)
+			if cs.wantErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			r.Equal(cs.want, ret)
+		})
+	}
+}
+
+func TestCoordinator_AlleviateConfig(t *testing.T) {
+	r := require.New(t)
+	extra := &prom.ExtraConfig{}
+	c := newTestingMoveCoordinator()
+	c.option.Alleviate = prom.AlleviateConfig{
+		Thresholds: []prom.AlleviateThreshold{{MaxSeriesRate: 1.2}, {MaxSeriesRate: 1.5}},
+		Hysteresis: 0.1,
+	}
+	c.getConfig = func() *prom.ConfigInfo {
+		return &prom.ConfigInfo{ExtraConfig: extra}
+	}
+
+	cfg := c.alleviateConfig()
+	r.Equal(0.1, cfg.Hysteresis)
+	r.Equal(1.5, cfg.Thresholds[0].MaxSeriesRate)
+
+	// ExtraConfig is preferred
+	extra.Alleviate = &prom.AlleviateConfig{Cooldown: model.Duration(time.Minute)}
+	cfg = c.alleviateConfig()
+	r.Equal(0.0, cfg.Hysteresis)
+	r.Equal(model.Duration(time.Minute), cfg.Cooldown)
+	r.Equal(DefaultAlleviateThresholds, cfg.Thresholds)
+}
+
+func TestCoordinator_MarkAlleviating(t *testing.T) {
+	cfg := &prom.AlleviateConfig{
+		Thresholds: []prom.AlleviateThreshold{{MaxSeriesRate: 1.1, ExpectSeriesRate: 0.9}},
+		Hysteresis: 0.2,
+	}
+
+	var cases = []struct {
+		name            string
+		lastRate        float64
+		lastAlleviateAt time.Time
+		cooldown        time.Duration
+		headSeries      int64
+		wantRate        float64
+		wantAlleviating bool
+	}{
+		{
+			name:       "not overload",
+			headSeries: 100,
+		},
+		{
+			name:            "enter tier",
+			headSeries:      110,
+			wantRate:        1.1,
+			wantAlleviating: true,
+		},
+		{
+			name:            "stay in tier within hysteresis band",
+			lastRate:        1.1,
+			headSeries:      95,
+			wantRate:        1.1,
+			wantAlleviating: true,
+		},
+		{
+			name:       "leave tier",
+			lastRate:   1.1,
+			headSeries: 85,
+		},
+		{
+			name:            "in cooldown",
+			lastAlleviateAt: time.Now(),
+			cooldown:        time.Minute,
+			headSeries:      85,
+			wantAlleviating: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			c := newTestingMoveCoordinator()
+			c.alleviateStates = map[string]*alleviateState{
+				"0": {maxSeriesRate: cs.lastRate, lastAlleviateAt: cs.lastAlleviateAt},
+			}
+			s := newTestingShardInfo("0", cs.headSeries)
+			cp := *cfg
+			cp.Cooldown = model.Duration(cs.cooldown)
+
+			c.markAlleviating([]*shardInfo{s}, &cp)
+			r.Equal(cs.wantRate, c.alleviateStates["0"].maxSeriesRate)
+			r.Equal(cs.wantAlleviating, s.alleviating)
+		})
+	}
+}
+
+func TestCoordinator_AlleviateCooldown(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	cfg := &prom.AlleviateConfig{
+		Thresholds: []prom.AlleviateThreshold{{MaxSeriesRate: 1.1, ExpectSeriesRate: 0.5}},
+		Cooldown:   model.Duration(time.Minute),
+	}
+
+	newShards := func() []*shardInfo {
+		s0 := newTestingShardInfo("0", 120)
+		s1 := newTestingShardInfo("1", 0)
+		s0.scraping = map[uint64]*target.ScrapeStatus{}
+		s1.scraping = map[uint64]*target.ScrapeStatus{}
+		for i := uint64(0); i < 4; i++ {
+			s0.scraping[i] = &target.ScrapeStatus{Series: 30, Health: scrape.HealthGood, TargetState: target.StateNormal}
+		}
+		return []*shardInfo{s0, s1}
+	}
+
+	// cooldown is not started in dry run
+	shards := newShards()
+	c.markAlleviating(shards, cfg)
+	r.True(shards[0].alleviating)
+	r.False(shards[1].alleviating)
+	c.alleviateShards(shards, cfg, true)
+	r.Equal(3, len(shards[1].scraping))
+	r.True(c.alleviateStates["0"].lastAlleviateAt.IsZero())
+
+	// cooldown is not started if no target is transferred
+	shards = newShards()
+	shards[1].cordoned = true
+	c.markAlleviating(shards, cfg)
+	c.alleviateShards(shards, cfg, false)
+	r.Empty(shards[1].scraping)
+	r.True(c.alleviateStates["0"].lastAlleviateAt.IsZero())
+
+	shards = newShards()
+	c.markAlleviating(shards, cfg)
+	c.alleviateShards(shards, cfg, false)
+	r.Equal(3, len(shards[1].scraping))
+	r.False(c.alleviateStates["0"].lastAlleviateAt.IsZero())
+
+	// shard is not alleviated again in cooldown
+	shards = newShards()
+	c.markAlleviating(shards, cfg)
+	c.alleviateShards(shards, cfg, false)
+	r.Empty(shards[1].scraping)
+}
@@ -0,0 +1,144 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"time"
+
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/prom"
+	"tkestack.io/kvass/pkg/shard"
+	"tkestack.io/kvass/pkg/target"
+)
+
+// applyCapacity overwrite the limits of shard with its capacity
+// limits that not overwritten are scaled by weight of capacity
+func (s *shardInfo) applyCapacity(cp *shard.Capacity) {
+	if cp == nil {
+		return
+	}
+
+	if w := cp.Weight; w > 0 {
+		s.maxHeadSeries = int64(float64(s.maxHeadSeries) * w)
+		s.maxProcessSeries = int64(float64(s.maxProcessSeries) * w)
+		s.maxMemoryBytes = int64(float64(s.maxMemoryBytes) * w)
+		s.maxSamplesRate *= w
+		s.maxTargets = int64(float64(s.maxTargets) * w)
+		s.maxScrapeDuration *= w
+	}
+
+	if cp.MaxHeadSeries != 0 {
+		s.maxHeadSeries = cp.MaxHeadSeries
+	}
+	if cp.MaxProcessSeries != 0 {
+		s.maxProcessSeries = cp.MaxProcessSeries
+	}
+	if cp.MaxMemoryBytes != 0 {
+		s.maxMemoryBytes = cp.MaxMemoryBytes
+	}
+}
+
+// newShardLimits return a shard with the limits that new shards of replica will get
+// new shards are created from the same template as the last shard, so capacity of the last shard
+// (e.g. derived from memory limit of its pod, or weight) is applied on "opt", which is the option of the pool of replica
+func newShardLimits(shards []*shardInfo, opt *Option) *shardInfo {
+	sd := &shard.Shard{}
+	if len(shards) != 0 {
+		sd.Capacity = shards[len(shards)-1].shard.Capacity
+	}
+	return newShardInfo(sd, opt)
+}
+
+// targetIntervals return the scrape interval seconds of every active target, which is got from config of its job
+func targetIntervals(active map[uint64]*discovery.SDTargets, cfg *prom.ConfigInfo) map[uint64]float64 {
+	jobs := map[string]float64{}
+	if cfg != nil && cfg.Config != nil {
+		for _, sc := range cfg.Config.ScrapeConfigs {
+			jobs[sc.JobName] = time.Duration(sc.ScrapeInterval).Seconds()
+		}
+	}
+
+	ret := map[uint64]float64{}
+	for hash, tar := range active {
+		if interval := jobs[tar.Job]; interval > 0 {
+			ret[hash] = interval
+		}
+	}
+	return ret
+}
+
+// targetSpace return the space that target "hash" with status "st" need
+// samples rate is 0 if scrape interval of target is unknown
+func (c *Coordinator) targetSpace(hash uint64, st *target.ScrapeStatus) space {
+	sp := space{
+		headSpace:      st.Series,
+		processSpace:   st.TotalSeries,
+		targets:        1,
+		scrapeDuration: st.LastScrapeDuration,
+	}
+
+	if interval := c.intervals[hash]; interval > 0 {
+		sp.samplesRate = float64(st.Series) / interval
+	}
+	return sp
+}
+
+// initLoad compute samples rate, targets number and scrape duration of shards according to their scraping targets
+func (c *Coordinator) initLoad(shards []*shardInfo) {
+	for _, s := range shards {
+		s.samplesRate, s.targets, s.scrapeDuration = 0, 0, 0
+		for hash, tar := range s.scraping {
+			sp := c.targetSpace(hash, tar)
+			s.samplesRate += sp.samplesRate
+			s.targets += sp.targets
+			s.scrapeDuration += sp.scrapeDuration
+		}
+	}
+}
+
+// addSpace record that targets with space "sp" is placed to shard
+func (s *shardInfo) addSpace(sp space) {
+	s.runtime.HeadSeries += sp.headSpace
+	s.runtime.ProcessSeries += sp.processSpace
+	s.samplesRate += sp.samplesRate
+	s.targets += sp.targets
+	s.scrapeDuration += sp.scrapeDuration
+}
+
+// loadCanHold return true if samples rate, targets number and scrape duration of shard are still under limits
+// after "sp" is added, limits with zero value are skipped
+func (s *shardInfo) loadCanHold(sp space) bool {
+	return (s.maxSamplesRate == 0 || s.samplesRate+sp.samplesRate < s.maxSamplesRate) &&
+		(s.maxTargets == 0 || s.targets+sp.targets <= s.maxTargets) &&
+		(s.maxScrapeDuration == 0 || s.scrapeDuration+sp.scrapeDuration < s.maxScrapeDuration)
+}
+
+// loadTooBig return true if samples rate or scrape duration of one target is over the limit of shard
+// such target can not be placed to any shard
+func loadTooBig(sp space, opt *Option) bool {
+	return (opt.MaxSamplesRate != 0 && sp.samplesRate >= opt.MaxSamplesRate) ||
+		(opt.MaxScrapeDuration != 0 && sp.scrapeDuration >= opt.MaxScrapeDuration.Seconds())
+}
+
+// shardsNeeded return the shard number needed to hold "need" if every shard can hold "max", 0 is returned if max is 0
+func shardsNeeded(need, max float64) int32 {
+	if max == 0 {
+		return 0
+	}
+	return int32(need/max) + 1
+}
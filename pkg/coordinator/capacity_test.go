@@ -0,0 +1,302 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/prom"
+	"tkestack.io/kvass/pkg/shard"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func TestShardInfo_ApplyCapacity(t *testing.T) {
+	opt := &Option{MaxHeadSeries: 100, MaxProcessSeries: 1000, MaxMemoryBytes: 1000, MaxTargets: 10}
+	var cases = []struct {
+		name        string
+		capacity    *shard.Capacity
+		wantHead    int64
+		wantProcess int64
+		wantMemory  int64
+		wantTargets int64
+	}{
+		{
+			name:        "no capacity",
+			wantHead:    100,
+			wantProcess: 1000,
+			wantMemory:  1000,
+			wantTargets: 10,
+		},
+		{
+			name:        "overwrite series",
+			capacity:    &shard.Capacity{MaxHeadSeries: 400, MaxProcessSeries: 2000},
+			wantHead:    400,
+			wantProcess: 2000,
+			wantMemory:  1000,
+			wantTargets: 10,
+		},
+		{
+			name:        "overwrite memory",
+			capacity:    &shard.Capacity{MaxMemoryBytes: 5000},
+			wantHead:    100,
+			wantProcess: 1000,
+			wantMemory:  5000,
+			wantTargets: 10,
+		},
+		{
+			name:        "weight",
+			capacity:    &shard.Capacity{MaxHeadSeries: 150, Weight: 4},
+			wantHead:    150,
+			wantProcess: 4000,
+			wantMemory:  4000,
+			wantTargets: 40,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			sd := shard.NewShard("0", "", true, logrus.New())
+			sd.Capacity = cs.capacity
+			s := newShardInfo(sd, opt)
+			r.Equal(cs.wantHead, s.maxHeadSeries)
+			r.Equal(cs.wantProcess, s.maxProcessSeries)
+			r.Equal(cs.wantMemory, s.maxMemoryBytes)
+			r.Equal(cs.wantTargets, s.maxTargets)
+		})
+	}
+}
+
+func TestIsTooBig(t *testing.T) {
+	r := require.New(t)
+	opt := &Option{MaxHeadSeries: 100, MaxProcessSeries: 1000}
+	small := newTestingShardInfo("0", 0)
+	big := newTestingShardInfo("1", 0)
+	big.maxHeadSeries = 400
+
+	tar := &target.ScrapeStatus{Series: 200}
+	r.True(isTooBig(tar, opt, []*shardInfo{small}))
+	r.False(isTooBig(tar, opt, []*shardInfo{small, big}))
+	r.False(isTooBig(&target.ScrapeStatus{Series: 50}, opt, nil))
+}
+
+func TestTargetIntervals(t *testing.T) {
+	r := require.New(t)
+	cfg := &prom.ConfigInfo{Config: &config.Config{ScrapeConfigs: []*config.ScrapeConfig{
+		{JobName: "job1", ScrapeInterval: model.Duration(10 * time.Second)},
+		{JobName: "job2", ScrapeInterval: model.Duration(time.Minute)},
+	}}}
+	active := map[uint64]*discovery.SDTargets{
+		1: {Job: "job1"},
+		2: {Job: "job2"},
+		3: {Job: "job3"},
+	}
+
+	r.Equal(map[uint64]float64{1: 10, 2: 60}, targetIntervals(active, cfg))
+	r.Empty(targetIntervals(active, nil))
+}
+
+func TestCoordinator_TargetSpace(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	c.intervals = map[uint64]float64{1: 10}
+	st := &target.ScrapeStatus{Series: 100, TotalSeries: 200, LastScrapeDuration: 0.5}
+
+	r.Equal(space{
+		headSpace:      100,
+		processSpace:   200,
+		samplesRate:    10,
+		targets:        1,
+		scrapeDuration: 0.5,
+	}, c.targetSpace(1, st))
+
+	// samples rate is unknown if scrape interval is unknown
+	r.Equal(float64(0), c.targetSpace(2, st).samplesRate)
+}
+
+func TestShardInfo_LoadCanHold(t *testing.T) {
+	var cases = []struct {
+		name  string
+		limit func(s *shardInfo)
+		sp    space
+		want  bool
+	}{
+		{
+			name:  "no limit",
+			limit: func(s *shardInfo) {},
+			sp:    space{samplesRate: 1000, targets: 1000, scrapeDuration: 1000},
+			want:  true,
+		},
+		{
+			name:  "samples rate over limit",
+			limit: func(s *shardInfo) { s.maxSamplesRate = 20 },
+			sp:    space{samplesRate: 10},
+			want:  false,
+		},
+		{
+			name:  "targets under limit",
+			limit: func(s *shardInfo) { s.maxTargets = 3 },
+			sp:    space{targets: 1},
+			want:  true,
+		},
+		{
+			name:  "targets over limit",
+			limit: func(s *shardInfo) { s.maxTargets = 2 },
+			sp:    space{targets: 1},
+			want:  false,
+		},
+		{
+			name:  "scrape duration over limit",
+			limit: func(s *shardInfo) { s.maxScrapeDuration = 2 },
+			sp:    space{scrapeDuration: 1},
+			want:  false,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			s := newTestingShardInfo("0", 0)
+			s.addSpace(space{samplesRate: 10, targets: 2, scrapeDuration: 1})
+			cs.limit(s)
+			require.Equal(t, cs.want, s.loadCanHold(cs.sp))
+		})
+	}
+}
+
+func TestCoordinator_AssignWithTargetsLimit(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	c.option.MaxProcessSeries = 1000
+	c.option.MaxTargets = 2
+	active := map[uint64]*discovery.SDTargets{
+		1: {Job: "job1", ShardTarget: &target.Target{}},
+		2: {Job: "job1", ShardTarget: &target.Target{}},
+		3: {Job: "job1", ShardTarget: &target.Target{}},
+	}
+	status := map[uint64]*target.ScrapeStatus{
+		1: {Series: 1, Health: scrape.HealthGood},
+		2: {Series: 1, Health: scrape.HealthGood},
+		3: {Series: 1, Health: scrape.HealthGood},
+	}
+
+	s := newTestingShardInfo("0", 0)
+	s.maxTargets = 2
+	s.scraping = map[uint64]*target.ScrapeStatus{}
+	needSpace := c.assignNoScrapingTargets([]*shardInfo{s}, active, status, c.option)
+	r.Equal(2, len(s.scraping))
+	r.Equal(int64(1), needSpace.targets)
+	r.Equal(int32(2), c.tryScaleUp([]*shardInfo{s}, needSpace, c.option))
+}
+
+func TestCoordinator_TryScaleUpLoad(t *testing.T) {
+	var cases = []struct {
+		name   string
+		option *Option
+		sp     space
+		want   int32
+	}{
+		{
+			name:   "only series",
+			option: &Option{MaxProcessSeries: 1000},
+			sp:     space{processSpace: 100, samplesRate: 1000, targets: 100, scrapeDuration: 100},
+			want:   2,
+		},
+		{
+			name:   "samples rate",
+			option: &Option{MaxProcessSeries: 1000, MaxSamplesRate: 100},
+			sp:     space{processSpace: 100, samplesRate: 250},
+			want:   4,
+		},
+		{
+			name:   "targets",
+			option: &Option{MaxProcessSeries: 1000, MaxTargets: 10},
+			sp:     space{processSpace: 100, targets: 30},
+			want:   5,
+		},
+		{
+			name:   "scrape duration",
+			option: &Option{MaxProcessSeries: 1000, MaxScrapeDuration: time.Minute},
+			sp:     space{processSpace: 100, scrapeDuration: 60},
+			want:   3,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			c := newTestingMoveCoordinator()
+			require.Equal(t, cs.want, c.tryScaleUp([]*shardInfo{newTestingShardInfo("0", 0)}, cs.sp, cs.option))
+		})
+	}
+}
+
+func TestCoordinator_TryScaleUpCapacity(t *testing.T) {
+	var cases = []struct {
+		name       string
+		capacities []*shard.Capacity
+		want       int32
+	}{
+		{
+			name:       "no capacity",
+			capacities: []*shard.Capacity{nil},
+			want:       4,
+		},
+		{
+			name:       "head series of capacity",
+			capacities: []*shard.Capacity{{MaxHeadSeries: 1000}},
+			want:       2,
+		},
+		{
+			name:       "weight of capacity",
+			capacities: []*shard.Capacity{{Weight: 2}},
+			want:       3,
+		},
+		{
+			name:       "memory of capacity",
+			capacities: []*shard.Capacity{{MaxHeadSeries: 1000, MaxMemoryBytes: 1000}},
+			want:       4,
+		},
+		{
+			name:       "capacity of the last shard",
+			capacities: []*shard.Capacity{{MaxHeadSeries: 1000}, nil},
+			want:       5,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			c := newTestingMoveCoordinator()
+			shards := make([]*shardInfo, 0)
+			for i, cp := range cs.capacities {
+				s := newTestingMemoryShardInfo(fmt.Sprint(i), 100, 0)
+				s.shard.Capacity = cp
+				shards = append(shards, s)
+			}
+
+			opt := &Option{MaxProcessSeries: 100000, MaxHeadSeries: 100}
+			require.Equal(t, cs.want, c.tryScaleUp(shards, space{headSpace: 250}, opt))
+		})
+	}
+}
@@ -18,7 +18,7 @@
 package coordinator
 
 import (
-	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -32,19 +32,6 @@ import (
 	"tkestack.io/kvass/pkg/scrape"
 	"tkestack.io/kvass/pkg/shard"
 	"tkestack.io/kvass/pkg/target"
-	"tkestack.io/kvass/pkg/utils/wait"
-)
-
-var (
-	coordinatorFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "kvass_coordinator_failed_total",
-	}, []string{})
-	assignNoScrapingTargetsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "kvass_coordinator_assign_targets_total",
-	}, []string{})
-	alleviateShardsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "kvass_coordinator_alleviate_shards_total",
-	}, []string{})
 )
 
 // Option indicate all coordinate arguments
@@ -53,6 +40,16 @@ type Option struct {
 	MaxHeadSeries int64
 	// MaxProcessSeries is max series before metrics_relabels every shard can assign
 	MaxProcessSeries int64
+	// MaxMemoryBytes is the max resident memory of every shard reported by sidecar, skipped if 0
+	// it is used alongside MaxHeadSeries and MaxProcessSeries when placing targets, alleviating and scaling up
+	MaxMemoryBytes int64
+	// MaxSamplesRate is the max ingested samples per second of every shard, skipped if 0
+	// samples rate of a target is its head series divided by scrape interval of its job
+	MaxSamplesRate float64
+	// MaxTargets is the max number of targets every shard can scrape, skipped if 0
+	MaxTargets int64
+	// MaxScrapeDuration is the max cumulative last scrape duration of targets of every shard, skipped if 0
+	MaxScrapeDuration time.Duration
 	// MaxShard is the max number we can scale up to
 	MaxShard int32
 	// MinShard is the min shard number that coordinator need
@@ -62,20 +59,106 @@ type Option struct {
 	MaxIdleTime time.Duration
 	// Period is the interval between every coordinating
 	Period time.Duration
+	// EventTrigger enable coordinating triggered by Trigger, Trigger is ignored if it is false
+	EventTrigger bool
+	// MinInterval is the min interval between last coordinating and the one triggered by Trigger
+	MinInterval time.Duration
+	// TriggerDebounce indicate that triggered coordinating is done only after no more trigger in TriggerDebounce
+	TriggerDebounce time.Duration
+	// ShardWatchInterval is the interval of checking shards changes, which triggers coordinating, disabled if 0
+	ShardWatchInterval time.Duration
+	// FailoverGracePeriod is how long a shard can be unhealthy before its targets are assigned to other shards
+	// failover is disabled if 0
+	FailoverGracePeriod time.Duration
 	// DisableAlleviate disable shard alleviation when shard is overload
 	DisableAlleviate bool
+	// Alleviate is the tiers, hysteresis and cooldown of shard alleviation, it is overwritten by ExtraConfig if set
+	Alleviate prom.AlleviateConfig
+	// Scheduler is the name of strategy used to choose shard for targets, see Schedulers
+	// first-fit is used if MaxIdleTime != 0, otherwise weighted-random is used if it is empty
+	Scheduler string
+	// CustomScheduler is used to choose shard for targets instead of built-in Scheduler if it is not nil
+	CustomScheduler Scheduler
+	// Affinity contains rules that keep related targets together or spread them apart
+	Affinity []AffinityRule
+	// Pools is the dedicated shard pools, targets of jobs that not in any pool are placed to default pool
+	Pools []PoolOption
+	// MaxTransferTargets is the max number of targets transferred to one replica during one coordinating, skipped if 0
+	MaxTransferTargets int
+	// MaxTransferSeries is the max head series of targets transferred to one replica during one coordinating, skipped if 0
+	MaxTransferSeries int64
+	// MaxShardTransferTargets is the max number of targets transferred from or to one shard during one coordinating
+	// skipped if 0
+	MaxShardTransferTargets int
+	// MaxShardTransferSeries is the max head series of targets transferred from or to one shard during one coordinating
+	// skipped if 0
+	MaxShardTransferSeries int64
+	// Quotas limit head series and number of targets of jobs or label values, new targets are not assigned if quota exceeded
+	// it is overwritten by ExtraConfig if set
+	Quotas []prom.QuotaRule
+	// MirrorReplicas make all replicas of one shard pool have the same targets placement
+	// placement of the first replica is computed, and targets of its shard i are placed to shard i of other replicas
+	MirrorReplicas bool
+	// DryRun make coordinator compute all decisions without applying them to shards
+	// the decisions can be got from LastPlan
+	DryRun bool
+	// MaxEvents is the max number of coordinating events kept in memory, defaultMaxEvents is used if 0
+	MaxEvents int
 }
 
+const defaultMaxEvents = 1000
+
 // Coordinator periodically re balance all replicates
 type Coordinator struct {
 	log              logrus.FieldLogger
 	reManager        shard.ReplicasManager
 	option           *Option
+	scheduler        Scheduler
+	affinity         *affinity
+	budget           *transferBudget
+	metrics          *metrics
 	getConfig        func() *prom.ConfigInfo
 	getExploreResult func(hash uint64) *target.ScrapeStatus
 	getActive        func() map[uint64]*discovery.SDTargets
+	isLeader         func() bool
+	events           *EventRecorder
+	trigger          chan string
+	lastRunAt        time.Time
+	quota            *quota
+	// intervals is the scrape interval seconds of active targets in current coordinating, key is target hash
+	intervals map[uint64]float64
+	// unassigned is the reasons of targets not assigned in current coordinating, key is target hash
+	unassigned map[uint64]string
+	// unassignedKinds is the kinds of reasons in unassigned, such as unassignedNoSpace, key is target hash
+	unassignedKinds map[uint64]string
+	// pendingEvents is the events of current coordinating replica, they are recorded after decisions are applied
+	pendingEvents []*Event
+
+	// moveLock protect moves and pins, which are changed by API
+	moveLock sync.Mutex
+	moves    map[uint64]*targetMove
+	pins     map[uint64]string
+	// cordonLock protect cordons, which are changed by API
+	cordonLock sync.Mutex
+	cordons    map[string]*ShardCordon
+	// alleviateStates is the alleviation state of shards, key is shard ID
+	alleviateStates map[string]*alleviateState
+	// unhealthySince is the time shards become not changeAble, key is shard ID
+	unhealthySince map[string]time.Time
+	// knownScraping is the targets of shards when they were changeAble last time, key is shard ID
+	knownScraping map[string]map[uint64]*target.ScrapeStatus
+	// roundShards is all shards got in current coordinating
+	roundShards []*shard.Shard
+	// exploreLock protect exploreShards, which is used by explore workers
+	exploreLock   sync.Mutex
+	exploreShards []*shard.Shard
+	exploreNext   int
 
+	// lastLock protect the results of last coordinating, which are read by API and overwritten by importing state
+	lastLock               sync.Mutex
 	lastGlobalScrapeStatus map[uint64]*target.ScrapeStatus
+	lastPlan               *Plan
+	lastUnassigned         map[uint64]string
 }
 
 // NewCoordinator create a new coordinator service
@@ -85,32 +168,79 @@ func NewCoordinator(
 	getConfig func() *prom.ConfigInfo,
 	getExploreResult func(hash uint64) *target.ScrapeStatus,
 	getActive func() map[uint64]*discovery.SDTargets,
+	isLeader func() bool,
 	promRegisterer prometheus.Registerer,
 	log logrus.FieldLogger,
 ) *Coordinator {
-	_ = promRegisterer.Register(coordinatorFailed)
-	_ = promRegisterer.Register(assignNoScrapingTargetsTotal)
-	_ = promRegisterer.Register(alleviateShardsTotal)
+	m := newMetrics()
+	m.register(promRegisterer)
+
+	scheduler, err := newScheduler(option)
+	if err != nil {
+		log.Errorf("%s, use default scheduler", err.Error())
+		scheduler, _ = newScheduler(&Option{MaxIdleTime: option.MaxIdleTime})
+	}
+
+	maxEvents := option.MaxEvents
+	if maxEvents == 0 {
+		maxEvents = defaultMaxEvents
+	}
+
 	return &Coordinator{
 		reManager:        reManager,
+		events:           NewEventRecorder(maxEvents),
+		trigger:          make(chan string, 1),
+		scheduler:        scheduler,
+		affinity:         newAffinity(option.Affinity),
+		budget:           newTransferBudget(option),
+		metrics:          m,
 		getConfig:        getConfig,
 		getExploreResult: getExploreResult,
 		getActive:        getActive,
+		isLeader:         isLeader,
 		option:           option,
 		log:              log,
 	}
 }
 
-// Run do coordinate periodically until ctx done
-func (c *Coordinator) Run(ctx context.Context) error {
-	return wait.RunUntil(ctx, c.log, c.option.Period, c.runOnce)
-}
-
 // LastGlobalScrapeStatus return the last scraping status of all targets
 func (c *Coordinator) LastGlobalScrapeStatus() map[uint64]*target.ScrapeStatus {
+	c.lastLock.Lock()
+	defer c.lastLock.Unlock()
 	return c.lastGlobalScrapeStatus
 }
 
+// LastUnassigned return the reasons of targets that are not assigned by last coordinating, key is target hash
+func (c *Coordinator) LastUnassigned() map[uint64]string {
+	c.lastLock.Lock()
+	defer c.lastLock.Unlock()
+	return c.lastUnassigned
+}
+
+// LastPlan return the plan made by last coordinating
+func (c *Coordinator) LastPlan() *Plan {
+	c.lastLock.Lock()
+	defer c.lastLock.Unlock()
+	return c.lastPlan
+}
+
+// restoreLast overwrite the results of last coordinating with imported state, nil values are ignored
+func (c *Coordinator) restoreLast(status map[uint64]*target.ScrapeStatus, plan *Plan) {
+	c.lastLock.Lock()
+	defer c.lastLock.Unlock()
+	if status != nil {
+		c.lastGlobalScrapeStatus = status
+	}
+	if plan != nil {
+		c.lastPlan = plan
+	}
+}
+
+// Events return the latest coordinating events, the oldest is the first one
+func (c *Coordinator) Events() []*Event {
+	return c.events.Events()
+}
+
 // LastScrapeStatistics collect targets scrape sample statistic from all shards
 func (c *Coordinator) LastScrapeStatistics(jobName string, withMetricsDetail bool) (map[string]*scrape.StatisticsSeriesResult, error) {
 	rep, err := c.reManager.Replicas()
@@ -176,12 +306,18 @@ func (c *Coordinator) LastScrapeStatistics(jobName string, withMetricsDetail boo
 	return ret, nil
 }
 
+// dryRun return true if decisions should not be applied to shards
+// followers only compute decisions to keep scraping status up to date
+func (c *Coordinator) dryRun() bool {
+	return c.option.DryRun || (c.isLeader != nil && !c.isLeader())
+}
+
 // runOnce get shards information from shard manager,
 // do shard reBalance and change expect shard number
 func (c *Coordinator) runOnce() (err error) {
 	defer func() {
 		if err != nil {
-			coordinatorFailed.WithLabelValues().Inc()
+			c.metrics.failed.WithLabelValues().Inc()
 		}
 	}()
 
@@ -194,59 +330,183 @@ func (c *Coordinator) runOnce() (err error) {
 		return errors.New("no shards replicas is found")
 	}
 
-	newLastGlobalScrapeStatus := map[uint64]*target.ScrapeStatus{}
+	c.unassigned = map[uint64]string{}
+	c.unassignedKinds = map[uint64]string{}
+	c.roundShards = nil
+
+	var (
+		active                    = c.getActive()
+		newLastGlobalScrapeStatus = map[uint64]*target.ScrapeStatus{}
+		dryRun                    = c.dryRun()
+		plan                      = &Plan{DryRun: dryRun, CreatedAt: time.Now()}
+		backlogTargets            = 0
+		backlogSeries             = int64(0)
+		// failed is true if any replica is not coordinated, shards of it may be missing in roundShards
+		failed = false
+		// sources is the placement of the first replica of every pool, used if MirrorReplicas is set
+		sources = map[string]*placement{}
+	)
+
 	for _, repItem := range replicas {
-		shards, err := repItem.Shards()
-		if err != nil {
-			c.log.Error(err.Error())
+		opt, exist := c.poolOption(repItem.Pool())
+		if !exist {
+			c.log.Warnf("shard pool %s is not configured, replica skipped", repItem.Pool())
 			continue
 		}
+		opt.DryRun = dryRun
 
 		var (
-			active           = c.getActive()
-			shardsInfo       = c.getShardInfos(shards)
-			changeAbleShards = changeAbleShardsInfo(shardsInfo)
+			poolActive             = c.poolTargets(repItem.Pool(), active)
+			lastGlobalScrapeStatus map[uint64]*target.ScrapeStatus
+			repPlan                *ReplicaPlan
+			start                  = time.Now()
 		)
 
-		if int32(len(changeAbleShards)) < c.option.MinShard { // insure that scaling up to min shard
-			if err := repItem.ChangeScale(c.option.MinShard); err != nil {
-				c.log.Error(err.Error())
-				continue
+		// budget is applied to every replica separately, include mirrors
+		c.budget.reset()
+		if src := sources[repItem.Pool()]; src != nil {
+			lastGlobalScrapeStatus, repPlan, err = c.mirrorReplica(repItem, opt, poolActive, src)
+		} else {
+			lastGlobalScrapeStatus, repPlan, src, err = c.coordinateReplica(repItem, opt, poolActive)
+			if err == nil && c.option.MirrorReplicas {
+				sources[repItem.Pool()] = src
 			}
 		}
+		c.metrics.updateReplica(repItem.Name(), repItem.Pool(), repPlan, time.Since(start))
 
-		lastGlobalScrapeStatus := c.globalScrapeStatus(active, shardsInfo)
-		c.gcTargets(changeAbleShards, active)
-		needSpace := c.alleviateShards(changeAbleShards)
-		needSpace.add(c.assignNoScrapingTargets(shardsInfo, active, lastGlobalScrapeStatus))
-
-		scale := int32(len(shardsInfo))
-		if !needSpace.isZero() {
-			c.log.Infof("need space head space = %d, process space = %d", needSpace.headSpace, needSpace.processSpace)
-			scale = c.tryScaleUp(shardsInfo, needSpace)
-		} else if c.option.MaxIdleTime != 0 {
-			scale = c.tryScaleDown(shardsInfo)
+		if err != nil {
+			c.log.Error(err.Error())
+			failed = true
+			continue
 		}
+		newLastGlobalScrapeStatus = mergeScrapeStatus(newLastGlobalScrapeStatus, lastGlobalScrapeStatus)
+		plan.Replicas = append(plan.Replicas, repPlan)
+		backlogTargets += c.budget.backlogTargets
+		backlogSeries += c.budget.backlogSeries
+	}
+
+	if !dryRun {
+		c.gcMoves(active)
+	}
 
-		if scale > c.option.MaxShard {
-			scale = c.option.MaxShard
+	if !failed {
+		c.pruneFailover(c.roundShards)
+		if !dryRun {
+			c.prunePins(c.roundShards)
 		}
+	}
+
+	c.metrics.transferBacklogTargets.WithLabelValues().Set(float64(backlogTargets))
+	c.metrics.transferBacklogSeries.WithLabelValues().Set(float64(backlogSeries))
+
+	c.lastLock.Lock()
+	c.lastGlobalScrapeStatus = newLastGlobalScrapeStatus
+	c.lastPlan = plan
+	c.lastUnassigned = c.unassigned
+	c.lastLock.Unlock()
+	c.metrics.updateUnassigned(c.unassignedKinds)
+	// shards, replicas or quota groups may be deleted, their metrics should be removed
+	c.metrics.deleteStale()
+	c.setExploreShards(c.roundShards)
+	return nil
+}
+
+// coordinateReplica do shard reBalance of one replica and change expect shard number
+// only "active" targets are placed to this replica, and shards are limited by "opt"
+// nothing is applied to shards if DryRun of "opt" is set
+// the returned placement is the expected targets of every shard, which can be mirrored to other replicas
+func (c *Coordinator) coordinateReplica(
+	repItem shard.Manager,
+	opt *Option,
+	active map[uint64]*discovery.SDTargets,
+) (map[uint64]*target.ScrapeStatus, *ReplicaPlan, *placement, error) {
+	shards, err := repItem.Shards()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return c.coordinateShards(repItem, opt, active, c.getShardInfos(shards, opt))
+}
+
+// coordinateShards do shard reBalance of "shardsInfo", which are all shards of replica "repItem"
+func (c *Coordinator) coordinateShards(
+	repItem shard.Manager,
+	opt *Option,
+	active map[uint64]*discovery.SDTargets,
+	shardsInfo []*shardInfo,
+) (map[uint64]*target.ScrapeStatus, *ReplicaPlan, *placement, error) {
+	c.pendingEvents = nil
+
+	var (
+		changeAbleShards = changeAbleShardsInfo(shardsInfo)
+		before           = snapshotScraping(shardsInfo)
+	)
+	c.markCordoned(shardsInfo)
+	c.markFailover(shardsInfo, before)
 
-		if scale < c.option.MinShard {
-			scale = c.option.MinShard
+	if int32(len(changeAbleShards)) < opt.MinShard && !opt.DryRun { // insure that scaling up to min shard
+		if err := repItem.ChangeScale(opt.MinShard); err != nil {
+			return nil, nil, nil, err
 		}
+	}
 
-		updateScrapingTargets(shardsInfo, active)
-		c.applyShardsInfo(shardsInfo)
-		if err := repItem.ChangeScale(scale); err != nil {
-			c.log.Error(err.Error())
-			continue
+	lastGlobalScrapeStatus := c.globalScrapeStatus(active, shardsInfo)
+	c.gcTargets(changeAbleShards, active)
+	c.intervals = targetIntervals(active, c.getConfig())
+	c.initLoad(changeAbleShards)
+	c.affinity.reset(shardsInfo, active)
+	c.quota = newQuota(c.quotaRules(), shardsInfo, active)
+	alleviateCfg := c.alleviateConfig()
+	c.markAlleviating(changeAbleShards, alleviateCfg)
+	c.moveTargets(changeAbleShards, opt.DryRun)
+	needSpace := c.drainShards(changeAbleShards)
+	needSpace.add(c.alleviateShards(changeAbleShards, alleviateCfg, opt.DryRun))
+	needSpace.add(c.assignNoScrapingTargets(shardsInfo, active, lastGlobalScrapeStatus, opt))
+	c.quota.updateMetrics(c.metrics)
+
+	scale := int32(len(shardsInfo))
+	scaleReason := ""
+	if !needSpace.isZero() {
+		c.log.Infof("need space %s", needSpace.String())
+		scale = c.tryScaleUp(shardsInfo, needSpace, opt)
+		scaleReason = fmt.Sprintf("need space %s", needSpace.String())
+	} else if c.option.MaxIdleTime != 0 {
+		scale = c.tryScaleDown(shardsInfo)
+		scaleReason = fmt.Sprintf("shards are idle for more than %s", c.option.MaxIdleTime)
+	}
+
+	if scale > opt.MaxShard {
+		scale = opt.MaxShard
+		if scaleReason == "" {
+			scaleReason = "shards number is more than max shard"
+		} else {
+			scaleReason += ", limited by max shard"
 		}
+	}
 
-		lastGlobalScrapeStatus = c.updateScrapeStatusShards(shardsInfo, lastGlobalScrapeStatus)
-		newLastGlobalScrapeStatus = mergeScrapeStatus(newLastGlobalScrapeStatus, lastGlobalScrapeStatus)
+	if scale < opt.MinShard {
+		scale = opt.MinShard
+		scaleReason = "shards number is less than min shard"
 	}
+	c.scaleEvent(int32(len(shardsInfo)), scale, scaleReason)
 
-	c.lastGlobalScrapeStatus = newLastGlobalScrapeStatus
-	return nil
+	updateScrapingTargets(shardsInfo, active)
+	c.updateCordonProgress(shardsInfo)
+	plan := newReplicaPlan(repItem.Pool(), before, shardsInfo, active, scale)
+	pl := newPlacement(shardsInfo, scale)
+	if opt.DryRun {
+		// targets are still scraped by origin shards
+		for _, s := range shardsInfo {
+			s.scraping = before[s]
+		}
+		c.pendingEvents = nil
+		return c.updateScrapeStatusShards(shardsInfo, lastGlobalScrapeStatus), plan, pl, nil
+	}
+
+	c.applyShardsInfo(shardsInfo)
+	if err := repItem.ChangeScale(scale); err != nil {
+		return nil, nil, nil, err
+	}
+	c.commitEvents(repItem, active)
+
+	return c.updateScrapeStatusShards(shardsInfo, lastGlobalScrapeStatus), plan, pl, nil
 }
@@ -45,7 +45,7 @@ type testingShard struct {
 }
 
 func (ts *testingShard) assert(t *testing.T) {
-	require.JSONEq(t, test.MustJSON(ts.wantTargets), test.MustJSON(ts.resultTargets))
+	require.JSONEq(t, test.MustJSON(ts.wantTargets.Targets), test.MustJSON(ts.resultTargets.Targets))
 }
 
 type fakeReplicasManager struct {
@@ -59,7 +59,9 @@ func (f *fakeReplicasManager) Replicas() ([]shard.Manager, error) {
 type fakeShardsManager struct {
 	resultRep int32
 	wantRep   int32
+	pool      string
 	shards    []*testingShard
+	events    []string
 }
 
 // Shards return current Shards in the cluster
@@ -94,6 +96,21 @@ func (f *fakeShardsManager) ChangeScale(expReplicate int32) error {
 	return nil
 }
 
+// Pool return the name of shard pool this replica belongs to
+func (f *fakeShardsManager) Pool() string {
+	return f.pool
+}
+
+// Name return the name of this replica
+func (f *fakeShardsManager) Name() string {
+	return "fake"
+}
+
+// ReportEvent record the reason of reported event
+func (f *fakeShardsManager) ReportEvent(reason, message string) {
+	f.events = append(f.events, reason)
+}
+
 func (f *fakeShardsManager) assert(t *testing.T) {
 	r := require.New(t)
 	r.Equal(f.wantRep, f.resultRep)
@@ -576,11 +593,12 @@ func TestCoordinator_RunOnce(t *testing.T) {
 	for _, cs := range cases {
 		t.Run(cs.name, func(t *testing.T) {
 			option := &Option{
-				MaxHeadSeries: cs.maxSeries,
-				MaxShard:      cs.maxShard,
-				MinShard:      cs.minShard,
-				MaxIdleTime:   cs.maxIdleTime,
-				Period:        cs.period,
+				MaxHeadSeries:    cs.maxSeries,
+				MaxProcessSeries: 1000000,
+				MaxShard:         cs.maxShard,
+				MinShard:         cs.minShard,
+				MaxIdleTime:      cs.maxIdleTime,
+				Period:           cs.period,
 			}
 			c := NewCoordinator(option,
 				&fakeReplicasManager{cs.shardManager},
@@ -589,6 +607,7 @@ func TestCoordinator_RunOnce(t *testing.T) {
 				},
 				cs.getExploreResult,
 				cs.getActive,
+				nil,
 				prometheus.NewRegistry(),
 				logrus.New(),
 			)
@@ -646,7 +665,7 @@ func TestCoordinator_LastGlobalScrapeStatus(t *testing.T) {
 	c := NewCoordinator(option,
 		&fakeReplicasManager{shardManager}, func() *prom.ConfigInfo {
 			return prom.DefaultConfig
-		}, getStatus, active,
+		}, getStatus, active, nil,
 		prometheus.NewRegistry(),
 		logrus.New(),
 	)
@@ -658,6 +677,148 @@ func TestCoordinator_LastGlobalScrapeStatus(t *testing.T) {
 	r.NotNil(g[2])
 }
 
+func TestCoordinator_DryRun(t *testing.T) {
+	t.Run("dry run", func(t *testing.T) {
+		testDryRun(t, true, nil)
+	})
+	t.Run("follower", func(t *testing.T) {
+		testDryRun(t, false, func() bool { return false })
+	})
+}
+
+func testDryRun(t *testing.T, dryRun bool, isLeader func() bool) {
+	shardManager := &fakeShardsManager{
+		shards: []*testingShard{
+			{
+				rtInfo: &shard.RuntimeInfo{
+					HeadSeries: 10,
+				},
+				targetStatus: map[uint64]*target.ScrapeStatus{
+					2: {
+						Series: 10,
+						Health: scrape.HealthGood,
+					},
+				},
+			},
+		},
+	}
+
+	active := func() map[uint64]*discovery.SDTargets {
+		return map[uint64]*discovery.SDTargets{
+			1: {
+				Job: "test",
+				ShardTarget: &target.Target{
+					Hash: 1,
+				},
+			},
+		}
+	}
+
+	option := &Option{
+		MaxHeadSeries:    100,
+		MaxProcessSeries: 1000000,
+		MaxShard:         100,
+		MinShard:         2,
+		DryRun:           dryRun,
+	}
+	c := NewCoordinator(option,
+		&fakeReplicasManager{shardManager}, func() *prom.ConfigInfo {
+			return prom.DefaultConfig
+		}, func(hash uint64) *target.ScrapeStatus {
+			return &target.ScrapeStatus{Series: 1, Health: scrape.HealthGood}
+		}, active, isLeader,
+		prometheus.NewRegistry(),
+		logrus.New(),
+	)
+
+	r := require.New(t)
+	r.NoError(c.runOnce())
+	// nothing is applied
+	r.Equal(int32(0), shardManager.resultRep)
+	r.Nil(shardManager.shards[0].resultTargets.Targets)
+
+	p := c.LastPlan()
+	r.True(p.DryRun)
+	r.Equal(1, len(p.Replicas))
+	rp := p.Replicas[0]
+	r.Equal(int32(1), rp.CurrentScale)
+	r.Equal(int32(2), rp.ExpectScale)
+	r.Equal(1, rp.AssignedTargets)
+	r.Equal(1, rp.DeletedTargets)
+	r.Equal(uint64(1), rp.Shards[0].Assigned[0].Hash)
+	r.Equal("test", rp.Shards[0].Assigned[0].Job)
+	r.Equal(uint64(2), rp.Shards[0].Deleted[0].Hash)
+	// target is not scraped by any shard actually
+	r.Empty(c.LastGlobalScrapeStatus()[1].Shards)
+	r.Empty(c.Events())
+	r.Empty(shardManager.events)
+}
+
+func TestCoordinator_Events(t *testing.T) {
+	shardManager := &fakeShardsManager{
+		shards: []*testingShard{
+			{
+				rtInfo: &shard.RuntimeInfo{
+					HeadSeries: 10,
+				},
+				targetStatus: map[uint64]*target.ScrapeStatus{
+					2: {
+						Series: 10,
+						Health: scrape.HealthGood,
+					},
+				},
+			},
+		},
+	}
+
+	active := func() map[uint64]*discovery.SDTargets {
+		return map[uint64]*discovery.SDTargets{
+			1: {
+				Job: "test",
+				ShardTarget: &target.Target{
+					Hash: 1,
+				},
+			},
+		}
+	}
+
+	option := &Option{
+		MaxHeadSeries:    100,
+		MaxProcessSeries: 1000000,
+		MaxShard:         100,
+		MinShard:         2,
+	}
+	c := NewCoordinator(option,
+		&fakeReplicasManager{shardManager}, func() *prom.ConfigInfo {
+			return prom.DefaultConfig
+		}, func(hash uint64) *target.ScrapeStatus {
+			return &target.ScrapeStatus{Series: 1, Health: scrape.HealthGood}
+		}, active, nil,
+		prometheus.NewRegistry(),
+		logrus.New(),
+	)
+
+	r := require.New(t)
+	r.NoError(c.runOnce())
+	events := c.Events()
+	r.Equal(3, len(events))
+
+	r.Equal(EventGC, events[0].Type)
+	r.Equal(uint64(2), events[0].Hash)
+	r.Equal("0-r0", events[0].From)
+
+	r.Equal(EventAssign, events[1].Type)
+	r.Equal(uint64(1), events[1].Hash)
+	r.Equal("test", events[1].Job)
+	r.Equal("0-r0", events[1].To)
+
+	r.Equal(EventScaleUp, events[2].Type)
+	r.Equal(int32(1), events[2].FromScale)
+	r.Equal(int32(2), events[2].ToScale)
+
+	r.Equal([]string{EventGC, EventAssign, EventScaleUp}, shardManager.events)
+}
+
 func TestCoordinator_LastScrapeStatistics(t *testing.T) {
 
 }
@@ -0,0 +1,196 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"tkestack.io/kvass/pkg/target"
+)
+
+// ShardCordon is the cordon status of one shard
+// cordoned shard receives no new target, and all targets of it are transferred to other shards if Drain is true
+type ShardCordon struct {
+	// Shard is the ID of cordoned shard
+	Shard string `json:"shard"`
+	// Drain indicate that all targets of this shard should be transferred to other shards
+	Drain bool `json:"drain"`
+	// CordonedAt is the time this shard is cordoned
+	CordonedAt time.Time `json:"cordonedAt"`
+	// Targets is the number of targets still on this shard after last coordinating, include in_transfer targets
+	Targets int `json:"targets"`
+	// InTransfer is the number of targets that are being transferred to other shards
+	InTransfer int `json:"inTransfer"`
+	// Pinned is the number of targets pinned to this shard, they are not drained until unpinned
+	Pinned int `json:"pinned"`
+	// Drained is true if Drain is true and no target is on this shard
+	Drained bool `json:"drained"`
+}
+
+// CordonShard mark shard "id" unschedulable, all targets of it are transferred to other shards if "drain" is true
+func (c *Coordinator) CordonShard(id string, drain bool) error {
+	if id == "" {
+		return errors.New("shard id is empty")
+	}
+
+	c.cordonLock.Lock()
+	defer c.cordonLock.Unlock()
+	if c.cordons == nil {
+		c.cordons = map[string]*ShardCordon{}
+	}
+
+	cd := c.cordons[id]
+	if cd == nil {
+		cd = &ShardCordon{Shard: id, CordonedAt: time.Now()}
+		c.cordons[id] = cd
+	}
+	cd.Drain = drain
+	return nil
+}
+
+// UncordonShard mark shard "id" schedulable again
+func (c *Coordinator) UncordonShard(id string) error {
+	c.cordonLock.Lock()
+	defer c.cordonLock.Unlock()
+	if _, exist := c.cordons[id]; !exist {
+		return errors.Errorf("shard %s is not cordoned", id)
+	}
+	delete(c.cordons, id)
+	return nil
+}
+
+// Cordons return the cordon status of all cordoned shards
+func (c *Coordinator) Cordons() []*ShardCordon {
+	c.cordonLock.Lock()
+	defer c.cordonLock.Unlock()
+	ret := make([]*ShardCordon, 0, len(c.cordons))
+	for _, cd := range c.cordons {
+		cp := *cd
+		ret = append(ret, &cp)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Shard < ret[j].Shard
+	})
+	return ret
+}
+
+func (c *Coordinator) setCordons(cordons []*ShardCordon) {
+	c.cordonLock.Lock()
+	defer c.cordonLock.Unlock()
+	c.cordons = map[string]*ShardCordon{}
+	for _, cd := range cordons {
+		cp := *cd
+		c.cordons[cd.Shard] = &cp
+	}
+}
+
+func (c *Coordinator) isCordoned(id string) bool {
+	c.cordonLock.Lock()
+	defer c.cordonLock.Unlock()
+	return c.cordons[id] != nil
+}
+
+// markCordoned set cordoned and draining flag of shards
+func (c *Coordinator) markCordoned(shards []*shardInfo) {
+	c.cordonLock.Lock()
+	defer c.cordonLock.Unlock()
+	for _, s := range shards {
+		if cd := c.cordons[s.shard.ID]; cd != nil {
+			s.cordoned = true
+			s.draining = cd.Drain
+		}
+	}
+}
+
+// drainShards try transfer all targets of draining shards to other shards
+// the space of targets that can not be transferred is returned
+func (c *Coordinator) drainShards(changeAbleShards []*shardInfo) space {
+	needSpace := space{}
+	for _, s := range changeAbleShards {
+		if !s.draining {
+			continue
+		}
+
+		// targets are drained in hash order, so that the result is stable
+		hashes := make([]uint64, 0, len(s.scraping))
+		for hash := range s.scraping {
+			hashes = append(hashes, hash)
+		}
+		sort.Slice(hashes, func(i, j int) bool {
+			return hashes[i] < hashes[j]
+		})
+
+		for _, hash := range hashes {
+			tar := s.scraping[hash]
+			if tar.TargetState != target.StateNormal {
+				continue
+			}
+
+			if c.pinnedTo(hash) == s.shard.ID {
+				c.log.Warnf("target %d is pinned to draining shard %s, unpin it to drain", hash, s.shard.ID)
+				continue
+			}
+
+			tarSp := c.targetSpace(hash, tar)
+			candidates, limited := c.budget.filter(s, c.freeShards(changeAbleShards, tarSp), tar.Series)
+			// the rest targets will be transferred in later coordinating
+			if limited {
+				continue
+			}
+
+			to := c.schedule(candidates, hash, tarSp)
+			if to == nil {
+				needSpace.add(tarSp)
+				continue
+			}
+
+			c.log.Infof("drain target %d from %s to %s series = (%d)", hash, s.shard.ID, to.shard.ID, tar.Series)
+			c.transferTarget(s, to, hash, "drain shard")
+		}
+	}
+	return needSpace
+}
+
+// updateCordonProgress record the targets still on cordoned shards
+func (c *Coordinator) updateCordonProgress(shards []*shardInfo) {
+	pins := c.Pins()
+	c.cordonLock.Lock()
+	defer c.cordonLock.Unlock()
+	for _, s := range shards {
+		cd := c.cordons[s.shard.ID]
+		if cd == nil || !s.changeAble {
+			continue
+		}
+
+		cd.Targets = len(s.scraping)
+		cd.InTransfer = 0
+		cd.Pinned = 0
+		for hash, tar := range s.scraping {
+			if tar.TargetState == target.StateInTransfer {
+				cd.InTransfer++
+			}
+			if pins[hash] == s.shard.ID {
+				cd.Pinned++
+			}
+		}
+		cd.Drained = cd.Drain && cd.Targets == 0
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/target"
+	"tkestack.io/kvass/pkg/utils/types"
+)
+
+func TestCoordinator_CordonShard(t *testing.T) {
+	r := require.New(t)
+	c := &Coordinator{}
+	r.Error(c.CordonShard("", false))
+	r.Error(c.UncordonShard("s0"))
+
+	r.NoError(c.CordonShard("s1", false))
+	r.NoError(c.CordonShard("s0", false))
+	r.NoError(c.CordonShard("s0", true))
+	cds := c.Cordons()
+	r.Equal(2, len(cds))
+	r.Equal("s0", cds[0].Shard)
+	r.True(cds[0].Drain)
+
+	r.NoError(c.UncordonShard("s0"))
+	r.Equal(1, len(c.Cordons()))
+}
+
+func TestCoordinator_DrainShards(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	shards := newTestingMoveShards()
+	shards[0].scraping[2] = &target.ScrapeStatus{Series: 95, Health: scrape.HealthGood, TargetState: target.StateNormal}
+	r.NoError(c.CordonShard("0", true))
+	r.NoError(c.CordonShard("2", false))
+	c.markCordoned(shards)
+	r.True(shards[0].draining)
+	r.True(shards[2].cordoned)
+	r.False(shards[2].draining)
+
+	// target 1 is transferred to shard 1, target 2 is too big for the rest space of shard 1
+	needSpace := c.drainShards(shards)
+	r.Equal(int64(95), needSpace.headSpace)
+	r.Equal(target.StateInTransfer, shards[0].scraping[1].TargetState)
+	r.NotNil(shards[1].scraping[1])
+	r.Nil(shards[2].scraping[1])
+
+	c.updateCordonProgress(shards)
+	cds := c.Cordons()
+	r.Equal(2, cds[0].Targets)
+	r.Equal(1, cds[0].InTransfer)
+	r.False(cds[0].Drained)
+
+	// target pinned to draining shard is kept
+	shards[0].scraping[3] = &target.ScrapeStatus{Series: 1, Health: scrape.HealthGood, TargetState: target.StateNormal}
+	c.setPins(map[uint64]string{3: "0"})
+	c.drainShards(shards)
+	r.Equal(target.StateNormal, shards[0].scraping[3].TargetState)
+	c.updateCordonProgress(shards)
+	r.Equal(1, c.Cordons()[0].Pinned)
+
+	shards[0].scraping = map[uint64]*target.ScrapeStatus{}
+	c.updateCordonProgress(shards)
+	r.True(c.Cordons()[0].Drained)
+	r.False(c.Cordons()[1].Drained)
+}
+
+func TestCoordinator_CordonedShardNotScheduled(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	c.option.MaxIdleTime = time.Second
+	shards := newTestingMoveShards()
+	shards[2].runtime.IdleStartAt = types.TimePtr(time.Now().Add(-time.Minute))
+	r.NoError(c.CordonShard("1", false))
+	r.NoError(c.CordonShard("2", false))
+	c.markCordoned(shards)
+
+	r.Equal([]*shardInfo{shards[0]}, c.freeShards(shards, space{}))
+	// idle cordoned shard is not removed
+	r.Equal(int32(3), c.tryScaleDown(shards))
+}
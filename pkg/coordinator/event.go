@@ -0,0 +1,200 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/shard"
+)
+
+const (
+	// EventAssign means target is assigned to a shard
+	EventAssign = "assign"
+	// EventTransferStart means target is marked as in_transfer and assigned to another shard
+	EventTransferStart = "transfer-start"
+	// EventTransferComplete means in_transfer target is deleted from source shard
+	EventTransferComplete = "transfer-complete"
+	// EventGC means target is deleted from shard
+	EventGC = "gc"
+	// EventScaleUp means shards number of replica is increased
+	EventScaleUp = "scale-up"
+	// EventScaleDown means shards number of replica is decreased
+	EventScaleDown = "scale-down"
+	// EventMoveSkipped means manual move of target is dropped since its destination can not receive it
+	EventMoveSkipped = "move-skipped"
+)
+
+// Event is a decision made by coordinator
+type Event struct {
+	// Time is the time this event happened
+	Time time.Time `json:"time"`
+	// Type is the type of event, see EventAssign .etc
+	Type string `json:"type"`
+	// Hash is the hash of target, empty if this is not a target event
+	Hash uint64 `json:"hash,omitempty"`
+	// Job is the job of target, empty if this is not a target event or target is not active any more
+	Job string `json:"job,omitempty"`
+	// From is the source shard of target
+	From string `json:"from,omitempty"`
+	// To is the destination shard of target
+	To string `json:"to,omitempty"`
+	// FromScale is the shards number before scaling
+	FromScale int32 `json:"fromScale,omitempty"`
+	// ToScale is the shards number after scaling
+	ToScale int32 `json:"toScale,omitempty"`
+	// Reason explain why this decision is made
+	Reason string `json:"reason"`
+}
+
+// String return a readable message of event
+func (e *Event) String() string {
+	switch e.Type {
+	case EventScaleUp, EventScaleDown:
+		return fmt.Sprintf("%s from %d to %d: %s", e.Type, e.FromScale, e.ToScale, e.Reason)
+	default:
+		msg := fmt.Sprintf("%s target %d", e.Type, e.Hash)
+		if e.Job != "" {
+			msg += fmt.Sprintf(" of job %s", e.Job)
+		}
+		if e.From != "" {
+			msg += fmt.Sprintf(" from %s", e.From)
+		}
+		if e.To != "" {
+			msg += fmt.Sprintf(" to %s", e.To)
+		}
+		return msg + ": " + e.Reason
+	}
+}
+
+// EventRecorder keep the latest events in a ring buffer
+type EventRecorder struct {
+	lock   sync.Mutex
+	events []*Event
+	next   int
+	full   bool
+}
+
+// NewEventRecorder create a EventRecorder that keep at most "size" events
+func NewEventRecorder(size int) *EventRecorder {
+	if size <= 0 {
+		size = 1
+	}
+	return &EventRecorder{events: make([]*Event, size)}
+}
+
+// Record save event to buffer, the oldest event will be dropped if buffer is full
+func (r *EventRecorder) Record(events ...*Event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, e := range events {
+		r.events[r.next] = e
+		r.next = (r.next + 1) % len(r.events)
+		if r.next == 0 {
+			r.full = true
+		}
+	}
+}
+
+// Events return all events in buffer, the oldest is the first one
+func (r *EventRecorder) Events() []*Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.full {
+		return append([]*Event{}, r.events[:r.next]...)
+	}
+	return append(append([]*Event{}, r.events[r.next:]...), r.events[:r.next]...)
+}
+
+// targetEvent record a target event during coordinating, it will be committed after decisions are applied
+func (c *Coordinator) targetEvent(tp string, hash uint64, from, to *shardInfo, reason string) {
+	e := &Event{
+		Time:   time.Now(),
+		Type:   tp,
+		Hash:   hash,
+		Reason: reason,
+	}
+
+	if from != nil {
+		e.From = from.shard.ID
+	}
+
+	if to != nil {
+		e.To = to.shard.ID
+	}
+	c.pendingEvents = append(c.pendingEvents, e)
+}
+
+// scaleEvent record a scaling event during coordinating
+func (c *Coordinator) scaleEvent(from, to int32, reason string) {
+	if from == to {
+		return
+	}
+
+	e := &Event{
+		Time:      time.Now(),
+		Type:      EventScaleUp,
+		FromScale: from,
+		ToScale:   to,
+		Reason:    reason,
+	}
+
+	if to < from {
+		e.Type = EventScaleDown
+	}
+	c.pendingEvents = append(c.pendingEvents, e)
+}
+
+// commitEvents save pending events to recorder and report them if replica is a shard.EventReporter
+func (c *Coordinator) commitEvents(repItem shard.Manager, active map[uint64]*discovery.SDTargets) {
+	reporter, _ := repItem.(shard.EventReporter)
+	for _, e := range c.pendingEvents {
+		if tar := active[e.Hash]; tar != nil {
+			e.Job = tar.Job
+		}
+
+		if reporter != nil {
+			reporter.ReportEvent(e.Type, e.String())
+		}
+	}
+
+	if c.events != nil {
+		c.events.Record(c.pendingEvents...)
+	}
+	c.pendingEvents = nil
+}
+
+// filterEvents return events with specific type, job and target hash, empty value means no filter
+func filterEvents(events []*Event, tp, job string, hash uint64) []*Event {
+	ret := make([]*Event, 0)
+	for _, e := range events {
+		if (tp != "" && !strings.EqualFold(tp, e.Type)) ||
+			(job != "" && job != e.Job) ||
+			(hash != 0 && hash != e.Hash) {
+			continue
+		}
+		ret = append(ret, e)
+	}
+	return ret
+}
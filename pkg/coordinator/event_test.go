@@ -0,0 +1,113 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventRecorder(t *testing.T) {
+	var cases = []struct {
+		name       string
+		size       int
+		record     []uint64
+		wantHashes []uint64
+	}{
+		{
+			name:       "not full",
+			size:       3,
+			record:     []uint64{1, 2},
+			wantHashes: []uint64{1, 2},
+		},
+		{
+			name:       "full",
+			size:       3,
+			record:     []uint64{1, 2, 3},
+			wantHashes: []uint64{1, 2, 3},
+		},
+		{
+			name:       "oldest events dropped",
+			size:       3,
+			record:     []uint64{1, 2, 3, 4, 5},
+			wantHashes: []uint64{3, 4, 5},
+		},
+		{
+			name:       "empty",
+			size:       3,
+			wantHashes: []uint64{},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			rd := NewEventRecorder(cs.size)
+			for _, h := range cs.record {
+				rd.Record(&Event{Type: EventAssign, Hash: h})
+			}
+
+			hashes := make([]uint64, 0)
+			for _, e := range rd.Events() {
+				hashes = append(hashes, e.Hash)
+			}
+			r.Equal(cs.wantHashes, hashes)
+		})
+	}
+}
+
+func TestEvent_String(t *testing.T) {
+	var cases = []struct {
+		name  string
+		event *Event
+		want  string
+	}{
+		{
+			name:  "transfer",
+			event: &Event{Type: EventTransferStart, Hash: 1, Job: "job1", From: "s0", To: "s1", Reason: "alleviate head series"},
+			want:  "transfer-start target 1 of job job1 from s0 to s1: alleviate head series",
+		},
+		{
+			name:  "gc",
+			event: &Event{Type: EventGC, Hash: 1, From: "s0", Reason: "target is not active"},
+			want:  "gc target 1 from s0: target is not active",
+		},
+		{
+			name:  "scale",
+			event: &Event{Type: EventScaleDown, FromScale: 3, ToScale: 2, Reason: "idle"},
+			want:  "scale-down from 3 to 2: idle",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			require.Equal(t, cs.want, cs.event.String())
+		})
+	}
+}
+
+func TestCoordinator_ScaleEvent(t *testing.T) {
+	r := require.New(t)
+	c := &Coordinator{}
+	c.scaleEvent(2, 2, "")
+	r.Empty(c.pendingEvents)
+
+	c.scaleEvent(2, 1, "idle")
+	r.Equal(EventScaleDown, c.pendingEvents[0].Type)
+}
@@ -0,0 +1,77 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"github.com/pkg/errors"
+	"tkestack.io/kvass/pkg/scrape"
+	"tkestack.io/kvass/pkg/shard"
+	"tkestack.io/kvass/pkg/target"
+)
+
+// ExploreBySidecar explore target by ready shards in turn, so that exploring is balanced across all shards
+// handled is false if no shard is ready or the sidecar can not be reached, target should be explored locally then
+func (c *Coordinator) ExploreBySidecar(job string, t *target.Target) (*scrape.StatisticsSeriesResult, bool, error) {
+	s := c.nextExploreShard()
+	if s == nil {
+		c.metrics.exploreDelegatedTotal.WithLabelValues("fallback").Inc()
+		return nil, false, nil
+	}
+
+	ret, err := s.Explore(job, t)
+	if err != nil {
+		c.log.Warnf("%s, explore target %d locally", err.Error(), t.Hash)
+		c.metrics.exploreDelegatedTotal.WithLabelValues("fallback").Inc()
+		return nil, false, nil
+	}
+
+	if ret.Error != "" {
+		c.metrics.exploreDelegatedTotal.WithLabelValues("failed").Inc()
+		return nil, true, errors.Errorf("explored by %s: %s", s.ID, ret.Error)
+	}
+
+	c.metrics.exploreDelegatedTotal.WithLabelValues("success").Inc()
+	return ret.Result, true, nil
+}
+
+// nextExploreShard return the next ready shard that exploring can be delegated to, nil is returned if no shard is ready
+func (c *Coordinator) nextExploreShard() *shard.Shard {
+	c.exploreLock.Lock()
+	defer c.exploreLock.Unlock()
+
+	if len(c.exploreShards) == 0 {
+		return nil
+	}
+
+	c.exploreNext = (c.exploreNext + 1) % len(c.exploreShards)
+	return c.exploreShards[c.exploreNext]
+}
+
+// setExploreShards replace the shards that exploring can be delegated to with the ready ones of "shards"
+func (c *Coordinator) setExploreShards(shards []*shard.Shard) {
+	ready := make([]*shard.Shard, 0, len(shards))
+	for _, s := range shards {
+		if s.Ready {
+			ready = append(ready, s)
+		}
+	}
+
+	c.exploreLock.Lock()
+	defer c.exploreLock.Unlock()
+	c.exploreShards = ready
+}
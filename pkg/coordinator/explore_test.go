@@ -0,0 +1,114 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/scrape"
+	"tkestack.io/kvass/pkg/shard"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func newTestingExploreShard(id string, ready bool, ret *shard.ExploreResult, err error, called map[string]int) *shard.Shard {
+	s := shard.NewShard(id, "", ready, logrus.New())
+	s.APIPost = func(url string, req interface{}, res interface{}) error {
+		called[id]++
+		if err != nil {
+			return err
+		}
+		*(res.(*shard.ExploreResult)) = *ret
+		return nil
+	}
+	return s
+}
+
+func TestCoordinator_ExploreBySidecar(t *testing.T) {
+	var cases = []struct {
+		name        string
+		ready       bool
+		ret         *shard.ExploreResult
+		err         error
+		wantHandled bool
+		wantErr     bool
+	}{
+		{
+			name:        "explored by sidecar",
+			ready:       true,
+			ret:         &shard.ExploreResult{Result: &scrape.StatisticsSeriesResult{Total: 10}},
+			wantHandled: true,
+		},
+		{
+			name:        "explore failed by sidecar",
+			ready:       true,
+			ret:         &shard.ExploreResult{Error: "test"},
+			wantHandled: true,
+			wantErr:     true,
+		},
+		{
+			name:  "sidecar unreachable",
+			ready: true,
+			err:   fmt.Errorf("test"),
+		},
+		{
+			name: "no ready shard",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			called := map[string]int{}
+			c := &Coordinator{log: logrus.New(), metrics: newMetrics()}
+			c.setExploreShards([]*shard.Shard{newTestingExploreShard("s0", cs.ready, cs.ret, cs.err, called)})
+
+			result, handled, err := c.ExploreBySidecar("job1", &target.Target{Hash: 1})
+			r.Equal(cs.wantHandled, handled)
+			if cs.wantErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			if handled {
+				r.Equal(float64(10), result.Total)
+			}
+		})
+	}
+}
+
+func TestCoordinator_ExploreBalanced(t *testing.T) {
+	r := require.New(t)
+	called := map[string]int{}
+	ret := &shard.ExploreResult{Result: scrape.NewStatisticsSeriesResult()}
+	c := &Coordinator{log: logrus.New(), metrics: newMetrics()}
+	c.setExploreShards([]*shard.Shard{
+		newTestingExploreShard("s0", true, ret, nil, called),
+		newTestingExploreShard("s1", true, ret, nil, called),
+		newTestingExploreShard("s2", false, ret, nil, called),
+	})
+
+	for i := 0; i < 10; i++ {
+		_, handled, err := c.ExploreBySidecar("job1", &target.Target{Hash: uint64(i)})
+		r.NoError(err)
+		r.True(handled)
+	}
+	r.Equal(map[string]int{"s0": 5, "s1": 5}, called)
+}
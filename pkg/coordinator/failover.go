@@ -0,0 +1,93 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"time"
+
+	"tkestack.io/kvass/pkg/shard"
+	"tkestack.io/kvass/pkg/target"
+)
+
+// markFailover treat targets of shards that are not changeAble for more than FailoverGracePeriod as unscraped
+// so that they are assigned to other shards, duplicates are deleted by gcTargets after shard come back
+// within grace period, targets of unreachable shards are still covered by the last known targets of shards
+// "before" is the snapshot of shards before coordinating, it is kept as known targets without copying
+func (c *Coordinator) markFailover(shards []*shardInfo, before scrapingSnapshot) {
+	if c.option.FailoverGracePeriod == 0 {
+		return
+	}
+
+	if c.unhealthySince == nil {
+		c.unhealthySince = map[string]time.Time{}
+	}
+	if c.knownScraping == nil {
+		c.knownScraping = map[string]map[uint64]*target.ScrapeStatus{}
+	}
+
+	now := time.Now()
+	for _, s := range shards {
+		id := s.shard.ID
+		if s.changeAble {
+			delete(c.unhealthySince, id)
+			c.knownScraping[id] = before[s]
+			continue
+		}
+
+		since, exist := c.unhealthySince[id]
+		if !exist {
+			since = now
+			c.unhealthySince[id] = now
+		}
+
+		if now.Sub(since) < c.option.FailoverGracePeriod {
+			// known targets are copied since they may be changed by coordinating
+			if len(s.scraping) == 0 {
+				s.scraping = map[uint64]*target.ScrapeStatus{}
+				for h, st := range c.knownScraping[id] {
+					cp := *st
+					s.scraping[h] = &cp
+				}
+			}
+			continue
+		}
+
+		c.log.Warnf("%s is unhealthy for more than %s, its targets will be assigned to other shards", id, c.option.FailoverGracePeriod)
+		s.scraping = map[uint64]*target.ScrapeStatus{}
+	}
+}
+
+// pruneFailover delete the failover records of shards that are not in "shards" any more
+func (c *Coordinator) pruneFailover(shards []*shard.Shard) {
+	exist := make(map[string]bool, len(shards))
+	for _, s := range shards {
+		exist[s.ID] = true
+	}
+
+	for id := range c.unhealthySince {
+		if !exist[id] {
+			delete(c.unhealthySince, id)
+		}
+	}
+
+	for id := range c.knownScraping {
+		if !exist[id] {
+			delete(c.knownScraping, id)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/shard"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func TestCoordinator_MarkFailover(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	shards := newTestingMoveShards()
+
+	// failover is disabled
+	shards[0].changeAble = false
+	shards[0].scraping = nil
+	c.markFailover(shards, snapshotScraping(shards))
+	r.Nil(shards[0].scraping)
+
+	c.option.FailoverGracePeriod = time.Minute
+	c.option.MaxHeadSeries = 100
+	c.option.MaxProcessSeries = 1000
+	shards = newTestingMoveShards()
+	c.markFailover(shards, snapshotScraping(shards))
+	r.Empty(c.unhealthySince)
+
+	// shard 0 become unreachable, its last known targets are still covered
+	shards = newTestingMoveShards()
+	shards[0].changeAble = false
+	shards[0].scraping = nil
+	c.markFailover(shards, snapshotScraping(shards))
+	r.NotNil(shards[0].scraping[1])
+	r.Contains(c.unhealthySince, "0")
+	// known targets are not changed by coordinating
+	shards[0].scraping[1].TargetState = target.StateInTransfer
+	r.Equal(target.StateNormal, c.knownScraping["0"][1].TargetState)
+
+	status := map[uint64]*target.ScrapeStatus{
+		1: {Series: 10, Health: scrape.HealthGood},
+	}
+	c.assignNoScrapingTargets(shards, c.getActive(), status, c.option)
+	r.Nil(shards[1].scraping[1])
+	r.Nil(shards[2].scraping[1])
+
+	// grace period passed, targets are assigned to other shards
+	c.unhealthySince["0"] = time.Now().Add(-time.Minute * 2)
+	shards = newTestingMoveShards()
+	shards[0].changeAble = false
+	c.markFailover(shards, snapshotScraping(shards))
+	r.Empty(shards[0].scraping)
+	c.assignNoScrapingTargets(shards, c.getActive(), status, c.option)
+	r.True(shards[1].scraping[1] != nil || shards[2].scraping[1] != nil)
+
+	// shard come back
+	shards = newTestingMoveShards()
+	c.markFailover(shards, snapshotScraping(shards))
+	r.Empty(c.unhealthySince)
+}
+
+func TestCoordinator_PruneFailover(t *testing.T) {
+	r := require.New(t)
+	c := &Coordinator{
+		unhealthySince: map[string]time.Time{"0": time.Now(), "1": time.Now()},
+		knownScraping:  map[string]map[uint64]*target.ScrapeStatus{"0": {}, "2": {}},
+	}
+
+	c.pruneFailover([]*shard.Shard{{ID: "0"}})
+	r.Equal(1, len(c.unhealthySince))
+	r.Contains(c.unhealthySince, "0")
+	r.Equal(1, len(c.knownScraping))
+	r.Contains(c.knownScraping, "0")
+}
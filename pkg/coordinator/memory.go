@@ -0,0 +1,76 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+// initMemory record the memory cost of every head series according to runtime info reported by sidecar
+// memory of shard is unknown if sidecar does not report resident memory or head series is 0
+func (s *shardInfo) initMemory() {
+	s.fetchedHeadSeries = s.runtime.HeadSeries
+	s.bytesPerSeries = 0
+	if s.runtime.ResidentMemory > 0 && s.runtime.HeadSeries > 0 {
+		s.bytesPerSeries = float64(s.runtime.ResidentMemory) / float64(s.runtime.HeadSeries)
+	}
+}
+
+// estimatedMemory return the expected resident memory of shard after "series" more head series is added
+// head series changed during this coordinating are taken into account
+func (s *shardInfo) estimatedMemory(series int64) int64 {
+	added := s.runtime.HeadSeries - s.fetchedHeadSeries + series
+	return s.runtime.ResidentMemory + int64(float64(added)*s.bytesPerSeries)
+}
+
+// memoryCanHold return true if shard is still under max memory after "series" more head series is added
+// it is always true if max memory is not set or memory of shard is unknown
+func (s *shardInfo) memoryCanHold(series int64) bool {
+	if s.maxMemoryBytes == 0 || s.bytesPerSeries == 0 {
+		return true
+	}
+	return s.estimatedMemory(series) < s.maxMemoryBytes
+}
+
+// alleviateShardsMemory transfer targets from shards whose resident memory is over max memory
+// the head series that can not be transferred is returned
+func (c *Coordinator) alleviateShardsMemory(changeAbleShards []*shardInfo) int64 {
+	needSpace := int64(0)
+	for _, s := range changeAbleShards {
+		if s.maxMemoryBytes == 0 || s.bytesPerSeries == 0 || s.runtime.ResidentMemory < s.maxMemoryBytes {
+			continue
+		}
+
+		c.log.Infof("resident memory of %s is %d, over max memory %d", s.shard.ID, s.runtime.ResidentMemory, s.maxMemoryBytes)
+		needSpace += c.alleviateShardHeadSeries(s, changeAbleShards, int64(float64(s.maxMemoryBytes)/s.bytesPerSeries))
+	}
+	return needSpace
+}
+
+// avgBytesPerSeries return the average memory cost of every head series of shards, 0 is returned if unknown
+func avgBytesPerSeries(shards []*shardInfo) float64 {
+	memory, series := int64(0), int64(0)
+	for _, s := range shards {
+		if s.bytesPerSeries == 0 {
+			continue
+		}
+		memory += s.runtime.ResidentMemory
+		series += s.fetchedHeadSeries
+	}
+
+	if series == 0 {
+		return 0
+	}
+	return float64(memory) / float64(series)
+}
@@ -0,0 +1,127 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/target"
+)
+
+// newTestingMemoryShardInfo create a shard that every head series cost 10 bytes
+func newTestingMemoryShardInfo(id string, headSeries int64, maxMemory int64) *shardInfo {
+	s := newTestingShardInfo(id, headSeries)
+	s.maxHeadSeries = 0
+	s.maxMemoryBytes = maxMemory
+	s.runtime.ResidentMemory = headSeries * 10
+	s.scraping = map[uint64]*target.ScrapeStatus{}
+	s.initMemory()
+	return s
+}
+
+func TestShardInfo_MemoryCanHold(t *testing.T) {
+	var cases = []struct {
+		name      string
+		shard     *shardInfo
+		series    int64
+		wantAdded int64
+		want      bool
+	}{
+		{
+			name:   "max memory not set",
+			shard:  newTestingMemoryShardInfo("0", 100, 0),
+			series: 1000,
+			want:   true,
+		},
+		{
+			name:   "memory unknown",
+			shard:  newTestingMemoryShardInfo("0", 0, 100),
+			series: 1000,
+			want:   true,
+		},
+		{
+			name:   "memory enough",
+			shard:  newTestingMemoryShardInfo("0", 10, 1000),
+			series: 80,
+			want:   true,
+		},
+		{
+			name:   "memory not enough",
+			shard:  newTestingMemoryShardInfo("0", 10, 1000),
+			series: 90,
+			want:   false,
+		},
+		{
+			name:      "head series added in this coordinating is counted",
+			shard:     newTestingMemoryShardInfo("0", 10, 1000),
+			series:    40,
+			wantAdded: 50,
+			want:      false,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			cs.shard.runtime.HeadSeries += cs.wantAdded
+			require.Equal(t, cs.want, cs.shard.memoryCanHold(cs.series))
+		})
+	}
+}
+
+func TestCoordinator_AlleviateShardsMemory(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	shards := []*shardInfo{
+		newTestingMemoryShardInfo("0", 100, 800),
+		newTestingMemoryShardInfo("1", 10, 800),
+	}
+	for i := uint64(1); i <= 5; i++ {
+		shards[0].scraping[i] = &target.ScrapeStatus{
+			Series:      20,
+			Health:      scrape.HealthGood,
+			TargetState: target.StateNormal,
+			ScrapeTimes: minWaitScrapeTimes,
+		}
+	}
+
+	// 20 series must be transferred to make memory of shard 0 under 800 bytes
+	r.Equal(int64(0), c.alleviateShardsMemory(shards))
+	r.Equal(1, len(shards[1].scraping))
+
+	// shard 1 can not receive more targets
+	shards[1].maxMemoryBytes = 150
+	shards[0].runtime.ResidentMemory = 1000
+	shards[0].maxMemoryBytes = 500
+	r.Equal(int64(30), c.alleviateShardsMemory(shards))
+}
+
+func TestCoordinator_TryScaleUpMemory(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	shards := []*shardInfo{newTestingMemoryShardInfo("0", 100, 1000)}
+	opt := &Option{MaxProcessSeries: 100000, MaxMemoryBytes: 1000}
+
+	// 250 series cost 2500 bytes, 3 more shards are needed
+	r.Equal(int32(4), c.tryScaleUp(shards, space{headSpace: 250}, opt))
+
+	// memory is skipped if unknown
+	shards[0].bytesPerSeries = 0
+	r.Equal(int32(2), c.tryScaleUp(shards, space{headSpace: 250}, opt))
+}
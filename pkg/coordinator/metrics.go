@@ -0,0 +1,240 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// unassignedUnhealthy means target is not explored yet or last scraping is failed
+	unassignedUnhealthy = "unhealthy"
+	// unassignedTooBig means target can not be held by any shard
+	unassignedTooBig = "too_big"
+	// unassignedNoSpace means no shard has enough space to receive target now
+	unassignedNoSpace = "no_space"
+	// unassignedQuota means quota of target is exceeded
+	unassignedQuota = "quota"
+)
+
+// metrics contains all metrics of one Coordinator
+// every tenant has its own Coordinator and metrics, which are registered with a "tenant" const label
+type metrics struct {
+	failed                 *prometheus.CounterVec
+	assignTargetsTotal     *prometheus.CounterVec
+	alleviateShardsTotal   *prometheus.CounterVec
+	transferTargetsTotal   *prometheus.CounterVec
+	transferBacklogTargets *prometheus.GaugeVec
+	transferBacklogSeries  *prometheus.GaugeVec
+	shardAlleviateTier     *prometheus.GaugeVec
+	quotaSeries            *prometheus.GaugeVec
+	quotaMaxSeries         *prometheus.GaugeVec
+	quotaTargets           *prometheus.GaugeVec
+	quotaMaxTargets        *prometheus.GaugeVec
+	quotaRejectedTargets   *prometheus.GaugeVec
+	exploreDelegatedTotal  *prometheus.CounterVec
+	coordinateDuration     *prometheus.HistogramVec
+	shardHeadSeries        *prometheus.GaugeVec
+	shardProcessSeries     *prometheus.GaugeVec
+	shardTargets           *prometheus.GaugeVec
+	shardInTransferTargets *prometheus.GaugeVec
+	replicaShards          *prometheus.GaugeVec
+	replicaExpectShards    *prometheus.GaugeVec
+	unassignedTargets      *prometheus.GaugeVec
+
+	// setLabels is the label values of gauges set in current coordinating
+	setLabels map[*prometheus.GaugeVec]map[string][]string
+	// lastLabels is the label values of gauges set in last coordinating
+	lastLabels map[*prometheus.GaugeVec]map[string][]string
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kvass_coordinator_failed_total",
+		}, []string{}),
+		assignTargetsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kvass_coordinator_assign_targets_total",
+		}, []string{}),
+		alleviateShardsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kvass_coordinator_alleviate_shards_total",
+		}, []string{}),
+		transferTargetsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kvass_coordinator_transfer_targets_total",
+		}, []string{}),
+		transferBacklogTargets: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_transfer_backlog_targets",
+			Help: "number of target transfers deferred by transfer budget in last coordinating",
+		}, []string{}),
+		transferBacklogSeries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_transfer_backlog_series",
+			Help: "series of target transfers deferred by transfer budget in last coordinating",
+		}, []string{}),
+		shardAlleviateTier: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_shard_alleviate_tier",
+			Help: "max series rate of the alleviation tier shard is in, 0 if shard is not overload",
+		}, []string{"shard"}),
+		quotaSeries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_quota_series",
+			Help: "head series of assigned targets of quota group",
+		}, []string{"group"}),
+		quotaMaxSeries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_quota_max_series",
+			Help: "max head series of quota group, 0 means no limit",
+		}, []string{"group"}),
+		quotaTargets: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_quota_targets",
+			Help: "number of assigned targets of quota group",
+		}, []string{"group"}),
+		quotaMaxTargets: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_quota_max_targets",
+			Help: "max number of targets of quota group, 0 means no limit",
+		}, []string{"group"}),
+		quotaRejectedTargets: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_quota_rejected_targets",
+			Help: "number of targets not assigned because quota group is exceeded",
+		}, []string{"group"}),
+		exploreDelegatedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kvass_coordinator_explore_delegated_total",
+			Help: "number of targets explored by sidecars, result is 'success', 'failed' or 'fallback' (explored locally)",
+		}, []string{"result"}),
+		coordinateDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kvass_coordinator_coordinate_duration_seconds",
+			Help:    "duration of coordinating one replica",
+			Buckets: []float64{0.1, 0.3, 0.5, 1, 3, 5, 10, 30, 60},
+		}, []string{"replica", "pool"}),
+		shardHeadSeries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_shard_head_series",
+			Help: "expected head series of shard after last coordinating",
+		}, []string{"replica", "pool", "shard"}),
+		shardProcessSeries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_shard_process_series",
+			Help: "expected process series of shard after last coordinating",
+		}, []string{"replica", "pool", "shard"}),
+		shardTargets: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_shard_targets",
+			Help: "number of targets of shard after last coordinating, in_transfer targets are included",
+		}, []string{"replica", "pool", "shard"}),
+		shardInTransferTargets: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_shard_in_transfer_targets",
+			Help: "number of in_transfer targets of shard after last coordinating",
+		}, []string{"replica", "pool", "shard"}),
+		replicaShards: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_replica_shards",
+			Help: "shard number of replica before last coordinating",
+		}, []string{"replica", "pool"}),
+		replicaExpectShards: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_replica_expect_shards",
+			Help: "shard number replica is expected to be scaled to by last coordinating",
+		}, []string{"replica", "pool"}),
+		unassignedTargets: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_coordinator_unassigned_targets",
+			Help: "number of active targets not assigned to any shard in last coordinating",
+		}, []string{"reason"}),
+		setLabels:  map[*prometheus.GaugeVec]map[string][]string{},
+		lastLabels: map[*prometheus.GaugeVec]map[string][]string{},
+	}
+}
+
+func (m *metrics) register(promRegisterer prometheus.Registerer) {
+	for _, col := range []prometheus.Collector{
+		m.failed,
+		m.assignTargetsTotal,
+		m.alleviateShardsTotal,
+		m.transferTargetsTotal,
+		m.transferBacklogTargets,
+		m.transferBacklogSeries,
+		m.shardAlleviateTier,
+		m.quotaSeries,
+		m.quotaMaxSeries,
+		m.quotaTargets,
+		m.quotaMaxTargets,
+		m.quotaRejectedTargets,
+		m.exploreDelegatedTotal,
+		m.coordinateDuration,
+		m.shardHeadSeries,
+		m.shardProcessSeries,
+		m.shardTargets,
+		m.shardInTransferTargets,
+		m.replicaShards,
+		m.replicaExpectShards,
+		m.unassignedTargets,
+	} {
+		_ = promRegisterer.Register(col)
+	}
+}
+
+// setGauge set gauge "g" with "labels" and record the labels as set in current coordinating
+func (m *metrics) setGauge(g *prometheus.GaugeVec, value float64, labels ...string) {
+	g.WithLabelValues(labels...).Set(value)
+	if m.setLabels[g] == nil {
+		m.setLabels[g] = map[string][]string{}
+	}
+	m.setLabels[g][strings.Join(labels, "\xff")] = labels
+}
+
+// deleteStale delete gauges that set in last coordinating but not in current one, e.g. shards or replicas deleted
+// gauges are never reset, so that they do not disappear while coordinating
+func (m *metrics) deleteStale() {
+	for g, last := range m.lastLabels {
+		for k, labels := range last {
+			if _, exist := m.setLabels[g][k]; !exist {
+				g.DeleteLabelValues(labels...)
+			}
+		}
+	}
+	m.lastLabels = m.setLabels
+	m.setLabels = map[*prometheus.GaugeVec]map[string][]string{}
+}
+
+// updateReplica record the load of shards and the scale of replica "replica" of "pool" according to its plan
+func (m *metrics) updateReplica(replica, pool string, plan *ReplicaPlan, duration time.Duration) {
+	m.coordinateDuration.WithLabelValues(replica, pool).Observe(duration.Seconds())
+	if plan == nil {
+		return
+	}
+
+	m.setGauge(m.replicaShards, float64(plan.CurrentScale), replica, pool)
+	m.setGauge(m.replicaExpectShards, float64(plan.ExpectScale), replica, pool)
+	for _, s := range plan.Shards {
+		// load of unhealthy shard is unknown
+		if !s.ChangeAble {
+			continue
+		}
+
+		m.setGauge(m.shardHeadSeries, float64(s.HeadSeries), replica, pool, s.ID)
+		m.setGauge(m.shardProcessSeries, float64(s.ProcessSeries), replica, pool, s.ID)
+		m.setGauge(m.shardTargets, float64(s.Targets), replica, pool, s.ID)
+		m.setGauge(m.shardInTransferTargets, float64(s.InTransfer), replica, pool, s.ID)
+	}
+}
+
+// updateUnassigned record the number of unassigned targets of every reason
+func (m *metrics) updateUnassigned(kinds map[uint64]string) {
+	counts := map[string]int{}
+	for _, k := range kinds {
+		counts[k]++
+	}
+
+	for _, k := range []string{unassignedUnhealthy, unassignedTooBig, unassignedNoSpace, unassignedQuota} {
+		m.unassignedTargets.WithLabelValues(k).Set(float64(counts[k]))
+	}
+}
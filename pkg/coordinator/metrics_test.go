@@ -0,0 +1,102 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func TestUpdateReplicaMetrics(t *testing.T) {
+	r := require.New(t)
+	m := newMetrics()
+	m.updateReplica("rep-0", "", &ReplicaPlan{
+		CurrentScale: 2,
+		ExpectScale:  3,
+		Shards: []*ShardPlan{
+			{ID: "s0", ChangeAble: true, HeadSeries: 10, ProcessSeries: 20, Targets: 2, InTransfer: 1},
+			{ID: "s1", ChangeAble: false},
+		},
+	}, time.Second)
+
+	r.Equal(float64(2), testutil.ToFloat64(m.replicaShards.WithLabelValues("rep-0", "")))
+	r.Equal(float64(3), testutil.ToFloat64(m.replicaExpectShards.WithLabelValues("rep-0", "")))
+	r.Equal(float64(10), testutil.ToFloat64(m.shardHeadSeries.WithLabelValues("rep-0", "", "s0")))
+	r.Equal(float64(20), testutil.ToFloat64(m.shardProcessSeries.WithLabelValues("rep-0", "", "s0")))
+	r.Equal(float64(2), testutil.ToFloat64(m.shardTargets.WithLabelValues("rep-0", "", "s0")))
+	r.Equal(float64(1), testutil.ToFloat64(m.shardInTransferTargets.WithLabelValues("rep-0", "", "s0")))
+	// not changeable shard is not recorded
+	r.Equal(1, testutil.CollectAndCount(m.shardHeadSeries))
+	m.deleteStale()
+
+	// failed coordinating only record duration, gauges of it are deleted after coordinating
+	m.updateReplica("rep-0", "", nil, time.Second)
+	r.Equal(1, testutil.CollectAndCount(m.replicaShards))
+	m.deleteStale()
+	r.Equal(0, testutil.CollectAndCount(m.replicaShards))
+}
+
+func TestMetrics_DeleteStale(t *testing.T) {
+	r := require.New(t)
+	m := newMetrics()
+	m.setGauge(m.shardAlleviateTier, 0.8, "s0")
+	m.setGauge(m.shardAlleviateTier, 0.9, "s1")
+	m.deleteStale()
+	r.Equal(2, testutil.CollectAndCount(m.shardAlleviateTier))
+
+	// gauges are kept while coordinating, only shard that not set again is deleted
+	m.setGauge(m.shardAlleviateTier, 0.7, "s0")
+	r.Equal(2, testutil.CollectAndCount(m.shardAlleviateTier))
+	m.deleteStale()
+	r.Equal(1, testutil.CollectAndCount(m.shardAlleviateTier))
+	r.Equal(0.7, testutil.ToFloat64(m.shardAlleviateTier.WithLabelValues("s0")))
+}
+
+func TestCoordinator_UnassignedMetrics(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	c.option.MaxHeadSeries = 100
+	c.option.MaxProcessSeries = 1000
+	active := map[uint64]*discovery.SDTargets{
+		1: {Job: "job1", ShardTarget: &target.Target{}},
+		2: {Job: "job1", ShardTarget: &target.Target{}},
+		3: {Job: "job1", ShardTarget: &target.Target{}},
+		4: {Job: "job1", ShardTarget: &target.Target{}},
+	}
+	status := map[uint64]*target.ScrapeStatus{
+		2: {Series: 10, Health: scrape.HealthBad},
+		3: {Series: 200, Health: scrape.HealthGood},
+		4: {Series: 95, Health: scrape.HealthGood},
+	}
+
+	// shard 0 is scraping target 1 and has 10 head series
+	shards := newTestingMoveShards()[:1]
+	c.assignNoScrapingTargets(shards, active, status, c.option)
+	c.metrics.updateUnassigned(c.unassignedKinds)
+
+	r.Equal(map[uint64]string{2: unassignedUnhealthy, 3: unassignedTooBig, 4: unassignedNoSpace}, c.unassignedKinds)
+	r.Equal(3, len(c.unassigned))
+	r.Equal(float64(1), testutil.ToFloat64(c.metrics.unassignedTargets.WithLabelValues(unassignedNoSpace)))
+	r.Equal(float64(0), testutil.ToFloat64(c.metrics.unassignedTargets.WithLabelValues(unassignedQuota)))
+}
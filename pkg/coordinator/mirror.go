@@ -0,0 +1,275 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"github.com/pkg/errors"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/shard"
+	"tkestack.io/kvass/pkg/target"
+)
+
+// placement is the expected targets of every shard of one replica
+type placement struct {
+	// scale is the expected shard number of replica
+	scale int32
+	// shards contains the targets of every shard, index is the shard index
+	shards []map[uint64]*target.ScrapeStatus
+	// limits contains the limits of every shard, index is the shard index
+	limits []shardLimits
+}
+
+// shardLimits is the limits of one shard, zero value of a limit means unlimited
+type shardLimits struct {
+	headSeries     int64
+	processSeries  int64
+	memoryBytes    int64
+	samplesRate    float64
+	targets        int64
+	scrapeDuration float64
+}
+
+func limitsOf(s *shardInfo) shardLimits {
+	return shardLimits{
+		headSeries:     s.maxHeadSeries,
+		processSeries:  s.maxProcessSeries,
+		memoryBytes:    s.maxMemoryBytes,
+		samplesRate:    s.maxSamplesRate,
+		targets:        s.maxTargets,
+		scrapeDuration: s.maxScrapeDuration,
+	}
+}
+
+// add return the limits of a shard that holds targets of both shards, unlimited is kept
+func (l shardLimits) add(o shardLimits) shardLimits {
+	sumInt := func(a, b int64) int64 {
+		if a == 0 || b == 0 {
+			return 0
+		}
+		return a + b
+	}
+	sumFloat := func(a, b float64) float64 {
+		if a == 0 || b == 0 {
+			return 0
+		}
+		return a + b
+	}
+
+	return shardLimits{
+		headSeries:     sumInt(l.headSeries, o.headSeries),
+		processSeries:  sumInt(l.processSeries, o.processSeries),
+		memoryBytes:    sumInt(l.memoryBytes, o.memoryBytes),
+		samplesRate:    sumFloat(l.samplesRate, o.samplesRate),
+		targets:        sumInt(l.targets, o.targets),
+		scrapeDuration: sumFloat(l.scrapeDuration, o.scrapeDuration),
+	}
+}
+
+// covers return true if every limit of "l" is not less than the one of "o"
+func (l shardLimits) covers(o shardLimits) bool {
+	intCovers := func(a, b int64) bool {
+		return a == 0 || (b != 0 && a >= b)
+	}
+	floatCovers := func(a, b float64) bool {
+		return a == 0 || (b != 0 && a >= b)
+	}
+
+	return intCovers(l.headSeries, o.headSeries) &&
+		intCovers(l.processSeries, o.processSeries) &&
+		intCovers(l.memoryBytes, o.memoryBytes) &&
+		floatCovers(l.samplesRate, o.samplesRate) &&
+		intCovers(l.targets, o.targets) &&
+		floatCovers(l.scrapeDuration, o.scrapeDuration)
+}
+
+func newPlacement(shards []*shardInfo, scale int32) *placement {
+	ret := &placement{
+		scale:  scale,
+		shards: make([]map[uint64]*target.ScrapeStatus, 0, len(shards)),
+		limits: make([]shardLimits, 0, len(shards)),
+	}
+
+	for _, s := range shards {
+		m := map[uint64]*target.ScrapeStatus{}
+		for h, st := range s.scraping {
+			cp := *st
+			m[h] = &cp
+		}
+		ret.shards = append(ret.shards, m)
+		ret.limits = append(ret.limits, limitsOf(s))
+	}
+	return ret
+}
+
+// mirrorReplica apply placement "src" of the first replica of the same pool to replica "repItem"
+// targets of shard i of source replica are placed to shard i of this replica, or shard i%n if this replica has only n shards
+// the shard number of this replica is changed to the one of source replica
+// this replica is coordinated normally if it can not mirror source replica, see checkMirror
+func (c *Coordinator) mirrorReplica(
+	repItem shard.Manager,
+	opt *Option,
+	active map[uint64]*discovery.SDTargets,
+	src *placement,
+) (map[uint64]*target.ScrapeStatus, *ReplicaPlan, error) {
+	shards, err := repItem.Shards()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shardsInfo := c.getShardInfos(shards, opt)
+	c.markCordoned(shardsInfo)
+	if err := c.checkMirror(shardsInfo, src); err != nil {
+		c.log.Warnf("replica of pool %s can not mirror the first replica, coordinate it normally: %s", repItem.Pool(), err.Error())
+		status, plan, _, err := c.coordinateShards(repItem, opt, active, shardsInfo)
+		return status, plan, err
+	}
+
+	var (
+		before                 = snapshotScraping(shardsInfo)
+		lastGlobalScrapeStatus = c.globalScrapeStatus(active, shardsInfo)
+	)
+
+	applyPlacement(shardsInfo, src)
+	updateScrapingTargets(shardsInfo, active)
+	plan := newReplicaPlan(repItem.Pool(), before, shardsInfo, active, src.scale)
+	if opt.DryRun {
+		for _, s := range shardsInfo {
+			s.scraping = before[s]
+		}
+		return c.updateScrapeStatusShards(shardsInfo, lastGlobalScrapeStatus), plan, nil
+	}
+
+	c.applyShardsInfo(shardsInfo)
+	if err := repItem.ChangeScale(src.scale); err != nil {
+		return nil, nil, err
+	}
+
+	return c.updateScrapeStatusShards(shardsInfo, lastGlobalScrapeStatus), plan, nil
+}
+
+// checkMirror return an error if "shards" can not mirror placement "src"
+// cordoned shards, targets pinned to these shards and shards smaller than source shards are not supported by mirroring
+// quota is not checked, since targets of mirror are never more than the ones of source replica
+func (c *Coordinator) checkMirror(shards []*shardInfo, src *placement) error {
+	ids := map[string]bool{}
+	for _, s := range shards {
+		if s.cordoned {
+			return errors.Errorf("shard %s is cordoned", s.shard.ID)
+		}
+		ids[s.shard.ID] = true
+	}
+
+	for hash, id := range c.Pins() {
+		if ids[id] {
+			return errors.Errorf("target %d is pinned to shard %s", hash, id)
+		}
+	}
+
+	if len(shards) == 0 {
+		return nil
+	}
+
+	// shard j holds targets of source shard i if i%len(shards) == j
+	need := make([]*shardLimits, len(shards))
+	for i, l := range src.limits {
+		j := i % len(shards)
+		if need[j] == nil {
+			cp := l
+			need[j] = &cp
+		} else {
+			*need[j] = need[j].add(l)
+		}
+	}
+
+	for j, s := range shards {
+		if need[j] != nil && !limitsOf(s).covers(*need[j]) {
+			return errors.Errorf("shard %s is smaller than shards of source replica", s.shard.ID)
+		}
+	}
+	return nil
+}
+
+// applyPlacement change targets of changeable shards according to placement "src"
+// a target that should be moved to other shard is kept as in_transfer until destination shard begin scraping it
+func applyPlacement(shards []*shardInfo, src *placement) {
+	if len(shards) == 0 {
+		return
+	}
+
+	var (
+		want = make([]map[uint64]*target.ScrapeStatus, len(shards))
+		dst  = map[uint64]int{}
+	)
+	for i := range want {
+		want[i] = map[uint64]*target.ScrapeStatus{}
+	}
+
+	for i, ts := range src.shards {
+		j := i % len(shards)
+		for h, st := range ts {
+			// shard j may hold several source shards, normal state is kept over in_transfer
+			if old := want[j][h]; old == nil || old.TargetState != target.StateNormal {
+				want[j][h] = st
+			}
+			if st.TargetState == target.StateNormal {
+				dst[h] = j
+			}
+		}
+	}
+
+	result := make([]map[uint64]*target.ScrapeStatus, len(shards))
+	for j, s := range shards {
+		scraping := map[uint64]*target.ScrapeStatus{}
+		for h, st := range want[j] {
+			old := s.scraping[h]
+			// target leaving source shard is not assigned to a shard that is not scraping it
+			if old == nil && st.TargetState != target.StateNormal {
+				continue
+			}
+
+			cp := *st
+			if old != nil {
+				cp = *old
+			}
+			cp.TargetState = st.TargetState
+			scraping[h] = &cp
+		}
+
+		for h, st := range s.scraping {
+			if scraping[h] != nil {
+				continue
+			}
+
+			k, exist := dst[h]
+			if !exist || !shards[k].changeAble || shards[k].scraping[h] != nil {
+				continue
+			}
+
+			cp := *st
+			cp.TargetState = target.StateInTransfer
+			scraping[h] = &cp
+		}
+		result[j] = scraping
+	}
+
+	for j, s := range shards {
+		if s.changeAble {
+			s.scraping = result[j]
+		}
+	}
+}
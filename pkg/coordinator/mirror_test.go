@@ -0,0 +1,229 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/shard"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func TestApplyPlacement(t *testing.T) {
+	normal := func() *target.ScrapeStatus {
+		return &target.ScrapeStatus{Series: 1, Health: scrape.HealthGood, TargetState: target.StateNormal}
+	}
+	transfer := func() *target.ScrapeStatus {
+		return &target.ScrapeStatus{Series: 1, Health: scrape.HealthGood, TargetState: target.StateInTransfer}
+	}
+
+	var cases = []struct {
+		name      string
+		src       []map[uint64]*target.ScrapeStatus
+		scraping  []map[uint64]*target.ScrapeStatus
+		unhealthy int
+		want      []map[uint64]string
+	}{
+		{
+			name:     "same shard number",
+			src:      []map[uint64]*target.ScrapeStatus{{1: normal()}, {2: normal()}},
+			scraping: []map[uint64]*target.ScrapeStatus{{}, {}},
+			want:     []map[uint64]string{{1: target.StateNormal}, {2: target.StateNormal}},
+		},
+		{
+			name:     "less shards, fallback to index mod shard number",
+			src:      []map[uint64]*target.ScrapeStatus{{1: normal()}, {2: normal()}, {3: normal()}},
+			scraping: []map[uint64]*target.ScrapeStatus{{}, {}},
+			want:     []map[uint64]string{{1: target.StateNormal, 3: target.StateNormal}, {2: target.StateNormal}},
+		},
+		{
+			name:     "normal target is kept over in_transfer one of the same shard",
+			src:      []map[uint64]*target.ScrapeStatus{{1: normal()}, {}, {1: transfer()}},
+			scraping: []map[uint64]*target.ScrapeStatus{{}, {}},
+			want:     []map[uint64]string{{1: target.StateNormal}, {}},
+		},
+		{
+			name:     "target is kept as in_transfer until destination shard scraping it",
+			src:      []map[uint64]*target.ScrapeStatus{{}, {1: normal()}},
+			scraping: []map[uint64]*target.ScrapeStatus{{1: normal()}, {}},
+			want:     []map[uint64]string{{1: target.StateInTransfer}, {1: target.StateNormal}},
+		},
+		{
+			name:     "target is deleted if destination shard is scraping it",
+			src:      []map[uint64]*target.ScrapeStatus{{}, {1: normal()}},
+			scraping: []map[uint64]*target.ScrapeStatus{{1: transfer()}, {1: normal()}},
+			want:     []map[uint64]string{{}, {1: target.StateNormal}},
+		},
+		{
+			name:     "leaving target is not assigned",
+			src:      []map[uint64]*target.ScrapeStatus{{1: transfer()}, {1: normal()}},
+			scraping: []map[uint64]*target.ScrapeStatus{{}, {}},
+			want:     []map[uint64]string{{}, {1: target.StateNormal}},
+		},
+		{
+			name:      "unhealthy shard is not changed",
+			src:       []map[uint64]*target.ScrapeStatus{{1: normal()}, {2: normal()}},
+			scraping:  []map[uint64]*target.ScrapeStatus{{}, {3: normal()}},
+			unhealthy: 1,
+			want:      []map[uint64]string{{1: target.StateNormal}, {3: target.StateNormal}},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			shards := make([]*shardInfo, 0)
+			for i, sc := range cs.scraping {
+				s := newTestingShardInfo(fmt.Sprint(i), 0)
+				s.scraping = sc
+				shards = append(shards, s)
+			}
+			if cs.unhealthy != 0 {
+				shards[cs.unhealthy].changeAble = false
+			}
+
+			applyPlacement(shards, &placement{scale: int32(len(cs.src)), shards: cs.src})
+			for i, s := range shards {
+				got := map[uint64]string{}
+				for h, st := range s.scraping {
+					got[h] = st.TargetState
+				}
+				r.Equal(cs.want[i], got, s.shard.ID)
+			}
+		})
+	}
+}
+
+func TestCoordinator_MirrorReplica(t *testing.T) {
+	r := require.New(t)
+	sm := &fakeShardsManager{
+		shards: []*testingShard{
+			{rtInfo: &shard.RuntimeInfo{}, targetStatus: map[uint64]*target.ScrapeStatus{}},
+			{rtInfo: &shard.RuntimeInfo{}, targetStatus: map[uint64]*target.ScrapeStatus{}},
+		},
+	}
+	c := newTestingTriggerCoordinator(&Option{MaxProcessSeries: 1000, MaxShard: 10}, sm)
+	active := map[uint64]*discovery.SDTargets{
+		1: {Job: "job1", ShardTarget: &target.Target{Hash: 1}},
+		2: {Job: "job1", ShardTarget: &target.Target{Hash: 2}},
+	}
+
+	src := &placement{
+		scale: 3,
+		shards: []map[uint64]*target.ScrapeStatus{
+			{},
+			{1: {TargetState: target.StateNormal}},
+			{2: {TargetState: target.StateNormal}},
+		},
+	}
+
+	_, plan, err := c.mirrorReplica(sm, c.option, active, src)
+	r.NoError(err)
+	r.Equal(int32(3), sm.resultRep)
+	r.Equal(int32(3), plan.ExpectScale)
+	r.Equal(2, plan.AssignedTargets)
+	r.Equal(uint64(2), sm.shards[0].resultTargets.Targets["job1"][0].Hash)
+	r.Equal(uint64(1), sm.shards[1].resultTargets.Targets["job1"][0].Hash)
+}
+
+func TestCoordinator_CheckMirror(t *testing.T) {
+	var cases = []struct {
+		name     string
+		limits   []shardLimits
+		cordoned bool
+		pins     map[uint64]string
+		wantErr  bool
+	}{
+		{
+			name:   "mirror is as large as source",
+			limits: []shardLimits{{processSeries: 100}, {processSeries: 100}},
+		},
+		{
+			name:   "less shards, source shards are held by one shard",
+			limits: []shardLimits{{processSeries: 50}, {processSeries: 100}, {processSeries: 50}},
+		},
+		{
+			name:    "mirror is smaller than source",
+			limits:  []shardLimits{{processSeries: 200}, {processSeries: 100}},
+			wantErr: true,
+		},
+		{
+			name:    "source is unlimited",
+			limits:  []shardLimits{{}, {processSeries: 100}},
+			wantErr: true,
+		},
+		{
+			name:     "shard is cordoned",
+			limits:   []shardLimits{{processSeries: 100}},
+			cordoned: true,
+			wantErr:  true,
+		},
+		{
+			name:    "target is pinned to shard of mirror",
+			limits:  []shardLimits{{processSeries: 100}},
+			pins:    map[uint64]string{1: "1"},
+			wantErr: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			c := &Coordinator{}
+			c.setPins(cs.pins)
+			shards := []*shardInfo{newTestingShardInfo("0", 0), newTestingShardInfo("1", 0)}
+			for _, s := range shards {
+				s.maxHeadSeries = 0
+				s.maxProcessSeries = 100
+			}
+			shards[0].cordoned = cs.cordoned
+
+			err := c.checkMirror(shards, &placement{limits: cs.limits})
+			require.Equal(t, cs.wantErr, err != nil)
+		})
+	}
+}
+
+func TestCoordinator_MirrorReplicaFallback(t *testing.T) {
+	r := require.New(t)
+	sm := &fakeShardsManager{
+		shards: []*testingShard{
+			{rtInfo: &shard.RuntimeInfo{}, targetStatus: map[uint64]*target.ScrapeStatus{}},
+			{rtInfo: &shard.RuntimeInfo{}, targetStatus: map[uint64]*target.ScrapeStatus{}},
+		},
+	}
+	c := newTestingTriggerCoordinator(&Option{MaxProcessSeries: 1000, MaxShard: 10}, sm)
+	active := map[uint64]*discovery.SDTargets{
+		1: {Job: "job1", ShardTarget: &target.Target{Hash: 1}},
+	}
+
+	// shards of source replica are larger, placement is not applied
+	src := &placement{
+		scale:  3,
+		shards: []map[uint64]*target.ScrapeStatus{{}, {1: {TargetState: target.StateNormal}}, {}},
+		limits: []shardLimits{{processSeries: 2000}, {processSeries: 2000}, {processSeries: 2000}},
+	}
+
+	_, plan, err := c.mirrorReplica(sm, c.option, active, src)
+	r.NoError(err)
+	r.NotEqual(int32(3), plan.ExpectScale)
+	r.NotEqual(int32(3), sm.resultRep)
+}
@@ -0,0 +1,269 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/shard"
+	"tkestack.io/kvass/pkg/target"
+)
+
+// targetMove is a manual move request of one target
+type targetMove struct {
+	hash uint64
+	// to is the ID of destination shard, a free shard is chosen if it is empty
+	to string
+	// pin keep target on destination shard until it is unpinned
+	pin bool
+}
+
+// MoveTarget request transferring target "hash" to shard "to" in next coordinating
+// a free shard is chosen if "to" is empty, and target is kept on destination shard until unpinned if "pin" is true
+func (c *Coordinator) MoveTarget(hash uint64, to string, pin bool) error {
+	if c.isLeader != nil && !c.isLeader() {
+		return errors.New("coordinator is not leader")
+	}
+
+	if _, exist := c.getActive()[hash]; !exist {
+		return errors.Errorf("target %d is not active", hash)
+	}
+
+	if to != "" {
+		if err := c.checkMoveDestination(to); err != nil {
+			return err
+		}
+	}
+
+	c.moveLock.Lock()
+	defer c.moveLock.Unlock()
+	if c.moves == nil {
+		c.moves = map[uint64]*targetMove{}
+	}
+	c.moves[hash] = &targetMove{hash: hash, to: to, pin: pin}
+	return nil
+}
+
+// checkMoveDestination return an error if target can not be moved to shard "id"
+// shards are got from the last coordinating, capacity is checked when the move is applied
+func (c *Coordinator) checkMoveDestination(id string) error {
+	if c.isCordoned(id) {
+		return errors.Errorf("shard %s is cordoned", id)
+	}
+
+	plan := c.LastPlan()
+	if plan == nil {
+		return errors.New("shards are unknown before the first coordinating")
+	}
+
+	for _, rp := range plan.Replicas {
+		for _, s := range rp.Shards {
+			if s.ID != id {
+				continue
+			}
+
+			if !s.ChangeAble {
+				return errors.Errorf("shard %s is not available", id)
+			}
+			return nil
+		}
+	}
+	return errors.Errorf("shard %s is not found", id)
+}
+
+// UnpinTarget allow pinned target "hash" to be transferred by coordinator again
+func (c *Coordinator) UnpinTarget(hash uint64) error {
+	c.moveLock.Lock()
+	defer c.moveLock.Unlock()
+	if _, exist := c.pins[hash]; !exist {
+		return errors.Errorf("target %d is not pinned", hash)
+	}
+	delete(c.pins, hash)
+	return nil
+}
+
+// Pins return all pinned targets and the ID of shards they are pinned to
+func (c *Coordinator) Pins() map[uint64]string {
+	c.moveLock.Lock()
+	defer c.moveLock.Unlock()
+	ret := map[uint64]string{}
+	for h, id := range c.pins {
+		ret[h] = id
+	}
+	return ret
+}
+
+func (c *Coordinator) setPins(pins map[uint64]string) {
+	c.moveLock.Lock()
+	defer c.moveLock.Unlock()
+	c.pins = map[uint64]string{}
+	for h, id := range pins {
+		c.pins[h] = id
+	}
+}
+
+// pinnedTo return the ID of shard that target "hash" is pinned to, empty string if not pinned
+func (c *Coordinator) pinnedTo(hash uint64) string {
+	c.moveLock.Lock()
+	defer c.moveLock.Unlock()
+	return c.pins[hash]
+}
+
+// moveTargets transfer targets requested by MoveTarget, and transfer pinned targets back to their shards
+// requests are kept if "dryRun" is true
+func (c *Coordinator) moveTargets(shards []*shardInfo, dryRun bool) {
+	c.moveLock.Lock()
+	moves := make([]*targetMove, 0, len(c.moves))
+	for _, m := range c.moves {
+		moves = append(moves, m)
+	}
+	c.moveLock.Unlock()
+
+	for _, m := range moves {
+		src := scrapingShard(shards, m.hash)
+		// target may be scraped by other replica
+		if src == nil {
+			continue
+		}
+
+		var (
+			dst  *shardInfo
+			sp   = c.targetSpace(m.hash, src.scraping[m.hash])
+			skip string
+		)
+		if m.to != "" {
+			dst = shardByID(shards, m.to)
+			switch {
+			case dst == nil:
+				skip = fmt.Sprintf("shard %s is not available", m.to)
+			case dst.cordoned:
+				skip = fmt.Sprintf("shard %s is cordoned", m.to)
+			case dst != src && !c.shardCanHold(dst, sp):
+				skip = fmt.Sprintf("shard %s has not enough space", m.to)
+			}
+		} else {
+			others := make([]*shardInfo, 0, len(shards))
+			for _, s := range shards {
+				if s != src {
+					others = append(others, s)
+				}
+			}
+			dst = c.getFreeShard(others, m.hash, sp)
+			if dst == nil {
+				skip = "no shard is available"
+			}
+		}
+
+		if skip != "" {
+			dst = nil
+			c.log.Warnf("move of target %d from %s is skipped: %s", m.hash, src.shard.ID, skip)
+			c.targetEvent(EventMoveSkipped, m.hash, src, nil, skip)
+		} else if dst != src {
+			c.log.Infof("move target %d from %s to %s", m.hash, src.shard.ID, dst.shard.ID)
+			c.transferTarget(src, dst, m.hash, "moved manually")
+		}
+
+		if dryRun {
+			continue
+		}
+
+		c.moveLock.Lock()
+		delete(c.moves, m.hash)
+		if m.pin && dst != nil {
+			if c.pins == nil {
+				c.pins = map[uint64]string{}
+			}
+			c.pins[m.hash] = dst.shard.ID
+		}
+		c.moveLock.Unlock()
+	}
+
+	for hash, id := range c.Pins() {
+		dst := shardByID(shards, id)
+		if dst == nil || dst.cordoned || dst.scraping[hash] != nil {
+			continue
+		}
+
+		src := scrapingShard(shards, hash)
+		if src == nil {
+			continue
+		}
+
+		if !c.shardCanHold(dst, c.targetSpace(hash, src.scraping[hash])) {
+			c.log.Warnf("shard %s has not enough space for pinned target %d", id, hash)
+			continue
+		}
+
+		c.log.Infof("transfer pinned target %d from %s back to %s", hash, src.shard.ID, id)
+		c.transferTarget(src, dst, hash, "target is pinned to "+id)
+	}
+}
+
+// prunePins delete pins of targets that are pinned to shards not in "shards" any more, e.g. shards are scaled down
+func (c *Coordinator) prunePins(shards []*shard.Shard) {
+	exist := make(map[string]bool, len(shards))
+	for _, s := range shards {
+		exist[s.ID] = true
+	}
+
+	c.moveLock.Lock()
+	defer c.moveLock.Unlock()
+	for hash, id := range c.pins {
+		if !exist[id] {
+			c.log.Warnf("shard %s is not found, target %d is unpinned", id, hash)
+			delete(c.pins, hash)
+		}
+	}
+}
+
+// gcMoves delete move requests of targets that are not active any more
+func (c *Coordinator) gcMoves(active map[uint64]*discovery.SDTargets) {
+	c.moveLock.Lock()
+	defer c.moveLock.Unlock()
+	for h := range c.moves {
+		if _, exist := active[h]; !exist {
+			delete(c.moves, h)
+		}
+	}
+}
+
+// scrapingShard return the shard that is scraping target "hash" in normal state
+func scrapingShard(shards []*shardInfo, hash uint64) *shardInfo {
+	for _, s := range shards {
+		if st := s.scraping[hash]; st != nil && st.TargetState == target.StateNormal {
+			return s
+		}
+	}
+	return nil
+}
+
+// shardByID return the shard with ID "id", nil if not found
+func shardByID(shards []*shardInfo, id string) *shardInfo {
+	if id == "" {
+		return nil
+	}
+
+	for _, s := range shards {
+		if s.shard.ID == id {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/shard"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func newTestingMoveCoordinator() *Coordinator {
+	option := &Option{}
+	return &Coordinator{
+		option:    option,
+		scheduler: &firstFitScheduler{},
+		affinity:  newAffinity(nil),
+		budget:    newTransferBudget(option),
+		metrics:   newMetrics(),
+		log:       logrus.New(),
+		getActive: func() map[uint64]*discovery.SDTargets {
+			return map[uint64]*discovery.SDTargets{1: {Job: "job1"}}
+		},
+		lastPlan: &Plan{Replicas: []*ReplicaPlan{{
+			Shards: []*ShardPlan{
+				{ID: "0", ChangeAble: true},
+				{ID: "1", ChangeAble: true},
+				{ID: "2", ChangeAble: true},
+				{ID: "3", ChangeAble: false},
+			},
+		}}},
+	}
+}
+
+func newTestingMoveShards() []*shardInfo {
+	shards := []*shardInfo{
+		newTestingShardInfo("0", 10),
+		newTestingShardInfo("1", 0),
+		newTestingShardInfo("2", 0),
+	}
+	for _, s := range shards {
+		s.scraping = map[uint64]*target.ScrapeStatus{}
+	}
+	shards[0].scraping[1] = &target.ScrapeStatus{Series: 10, Health: scrape.HealthGood, TargetState: target.StateNormal}
+	return shards
+}
+
+func TestCoordinator_MoveTarget(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	r.NoError(c.MoveTarget(1, "", false))
+	r.Error(c.MoveTarget(2, "", false))
+
+	r.NoError(c.MoveTarget(1, "2", false))
+	r.Error(c.MoveTarget(1, "3", false))
+	r.Error(c.MoveTarget(1, "4", false))
+	r.NoError(c.CordonShard("2", false))
+	r.Error(c.MoveTarget(1, "2", false))
+
+	c.isLeader = func() bool { return false }
+	r.Error(c.MoveTarget(1, "", false))
+
+	r.Error(c.UnpinTarget(1))
+	c.setPins(map[uint64]string{1: "1"})
+	r.Equal(map[uint64]string{1: "1"}, c.Pins())
+	r.NoError(c.UnpinTarget(1))
+	r.Empty(c.Pins())
+}
+
+func TestCoordinator_MoveTargets(t *testing.T) {
+	var cases = []struct {
+		name     string
+		to       string
+		pin      bool
+		dryRun   bool
+		wantDst  int
+		wantPins map[uint64]string
+		wantKeep bool
+	}{
+		{
+			name:     "move to specific shard",
+			to:       "2",
+			wantDst:  2,
+			wantPins: map[uint64]string{},
+		},
+		{
+			name:     "move to free shard",
+			wantDst:  1,
+			wantPins: map[uint64]string{},
+		},
+		{
+			name:     "move and pin",
+			to:       "2",
+			pin:      true,
+			wantDst:  2,
+			wantPins: map[uint64]string{1: "2"},
+		},
+		{
+			name:     "dry run",
+			to:       "2",
+			pin:      true,
+			dryRun:   true,
+			wantDst:  2,
+			wantPins: map[uint64]string{},
+			wantKeep: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			c := newTestingMoveCoordinator()
+			shards := newTestingMoveShards()
+			r.NoError(c.MoveTarget(1, cs.to, cs.pin))
+
+			c.moveTargets(shards, cs.dryRun)
+			r.Equal(target.StateInTransfer, shards[0].scraping[1].TargetState)
+			r.Equal(target.StateNormal, shards[cs.wantDst].scraping[1].TargetState)
+			r.Equal(cs.wantPins, c.Pins())
+			r.Equal(cs.wantKeep, len(c.moves) != 0)
+		})
+	}
+}
+
+func TestCoordinator_MoveTargetsSkipped(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	shards := newTestingMoveShards()
+	r.NoError(c.MoveTarget(1, "2", false))
+
+	// destination has not enough space, move is dropped
+	shards[2].runtime.HeadSeries = 95
+	c.moveTargets(shards, false)
+	r.Equal(target.StateNormal, shards[0].scraping[1].TargetState)
+	r.Nil(shards[2].scraping[1])
+	r.Empty(c.moves)
+	r.Equal(1, len(c.pendingEvents))
+	r.Equal(EventMoveSkipped, c.pendingEvents[0].Type)
+	r.Equal("0", c.pendingEvents[0].From)
+}
+
+func TestCoordinator_PrunePins(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	c.setPins(map[uint64]string{1: "1", 2: "5"})
+	c.prunePins([]*shard.Shard{
+		shard.NewShard("0", "", true, logrus.New()),
+		shard.NewShard("1", "", true, logrus.New()),
+	})
+	r.Equal(map[uint64]string{1: "1"}, c.Pins())
+}
+
+func TestCoordinator_MoveTargetsPinned(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	shards := newTestingMoveShards()
+	c.setPins(map[uint64]string{1: "1"})
+
+	// pinned target is transferred back
+	c.moveTargets(shards, false)
+	r.Equal(target.StateInTransfer, shards[0].scraping[1].TargetState)
+	r.Equal(target.StateNormal, shards[1].scraping[1].TargetState)
+
+	// pinned target is never alleviated
+	shards[1].runtime.HeadSeries = 1000
+	r.Equal(int64(0), c.alleviateShardHeadSeries(shards[1], shards, 0))
+	r.Equal(target.StateNormal, shards[1].scraping[1].TargetState)
+
+	// shard with pinned target can not be idle
+	r.False(c.shardCanBeIdle(shards[1], shards))
+}
+
+func TestCoordinator_GCTargetsPinned(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	shards := newTestingMoveShards()
+	// shard 1 has lower head series, but target is pinned to shard 0
+	shards[1].scraping[1] = &target.ScrapeStatus{Series: 10, Health: scrape.HealthGood, TargetState: target.StateNormal}
+	c.setPins(map[uint64]string{1: "0"})
+
+	c.gcTargets(shards, c.getActive())
+	r.NotNil(shards[0].scraping[1])
+	r.Nil(shards[1].scraping[1])
+}
+
+func TestCoordinator_AssignPinnedTarget(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	shards := newTestingMoveShards()
+	delete(shards[0].scraping, 1)
+	c.setPins(map[uint64]string{1: "2"})
+
+	status := map[uint64]*target.ScrapeStatus{1: {Series: 10, Health: scrape.HealthGood}}
+	c.assignNoScrapingTargets(shards, c.getActive(), status, &Option{MaxHeadSeries: 100, MaxProcessSeries: 1000})
+	r.NotNil(shards[2].scraping[1])
+}
+
+func TestCoordinator_TransferTarget(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	shards := newTestingMoveShards()
+	global := map[uint64]*target.ScrapeStatus{1: shards[0].scraping[1]}
+
+	c.transferTarget(shards[0], shards[1], 1, "test")
+	r.Equal(target.StateInTransfer, shards[0].scraping[1].TargetState)
+	r.Equal(target.StateNormal, shards[1].scraping[1].TargetState)
+	// global scraping status is not changed, so that dry run does not report in_transfer targets
+	r.Equal(target.StateNormal, global[1].TargetState)
+}
@@ -0,0 +1,184 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"sort"
+	"time"
+
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/target"
+)
+
+// Plan contains all decisions made by one coordinating
+type Plan struct {
+	// DryRun indicate that this plan is not applied to shards
+	DryRun bool `json:"dryRun"`
+	// CreatedAt is the time this plan is made
+	CreatedAt time.Time `json:"createdAt"`
+	// Replicas contains the plan of every replica
+	Replicas []*ReplicaPlan `json:"replicas"`
+}
+
+// ReplicaPlan contains all decisions of one replica
+type ReplicaPlan struct {
+	// Pool is the shard pool of this replica
+	Pool string `json:"pool"`
+	// CurrentScale is the shard number before coordinating
+	CurrentScale int32 `json:"currentScale"`
+	// ExpectScale is the shard number this replica will be scaled to
+	ExpectScale int32 `json:"expectScale"`
+	// AssignedTargets is the number of targets that will be assigned to shards, include transferred targets
+	AssignedTargets int `json:"assignedTargets"`
+	// TransferredTargets is the number of targets that will be transferred to other shards
+	TransferredTargets int `json:"transferredTargets"`
+	// DeletedTargets is the number of targets that will be deleted from shards
+	DeletedTargets int `json:"deletedTargets"`
+	// Shards contains the plan of every shard
+	Shards []*ShardPlan `json:"shards"`
+}
+
+// ShardPlan contains all target changes of one shard
+type ShardPlan struct {
+	// ID is the shard ID
+	ID string `json:"id"`
+	// ChangeAble is false if shard is not ready, changes will not be applied to this shard
+	ChangeAble bool `json:"changeAble"`
+	// HeadSeries is the expected head series after plan is applied
+	HeadSeries int64 `json:"headSeries"`
+	// ProcessSeries is the expected process series after plan is applied
+	ProcessSeries int64 `json:"processSeries"`
+	// Targets is the number of targets after plan is applied, in_transfer targets are included
+	Targets int `json:"targets"`
+	// InTransfer is the number of in_transfer targets after plan is applied
+	InTransfer int `json:"inTransfer"`
+	// Assigned contains targets that will be assigned to this shard
+	Assigned []*PlanTarget `json:"assigned"`
+	// Transferred contains targets that will be marked as in_transfer
+	Transferred []*PlanTarget `json:"transferred"`
+	// Deleted contains targets that will be deleted from this shard
+	Deleted []*PlanTarget `json:"deleted"`
+}
+
+// PlanTarget is a target in plan
+type PlanTarget struct {
+	// Hash is the target hash
+	Hash uint64 `json:"hash"`
+	// Job is the job name of target, empty if target is not active any more
+	Job string `json:"job,omitempty"`
+	// Address is the address of target, empty if target is not active any more
+	Address string `json:"address,omitempty"`
+	// Series is the head series of target
+	Series int64 `json:"series"`
+}
+
+// scrapingSnapshot record the targets state of shards before coordinating
+type scrapingSnapshot map[*shardInfo]map[uint64]*target.ScrapeStatus
+
+func snapshotScraping(shards []*shardInfo) scrapingSnapshot {
+	ret := scrapingSnapshot{}
+	for _, s := range shards {
+		m := map[uint64]*target.ScrapeStatus{}
+		for h, st := range s.scraping {
+			cp := *st
+			m[h] = &cp
+		}
+		ret[s] = m
+	}
+	return ret
+}
+
+// newReplicaPlan compare shards with the snapshot before coordinating and return all changes
+func newReplicaPlan(
+	pool string,
+	before scrapingSnapshot,
+	shards []*shardInfo,
+	active map[uint64]*discovery.SDTargets,
+	expectScale int32,
+) *ReplicaPlan {
+	ret := &ReplicaPlan{
+		Pool:         pool,
+		CurrentScale: int32(len(shards)),
+		ExpectScale:  expectScale,
+		Shards:       make([]*ShardPlan, 0, len(shards)),
+	}
+
+	for _, s := range shards {
+		sp := &ShardPlan{
+			ID:            s.shard.ID,
+			ChangeAble:    s.changeAble,
+			HeadSeries:    s.runtime.HeadSeries,
+			ProcessSeries: s.runtime.ProcessSeries,
+			Assigned:      []*PlanTarget{},
+			Transferred:   []*PlanTarget{},
+			Deleted:       []*PlanTarget{},
+		}
+		ret.Shards = append(ret.Shards, sp)
+		if !s.changeAble {
+			continue
+		}
+
+		sp.Targets = len(s.scraping)
+		old := before[s]
+		for h, st := range s.scraping {
+			if st.TargetState == target.StateInTransfer {
+				sp.InTransfer++
+			}
+
+			o := old[h]
+			if o == nil {
+				sp.Assigned = append(sp.Assigned, newPlanTarget(h, st, active))
+			} else if o.TargetState == target.StateNormal && st.TargetState == target.StateInTransfer {
+				sp.Transferred = append(sp.Transferred, newPlanTarget(h, st, active))
+			}
+		}
+
+		for h, st := range old {
+			if s.scraping[h] == nil {
+				sp.Deleted = append(sp.Deleted, newPlanTarget(h, st, active))
+			}
+		}
+
+		sortPlanTargets(sp.Assigned)
+		sortPlanTargets(sp.Transferred)
+		sortPlanTargets(sp.Deleted)
+		ret.AssignedTargets += len(sp.Assigned)
+		ret.TransferredTargets += len(sp.Transferred)
+		ret.DeletedTargets += len(sp.Deleted)
+	}
+	return ret
+}
+
+func newPlanTarget(hash uint64, st *target.ScrapeStatus, active map[uint64]*discovery.SDTargets) *PlanTarget {
+	ret := &PlanTarget{
+		Hash:   hash,
+		Series: st.Series,
+	}
+
+	if tar := active[hash]; tar != nil {
+		ret.Job = tar.Job
+		ret.Address = tar.ShardTarget.Address()
+	}
+	return ret
+}
+
+func sortPlanTargets(ts []*PlanTarget) {
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].Hash < ts[j].Hash
+	})
+}
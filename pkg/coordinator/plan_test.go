@@ -0,0 +1,69 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func TestNewReplicaPlan(t *testing.T) {
+	r := require.New(t)
+	active := map[uint64]*discovery.SDTargets{
+		1: {Job: "job1", ShardTarget: &target.Target{Hash: 1}},
+		2: {Job: "job1", ShardTarget: &target.Target{Hash: 2}},
+	}
+
+	s0 := newTestingShardInfo("0", 90)
+	s1 := newTestingShardInfo("1", 0)
+	s0.scraping = map[uint64]*target.ScrapeStatus{
+		1: {Series: 10},
+		3: {Series: 10},
+	}
+	s1.scraping = map[uint64]*target.ScrapeStatus{}
+	shards := []*shardInfo{s0, s1}
+
+	before := snapshotScraping(shards)
+	c := &Coordinator{affinity: newAffinity(nil), budget: newTransferBudget(&Option{}), metrics: newMetrics()}
+	c.transferTarget(s0, s1, 1, "")
+	delete(s0.scraping, 3)
+	s1.scraping[2] = &target.ScrapeStatus{Series: 5}
+
+	p := newReplicaPlan("", before, shards, active, 3)
+	r.Equal(int32(2), p.CurrentScale)
+	r.Equal(int32(3), p.ExpectScale)
+	r.Equal(2, p.AssignedTargets)
+	r.Equal(1, p.TransferredTargets)
+	r.Equal(1, p.DeletedTargets)
+
+	r.Equal(uint64(1), p.Shards[0].Transferred[0].Hash)
+	r.Equal(uint64(3), p.Shards[0].Deleted[0].Hash)
+	r.Equal("", p.Shards[0].Deleted[0].Job)
+	r.Equal([]*PlanTarget{
+		{Hash: 1, Job: "job1", Series: 10},
+		{Hash: 2, Job: "job1", Series: 5},
+	}, p.Shards[1].Assigned)
+	r.Equal(int64(10), p.Shards[1].HeadSeries)
+	r.Equal(1, p.Shards[0].Targets)
+	r.Equal(1, p.Shards[0].InTransfer)
+	r.Equal(2, p.Shards[1].Targets)
+	r.Equal(0, p.Shards[1].InTransfer)
+}
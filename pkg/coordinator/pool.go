@@ -0,0 +1,132 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"tkestack.io/kvass/pkg/discovery"
+)
+
+// PoolOption indicate a dedicated shard pool
+// targets of Jobs are only placed to replicas of this pool, and each pool is scaled independently
+// limits with zero value inherit from the global Option
+type PoolOption struct {
+	// Name is the pool name of replicas, see shard.Manager.Pool
+	Name string `yaml:"name" json:"name"`
+	// Jobs is the jobs whose targets are placed to this pool
+	Jobs []string `yaml:"jobs" json:"jobs"`
+	// MaxHeadSeries is max series after metrics_relabels every shard of this pool can assign
+	MaxHeadSeries int64 `yaml:"maxHeadSeries,omitempty" json:"maxHeadSeries,omitempty"`
+	// MaxProcessSeries is max series before metrics_relabels every shard of this pool can assign
+	MaxProcessSeries int64 `yaml:"maxProcessSeries,omitempty" json:"maxProcessSeries,omitempty"`
+	// MaxShard is the max number this pool can scale up to
+	MaxShard int32 `yaml:"maxShard,omitempty" json:"maxShard,omitempty"`
+	// MinShard is the min shard number of this pool
+	MinShard int32 `yaml:"minShard,omitempty" json:"minShard,omitempty"`
+}
+
+// LoadPools load shard pools from a yaml file
+func LoadPools(file string) ([]PoolOption, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read pools file")
+	}
+
+	pools := make([]PoolOption, 0)
+	if err := yaml.Unmarshal(data, &pools); err != nil {
+		return nil, errors.Wrapf(err, "wrong format of pools file")
+	}
+
+	names := map[string]bool{}
+	jobs := map[string]string{}
+	for _, p := range pools {
+		if p.Name == "" {
+			return nil, errors.Errorf("name of pool is empty")
+		}
+
+		if names[p.Name] {
+			return nil, errors.Errorf("pool %s is duplicated", p.Name)
+		}
+		names[p.Name] = true
+
+		for _, j := range p.Jobs {
+			if jobs[j] != "" {
+				return nil, errors.Errorf("job %s is in both pool %s and %s", j, jobs[j], p.Name)
+			}
+			jobs[j] = p.Name
+		}
+	}
+	return pools, nil
+}
+
+// poolOption return a copy of the coordinate option of shard pool "name"
+// global option is used for default pool, false is returned if pool is not found
+func (c *Coordinator) poolOption(name string) (*Option, bool) {
+	if name == "" {
+		opt := *c.option
+		return &opt, true
+	}
+
+	for _, p := range c.option.Pools {
+		if p.Name != name {
+			continue
+		}
+
+		opt := *c.option
+		if p.MaxHeadSeries != 0 {
+			opt.MaxHeadSeries = p.MaxHeadSeries
+		}
+		if p.MaxProcessSeries != 0 {
+			opt.MaxProcessSeries = p.MaxProcessSeries
+		}
+		if p.MaxShard != 0 {
+			opt.MaxShard = p.MaxShard
+		}
+		if p.MinShard != 0 {
+			opt.MinShard = p.MinShard
+		}
+		return &opt, true
+	}
+	return nil, false
+}
+
+// poolTargets return the active targets that should be placed to shard pool "name"
+// targets whose job is not in any pool belong to the default pool
+func (c *Coordinator) poolTargets(name string, active map[uint64]*discovery.SDTargets) map[uint64]*discovery.SDTargets {
+	if len(c.option.Pools) == 0 {
+		return active
+	}
+
+	poolOfJob := map[string]string{}
+	for _, p := range c.option.Pools {
+		for _, j := range p.Jobs {
+			poolOfJob[j] = p.Name
+		}
+	}
+
+	ret := map[uint64]*discovery.SDTargets{}
+	for hash, tar := range active {
+		if poolOfJob[tar.Job] == name {
+			ret[hash] = tar
+		}
+	}
+	return ret
+}
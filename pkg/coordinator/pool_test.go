@@ -0,0 +1,163 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/discovery"
+)
+
+func TestLoadPools(t *testing.T) {
+	var cases = []struct {
+		name      string
+		content   string
+		wantPools int
+		wantErr   bool
+	}{
+		{
+			name: "success",
+			content: `
+- name: ksm
+  jobs: [kube-state-metrics]
+  maxHeadSeries: 100
+- name: big
+  jobs: [job1, job2]
+  maxShard: 3
+`,
+			wantPools: 2,
+		},
+		{
+			name:    "empty name",
+			content: `- jobs: [job1]`,
+			wantErr: true,
+		},
+		{
+			name: "duplicated name",
+			content: `
+- name: p1
+- name: p1
+`,
+			wantErr: true,
+		},
+		{
+			name: "job in multi pools",
+			content: `
+- name: p1
+  jobs: [job1]
+- name: p2
+  jobs: [job1]
+`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong format",
+			content: `a: b`,
+			wantErr: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			file := t.TempDir() + "/pools.yaml"
+			r.NoError(ioutil.WriteFile(file, []byte(cs.content), 0644))
+
+			pools, err := LoadPools(file)
+			if cs.wantErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			r.Equal(cs.wantPools, len(pools))
+		})
+	}
+}
+
+func TestCoordinator_PoolOption(t *testing.T) {
+	r := require.New(t)
+	c := &Coordinator{option: &Option{
+		MaxHeadSeries:    100,
+		MaxProcessSeries: 1000,
+		MaxShard:         10,
+		MinShard:         1,
+		Pools: []PoolOption{
+			{Name: "p1", MaxHeadSeries: 50, MaxShard: 2},
+		},
+	}}
+
+	opt, exist := c.poolOption("")
+	r.True(exist)
+	r.Equal(c.option, opt)
+
+	opt, exist = c.poolOption("p1")
+	r.True(exist)
+	r.Equal(int64(50), opt.MaxHeadSeries)
+	r.Equal(int64(1000), opt.MaxProcessSeries)
+	r.Equal(int32(2), opt.MaxShard)
+	r.Equal(int32(1), opt.MinShard)
+	r.Equal(int64(100), c.option.MaxHeadSeries)
+
+	_, exist = c.poolOption("p2")
+	r.False(exist)
+}
+
+func TestCoordinator_PoolTargets(t *testing.T) {
+	active := map[uint64]*discovery.SDTargets{
+		1: {Job: "job1"},
+		2: {Job: "job2"},
+		3: {Job: "job3"},
+	}
+
+	var cases = []struct {
+		name      string
+		pools     []PoolOption
+		pool      string
+		wantHashs []uint64
+	}{
+		{
+			name:      "no pools",
+			wantHashs: []uint64{1, 2, 3},
+		},
+		{
+			name:      "default pool",
+			pools:     []PoolOption{{Name: "p1", Jobs: []string{"job1"}}},
+			wantHashs: []uint64{2, 3},
+		},
+		{
+			name:      "dedicated pool",
+			pools:     []PoolOption{{Name: "p1", Jobs: []string{"job1", "job2"}}},
+			pool:      "p1",
+			wantHashs: []uint64{1, 2},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			c := &Coordinator{option: &Option{Pools: cs.pools}}
+			want := map[uint64]*discovery.SDTargets{}
+			for _, h := range cs.wantHashs {
+				want[h] = active[h]
+			}
+			r.Equal(want, c.poolTargets(cs.pool, active))
+		})
+	}
+}
@@ -0,0 +1,168 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/prom"
+	"tkestack.io/kvass/pkg/target"
+	"tkestack.io/kvass/pkg/utils/types"
+)
+
+// LoadQuotaRules load quota rules from a yaml file
+func LoadQuotaRules(file string) ([]prom.QuotaRule, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read quota file")
+	}
+
+	rules := make([]prom.QuotaRule, 0)
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, errors.Wrapf(err, "wrong format of quota file")
+	}
+
+	for i, r := range rules {
+		if r.MaxSeries == 0 && r.MaxTargets == 0 {
+			return nil, errors.Errorf("maxSeries and maxTargets of quota rule %d are both empty", i)
+		}
+	}
+	return rules, nil
+}
+
+type quotaKey struct {
+	rule  int
+	group string
+}
+
+type quotaUsage struct {
+	series   int64
+	targets  int
+	rejected int
+}
+
+// quota record the usage of every quota group during one coordination
+type quota struct {
+	rules []prom.QuotaRule
+	usage map[quotaKey]*quotaUsage
+}
+
+// newQuota create a quota with usage of targets that shards are scraping
+func newQuota(rules []prom.QuotaRule, shards []*shardInfo, active map[uint64]*discovery.SDTargets) *quota {
+	q := &quota{
+		rules: rules,
+		usage: map[quotaKey]*quotaUsage{},
+	}
+	if len(rules) == 0 {
+		return q
+	}
+
+	counted := map[uint64]bool{}
+	for _, s := range shards {
+		for hash, st := range s.scraping {
+			tar := active[hash]
+			if tar == nil || counted[hash] || st.TargetState != target.StateNormal {
+				continue
+			}
+			counted[hash] = true
+			q.add(tar, st.Series)
+		}
+	}
+	return q
+}
+
+// keys return the quota groups target belongs to
+func (q *quota) keys(tar *discovery.SDTargets) []quotaKey {
+	ret := make([]quotaKey, 0)
+	for i, r := range q.rules {
+		if len(r.Jobs) != 0 && !types.FindString(tar.Job, r.Jobs...) {
+			continue
+		}
+
+		group := "job=" + tar.Job
+		if r.Label != "" {
+			v := tar.ShardTarget.Labels.Get(r.Label)
+			if v == "" {
+				continue
+			}
+			group = r.Label + "=" + v
+		}
+		ret = append(ret, quotaKey{rule: i, group: group})
+	}
+	return ret
+}
+
+func (q *quota) get(k quotaKey) *quotaUsage {
+	u := q.usage[k]
+	if u == nil {
+		u = &quotaUsage{}
+		q.usage[k] = u
+	}
+	return u
+}
+
+// add record that target with "series" head series is assigned
+func (q *quota) add(tar *discovery.SDTargets, series int64) {
+	for _, k := range q.keys(tar) {
+		u := q.get(k)
+		u.series += series
+		u.targets++
+	}
+}
+
+// check return the reason if target with "series" head series can not be assigned, empty string is returned if it can
+func (q *quota) check(tar *discovery.SDTargets, series int64) string {
+	for _, k := range q.keys(tar) {
+		r := q.rules[k.rule]
+		u := q.get(k)
+		if r.MaxSeries != 0 && u.series+series > r.MaxSeries {
+			u.rejected++
+			return fmt.Sprintf("over quota of %s: head series %d + %d > %d", k.group, u.series, series, r.MaxSeries)
+		}
+
+		if r.MaxTargets != 0 && u.targets+1 > r.MaxTargets {
+			u.rejected++
+			return fmt.Sprintf("over quota of %s: targets %d + 1 > %d", k.group, u.targets, r.MaxTargets)
+		}
+	}
+	return ""
+}
+
+// updateMetrics set usage metrics of all quota groups
+func (q *quota) updateMetrics(m *metrics) {
+	for k, u := range q.usage {
+		r := q.rules[k.rule]
+		m.setGauge(m.quotaSeries, float64(u.series), k.group)
+		m.setGauge(m.quotaMaxSeries, float64(r.MaxSeries), k.group)
+		m.setGauge(m.quotaTargets, float64(u.targets), k.group)
+		m.setGauge(m.quotaMaxTargets, float64(r.MaxTargets), k.group)
+		m.setGauge(m.quotaRejectedTargets, float64(u.rejected), k.group)
+	}
+}
+
+// quotaRules return quota rules in ExtraConfig if set, otherwise the rules of Option is used
+func (c *Coordinator) quotaRules() []prom.QuotaRule {
+	if cfg := c.getConfig(); cfg != nil && cfg.ExtraConfig != nil && cfg.ExtraConfig.Quotas != nil {
+		return cfg.ExtraConfig.Quotas
+	}
+	return c.option.Quotas
+}
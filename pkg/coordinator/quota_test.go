@@ -0,0 +1,191 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/prom"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func TestLoadQuotaRules(t *testing.T) {
+	var cases = []struct {
+		name      string
+		content   string
+		wantRules int
+		wantErr   bool
+	}{
+		{
+			name: "success",
+			content: `
+- jobs: [job1]
+  maxSeries: 100
+- label: namespace
+  maxTargets: 10
+`,
+			wantRules: 2,
+		},
+		{
+			name:    "no limit",
+			content: `- jobs: [job1]`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong format",
+			content: `a: b`,
+			wantErr: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			file := t.TempDir() + "/quota.yaml"
+			r.NoError(ioutil.WriteFile(file, []byte(cs.content), 0644))
+
+			rules, err := LoadQuotaRules(file)
+			if cs.wantErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			r.Equal(cs.wantRules, len(rules))
+		})
+	}
+}
+
+func newTestingQuotaTarget(hash uint64, job, namespace string) *discovery.SDTargets {
+	return &discovery.SDTargets{
+		Job: job,
+		ShardTarget: &target.Target{
+			Hash:   hash,
+			Labels: labels.Labels{{Name: "namespace", Value: namespace}},
+		},
+	}
+}
+
+func TestQuota(t *testing.T) {
+	active := map[uint64]*discovery.SDTargets{
+		1: newTestingQuotaTarget(1, "job1", "ns1"),
+		2: newTestingQuotaTarget(2, "job1", "ns2"),
+		3: newTestingQuotaTarget(3, "job2", "ns1"),
+	}
+
+	shards := newTestingMoveShards()
+	shards[0].scraping = map[uint64]*target.ScrapeStatus{
+		1: {Series: 10, TargetState: target.StateNormal},
+	}
+	// in_transfer target is counted only once
+	shards[1].scraping = map[uint64]*target.ScrapeStatus{
+		1: {Series: 10, TargetState: target.StateInTransfer},
+	}
+
+	var cases = []struct {
+		name       string
+		rules      []prom.QuotaRule
+		hash       uint64
+		series     int64
+		wantReject bool
+	}{
+		{
+			name:   "no rules",
+			hash:   2,
+			series: 100,
+		},
+		{
+			name:       "job series exceeded",
+			rules:      []prom.QuotaRule{{Jobs: []string{"job1"}, MaxSeries: 15}},
+			hash:       2,
+			series:     10,
+			wantReject: true,
+		},
+		{
+			name:   "other job is not limited",
+			rules:  []prom.QuotaRule{{Jobs: []string{"job1"}, MaxSeries: 15}},
+			hash:   3,
+			series: 10,
+		},
+		{
+			name:       "job targets exceeded",
+			rules:      []prom.QuotaRule{{MaxTargets: 1}},
+			hash:       2,
+			series:     1,
+			wantReject: true,
+		},
+		{
+			name:       "label value exceeded",
+			rules:      []prom.QuotaRule{{Label: "namespace", MaxTargets: 1}},
+			hash:       3,
+			series:     1,
+			wantReject: true,
+		},
+		{
+			name:   "other label value is not limited",
+			rules:  []prom.QuotaRule{{Label: "namespace", MaxTargets: 1}},
+			hash:   2,
+			series: 1,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			q := newQuota(cs.rules, shards, active)
+			reason := q.check(active[cs.hash], cs.series)
+			r.Equal(cs.wantReject, reason != "", reason)
+		})
+	}
+}
+
+func TestCoordinator_AssignWithQuota(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	c.option.MaxHeadSeries = 100
+	c.option.MaxProcessSeries = 1000
+	c.getConfig = func() *prom.ConfigInfo { return prom.DefaultConfig }
+	c.option.Quotas = []prom.QuotaRule{{MaxSeries: 15}}
+
+	active := map[uint64]*discovery.SDTargets{
+		1: newTestingQuotaTarget(1, "job1", "ns1"),
+		2: newTestingQuotaTarget(2, "job1", "ns1"),
+		3: newTestingQuotaTarget(3, "job1", "ns1"),
+	}
+	status := map[uint64]*target.ScrapeStatus{
+		2: {Series: 5, Health: scrape.HealthGood},
+		3: {Series: 5, Health: scrape.HealthGood},
+	}
+
+	shards := newTestingMoveShards()
+	c.quota = newQuota(c.quotaRules(), shards, active)
+	needSpace := c.assignNoScrapingTargets(shards, active, status, c.option)
+	r.True(needSpace.isZero())
+
+	assigned := 0
+	for _, s := range shards {
+		assigned += len(s.scraping)
+	}
+	// target 1 (10 series) is scraping, only one of target 2 and target 3 can be assigned
+	r.Equal(2, assigned)
+	r.Equal(1, len(c.unassigned))
+}
@@ -18,12 +18,13 @@
 package coordinator
 
 import (
+	"fmt"
 	"time"
 
-	wr "github.com/mroth/weightedrand"
 	"github.com/prometheus/prometheus/scrape"
 	"golang.org/x/sync/errgroup"
 	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/prom"
 	"tkestack.io/kvass/pkg/shard"
 	"tkestack.io/kvass/pkg/target"
 )
@@ -38,14 +39,50 @@ type shardInfo struct {
 	runtime    *shard.RuntimeInfo
 	scraping   map[uint64]*target.ScrapeStatus
 	newTargets map[string][]*target.Target
+	// maxHeadSeries is max series after metrics_relabels this shard can assign, skipped if 0
+	maxHeadSeries int64
+	// maxProcessSeries is max series before metrics_relabels this shard can assign
+	maxProcessSeries int64
+	// maxMemoryBytes is max resident memory of this shard, skipped if 0
+	maxMemoryBytes int64
+	// bytesPerSeries is the memory cost of every head series, 0 if memory of shard is unknown
+	bytesPerSeries float64
+	// fetchedHeadSeries is the head series got from sidecar before coordinating
+	fetchedHeadSeries int64
+	// maxSamplesRate is max samples per second this shard can ingest, skipped if 0
+	maxSamplesRate float64
+	// maxTargets is max targets number this shard can scrape, skipped if 0
+	maxTargets int64
+	// maxScrapeDuration is max cumulative scrape seconds of targets of this shard, skipped if 0
+	maxScrapeDuration float64
+	// samplesRate, targets and scrapeDuration is the load of this shard, see initLoad
+	samplesRate    float64
+	targets        int64
+	scrapeDuration float64
+	// cordoned shard receives no new target
+	cordoned bool
+	// draining shard transfer all targets to other shards
+	draining bool
+	// threshold is the alleviation tier this shard in, nil if shard is not overload
+	threshold *prom.AlleviateThreshold
+	// alleviating shard is in alleviation tier or cooldown, it receives no new target
+	alleviating bool
 }
 
-func newShardInfo(sd *shard.Shard) *shardInfo {
-	return &shardInfo{
-		shard:      sd,
-		runtime:    &shard.RuntimeInfo{},
-		newTargets: map[string][]*target.Target{},
-	}
+func newShardInfo(sd *shard.Shard, opt *Option) *shardInfo {
+	s := &shardInfo{
+		shard:             sd,
+		runtime:           &shard.RuntimeInfo{},
+		newTargets:        map[string][]*target.Target{},
+		maxHeadSeries:     opt.MaxHeadSeries,
+		maxProcessSeries:  opt.MaxProcessSeries,
+		maxMemoryBytes:    opt.MaxMemoryBytes,
+		maxSamplesRate:    opt.MaxSamplesRate,
+		maxTargets:        opt.MaxTargets,
+		maxScrapeDuration: opt.MaxScrapeDuration.Seconds(),
+	}
+	s.applyCapacity(sd.Capacity)
+	return s
 }
 
 func (s *shardInfo) totalTargetsHeadSeries() int64 {
@@ -99,14 +136,15 @@ func updateScrapingTargets(shards []*shardInfo, active map[uint64]*discovery.SDT
 	}
 }
 
-func (c *Coordinator) getShardInfos(shards []*shard.Shard) []*shardInfo {
+func (c *Coordinator) getShardInfos(shards []*shard.Shard, opt *Option) []*shardInfo {
+	c.roundShards = append(c.roundShards, shards...)
 	all := make([]*shardInfo, len(shards))
 	g := errgroup.Group{}
 	for index, tmp := range shards {
 		s := tmp
 		i := index
 		g.Go(func() (err error) {
-			all[i] = c.getOneShardInfo(s)
+			all[i] = c.getOneShardInfo(s, opt)
 			return nil
 		})
 	}
@@ -114,10 +152,10 @@ func (c *Coordinator) getShardInfos(shards []*shard.Shard) []*shardInfo {
 	return all
 }
 
-func (c *Coordinator) getOneShardInfo(s *shard.Shard) *shardInfo {
+func (c *Coordinator) getOneShardInfo(s *shard.Shard, opt *Option) *shardInfo {
 	var (
 		err error
-		si  = newShardInfo(s)
+		si  = newShardInfo(s, opt)
 	)
 
 	if !s.Ready {
@@ -136,10 +174,11 @@ func (c *Coordinator) getOneShardInfo(s *shard.Shard) *shardInfo {
 		c.log.Error(err.Error())
 		return si
 	}
+	si.initMemory()
 
 	cfg := c.getConfig()
 	// try update config to send raw config to
-	if si.runtime.ConfigHash != cfg.ConfigHash {
+	if si.runtime.ConfigHash != cfg.ConfigHash && !opt.DryRun {
 		c.log.Infof("shard %s config need update", si.shard.ID)
 		if err := s.UpdateConfig(&shard.UpdateConfigRequest{
 			RawContent: string(cfg.RawContent),
@@ -200,7 +239,9 @@ func (c *Coordinator) updateScrapeStatusShards(shards []*shardInfo, status map[u
 			continue
 		}
 		for k := range s.scraping {
-			status[k].Shards = append(status[k].Shards, s.shard.ID)
+			if st := status[k]; st != nil {
+				st.Shards = append(st.Shards, s.shard.ID)
+			}
 		}
 	}
 	return status
@@ -209,13 +250,14 @@ func (c *Coordinator) updateScrapeStatusShards(shards []*shardInfo, status map[u
 // gcTargets delete targets with following conditions
 // 1. not exist in active targets
 // 2. is in_transfer state and had been scraped by other shard
-// 3. is normal state and had been scraped by other shard with lower head series
+// 3. is normal state and had been scraped by other shard with lower head series, or pinned to other shard
 func (c *Coordinator) gcTargets(changeAbleShards []*shardInfo, active map[uint64]*discovery.SDTargets) {
 	for _, s := range changeAbleShards {
 		for h, tar := range s.scraping {
 			// target not exist in active targets
 			if _, exist := active[h]; !exist {
 				delete(s.scraping, h)
+				c.targetEvent(EventGC, h, s, nil, "target is not active")
 				continue
 			}
 
@@ -232,13 +274,26 @@ func (c *Coordinator) gcTargets(changeAbleShards []*shardInfo, active map[uint64
 					// is in_transfer state and had been scraped by other shard
 					if tar.TargetState == target.StateInTransfer && st.TargetState == target.StateNormal {
 						delete(s.scraping, h)
+						c.targetEvent(EventTransferComplete, h, s, other, "target is scraped by destination shard")
 						break
 					}
 
 					if tar.TargetState == st.TargetState {
-						if (c.option.MaxHeadSeries != 0 && other.runtime.HeadSeries < s.runtime.HeadSeries) ||
-							(c.option.MaxHeadSeries == 0 && other.runtime.ProcessSeries < s.runtime.ProcessSeries) {
+						pinned := c.pinnedTo(h)
+						if pinned == s.shard.ID {
+							continue
+						}
+
+						if pinned == other.shard.ID {
 							delete(s.scraping, h)
+							c.targetEvent(EventGC, h, s, nil, fmt.Sprintf("target is pinned to %s", other.shard.ID))
+							break
+						}
+
+						if (s.maxHeadSeries != 0 && other.runtime.HeadSeries < s.runtime.HeadSeries) ||
+							(s.maxHeadSeries == 0 && other.runtime.ProcessSeries < s.runtime.ProcessSeries) {
+							delete(s.scraping, h)
+							c.targetEvent(EventGC, h, s, nil, fmt.Sprintf("target is also scraped by less loaded shard %s", other.shard.ID))
 							break
 						}
 					}
@@ -249,7 +304,8 @@ func (c *Coordinator) gcTargets(changeAbleShards []*shardInfo, active map[uint64
 }
 
 // alleviateShards try remove some targets from shards to alleviate shard burden
-func (c *Coordinator) alleviateShards(changeAbleShards []*shardInfo) space {
+// the alleviation tier of shards should be marked by markAlleviating
+func (c *Coordinator) alleviateShards(changeAbleShards []*shardInfo, cfg *prom.AlleviateConfig, dryRun bool) space {
 	needSpace := space{}
 	if c.option.DisableAlleviate {
 		return needSpace
@@ -258,42 +314,36 @@ func (c *Coordinator) alleviateShards(changeAbleShards []*shardInfo) space {
 	// alleviate shard if total processing series over 1.0 rate of max
 	for _, s := range changeAbleShards {
 		c.log.Infof("process series of %s is %d", s.shard.ID, s.runtime.ProcessSeries)
-		if s.runtime.ProcessSeries >= seriesWithRate(c.option.MaxProcessSeries, 1.0) {
-			needSpace.processSpace += c.alleviateShardProcessSeries(s, changeAbleShards, seriesWithRate(c.option.MaxProcessSeries, 1))
-		}
-	}
-
-	var threshold = []struct {
-		maxSeriesRate    float64
-		expectSeriesRate float64
-	}{
-		{
-			maxSeriesRate:    1.8,
-			expectSeriesRate: 0,
-		},
-		{
-			maxSeriesRate:    1.6,
-			expectSeriesRate: 0.2,
-		},
-		{
-			maxSeriesRate:    1.4,
-			expectSeriesRate: 0.5,
-		},
-		{
-			maxSeriesRate:    1.1,
-			expectSeriesRate: 1,
-		},
+		if s.runtime.ProcessSeries >= seriesWithRate(s.maxProcessSeries, 1.0) {
+			needSpace.processSpace += c.alleviateShardProcessSeries(s, changeAbleShards, seriesWithRate(s.maxProcessSeries, 1))
+		}
 	}
 
+	// alleviate shard if resident memory over max memory
+	needSpace.headSpace += c.alleviateShardsMemory(changeAbleShards)
+
 	// alleviate shard if total head series over threshold list
-	if c.option.MaxHeadSeries != 0 {
-		for _, s := range changeAbleShards {
-			for _, t := range threshold {
-				if s.runtime.HeadSeries >= seriesWithRate(c.option.MaxHeadSeries, t.maxSeriesRate) {
-					needSpace.headSpace += c.alleviateShardHeadSeries(s, changeAbleShards, seriesWithRate(c.option.MaxHeadSeries, t.expectSeriesRate))
-					break
-				}
-			}
+	for _, s := range changeAbleShards {
+		if s.maxHeadSeries == 0 || s.threshold == nil {
+			continue
+		}
+
+		st := c.alleviateStates[s.shard.ID]
+		if cfg.Cooldown != 0 && time.Since(st.lastAlleviateAt) < time.Duration(cfg.Cooldown) {
+			c.log.Infof("%s is in alleviation cooldown, skip", s.shard.ID)
+			continue
+		}
+
+		expSeries := seriesWithRate(s.maxHeadSeries, s.threshold.ExpectSeriesRate)
+		total := s.totalTargetsHeadSeries()
+		if total <= expSeries {
+			continue
+		}
+
+		needSpace.headSpace += c.alleviateShardHeadSeries(s, changeAbleShards, expSeries)
+		// cooldown starts only if some targets are really transferred
+		if !dryRun && s.totalTargetsHeadSeries() < total {
+			st.lastAlleviateAt = time.Now()
 		}
 	}
 
@@ -307,8 +357,9 @@ func (c *Coordinator) alleviateShardHeadSeries(s *shardInfo, changeAbleShards []
 	}
 
 	c.log.Infof("%s need alleviate head series, cur = %d, exp = %d", s.shard.ID, total, expSeries)
-	alleviateShardsTotal.WithLabelValues().Inc()
+	c.metrics.alleviateShardsTotal.WithLabelValues().Inc()
 
+	deferred := int64(0)
 	for hash, tar := range s.scraping {
 		if total <= expSeries {
 			break
@@ -318,28 +369,42 @@ func (c *Coordinator) alleviateShardHeadSeries(s *shardInfo, changeAbleShards []
 			continue
 		}
 
-		if tar.Series > c.option.MaxHeadSeries {
+		// pinned target is never transferred, and more space can not help
+		if c.pinnedTo(hash) != "" {
+			deferred += tar.Series
+			continue
+		}
+
+		if s.maxHeadSeries != 0 && tar.Series > s.maxHeadSeries {
 			c.log.Warnf("too big series [%d] series is [%d], skip alleviate", hash, tar.Series)
 			return 0
 		}
 
 		// try transfer target to other shard
+		candidates := make([]*shardInfo, 0)
 		for _, os := range changeAbleShards {
-			if os == s {
-				continue
+			if os != s && !os.cordoned && !os.alleviating && (os.maxHeadSeries == 0 || os.runtime.HeadSeries+tar.Series < os.maxHeadSeries) &&
+				os.memoryCanHold(tar.Series) && os.loadCanHold(c.targetSpace(hash, tar)) {
+				candidates = append(candidates, os)
 			}
+		}
 
-			if os.runtime.HeadSeries+tar.Series < c.option.MaxHeadSeries {
-				c.log.Infof("need transfer target %d, from %s to %s series = (%d) ", hash, s.shard.ID, os.shard.ID, tar.Series)
-				transferTarget(s, os, hash)
-				total -= tar.Series
-				break
-			}
+		candidates, limited := c.budget.filter(s, candidates, tar.Series)
+		if limited {
+			deferred += tar.Series
+			continue
+		}
+
+		if os := c.schedule(candidates, hash, c.targetSpace(hash, tar)); os != nil {
+			c.log.Infof("need transfer target %d, from %s to %s series = (%d) ", hash, s.shard.ID, os.shard.ID, tar.Series)
+			c.transferTarget(s, os, hash, "alleviate head series")
+			total -= tar.Series
 		}
 	}
 
-	if total > expSeries {
-		return total - expSeries
+	// deferred transfers will be done in later coordinating and pinned targets stay, no more space is needed
+	if total-deferred > expSeries {
+		return total - deferred - expSeries
 	}
 	return 0
 }
@@ -352,8 +417,9 @@ func (c *Coordinator) alleviateShardProcessSeries(s *shardInfo, changeAbleShards
 	}
 
 	c.log.Infof("%s need alleviate process series, cur = %d, exp = %d", s.shard.ID, total, expSeries)
-	alleviateShardsTotal.WithLabelValues().Inc()
+	c.metrics.alleviateShardsTotal.WithLabelValues().Inc()
 
+	deferred := int64(0)
 	for hash, tar := range s.scraping {
 		if total <= expSeries {
 			break
@@ -363,40 +429,60 @@ func (c *Coordinator) alleviateShardProcessSeries(s *shardInfo, changeAbleShards
 			continue
 		}
 
-		if tar.TotalSeries > c.option.MaxProcessSeries {
+		// pinned target is never transferred, and more space can not help
+		if c.pinnedTo(hash) != "" {
+			deferred += tar.TotalSeries
+			continue
+		}
+
+		if tar.TotalSeries > s.maxProcessSeries {
 			c.log.Warnf("too big series [%d] series is [%d], skip alleviate", hash, tar.Series)
 			return 0
 		}
 
 		// try transfer target to other shard
+		tarSp := c.targetSpace(hash, tar)
+		candidates := make([]*shardInfo, 0)
 		for _, os := range changeAbleShards {
-			if os == s {
-				continue
+			if os != s && !os.cordoned && !os.alleviating && c.shardCanHold(os, tarSp) {
+				candidates = append(candidates, os)
 			}
+		}
 
-			if (c.option.MaxHeadSeries == 0 || os.runtime.HeadSeries+tar.Series < c.option.MaxHeadSeries) &&
-				(os.runtime.ProcessSeries+tar.TotalSeries < c.option.MaxProcessSeries) {
-				c.log.Infof("need transfer %d target from %s to %s series = (%d) ", hash, s.shard.ID, os.shard.ID, tar.Series)
-				transferTarget(s, os, hash)
-				total -= tar.TotalSeries
-				break
-			}
+		candidates, limited := c.budget.filter(s, candidates, tar.Series)
+		if limited {
+			deferred += tar.TotalSeries
+			continue
+		}
+
+		if os := c.schedule(candidates, hash, tarSp); os != nil {
+			c.log.Infof("need transfer %d target from %s to %s series = (%d) ", hash, s.shard.ID, os.shard.ID, tar.Series)
+			c.transferTarget(s, os, hash, "alleviate process series")
+			total -= tar.TotalSeries
 		}
 	}
 
-	if total > expSeries {
-		return total - expSeries
+	// deferred transfers will be done in later coordinating and pinned targets stay, no more space is needed
+	if total-deferred > expSeries {
+		return total - deferred - expSeries
 	}
 	return 0
 }
 
-func transferTarget(from, to *shardInfo, hash uint64) {
+func (c *Coordinator) transferTarget(from, to *shardInfo, hash uint64, reason string) {
+	c.affinity.remove(from, hash)
+	c.affinity.add(to, hash)
+	c.targetEvent(EventTransferStart, hash, from, to, reason)
 	tar := from.scraping[hash]
-	to.runtime.ProcessSeries += tar.TotalSeries
-	to.runtime.HeadSeries += tar.Series
+	c.budget.use(from, to, tar.Series)
+	c.metrics.transferTargetsTotal.WithLabelValues().Inc()
+	to.addSpace(c.targetSpace(hash, tar))
 	newTar := *tar
-	tar.TargetState = target.StateInTransfer
 	to.scraping[hash] = &newTar
+	// status of target may be shared with global scraping status, which must not be changed in dry run
+	old := *tar
+	old.TargetState = target.StateInTransfer
+	from.scraping[hash] = &old
 }
 
 func seriesWithRate(series int64, rate float64) int64 {
@@ -408,6 +494,7 @@ func (c *Coordinator) assignNoScrapingTargets(
 	shards []*shardInfo,
 	active map[uint64]*discovery.SDTargets,
 	globalScrapeStatus map[uint64]*target.ScrapeStatus,
+	opt *Option,
 ) space {
 	needSp := space{}
 	healthShards := changeAbleShardsInfo(shards)
@@ -428,29 +515,43 @@ func (c *Coordinator) assignNoScrapingTargets(
 		status := globalScrapeStatus[hash]
 		if status == nil || status.Health != scrape.HealthGood {
 			//c.log.Warnf("target %s status not found or not health", tar.ShardTarget.NoParamURL())
+			c.markUnassigned(hash, unassignedUnhealthy, "target is not explored or not healthy")
 			continue
 		}
 		// we may mark too big target as heath down in explore
 		// double check here
-		if c.isTooBig(status) {
+		tarSp := c.targetSpace(hash, status)
+		if isTooBig(status, opt, healthShards) || loadTooBig(tarSp, opt) {
 			c.log.Warnf("target too big: %s", tar.ShardTarget.NoParamURL())
+			c.markUnassigned(hash, unassignedTooBig, "target is too big for any shard")
 			continue
 		}
 
-		tarSp := space{
-			headSpace:    status.Series,
-			processSpace: status.TotalSeries,
+		// targets of groups that exceed quota are not assigned, and no more space is needed
+		if c.quota != nil {
+			if reason := c.quota.check(tar, status.Series); reason != "" {
+				c.markUnassigned(hash, unassignedQuota, reason)
+				continue
+			}
 		}
 
-		// try get free shard which can hold this target
-		sd := c.getFreeShard(healthShards, tarSp)
+		// pinned target is placed to its shard directly, otherwise try get free shard which can hold this target
+		sd := shardByID(healthShards, c.pinnedTo(hash))
+		if sd == nil || sd.cordoned {
+			sd = c.getFreeShard(healthShards, hash, tarSp)
+		}
 		if sd != nil {
-			sd.runtime.HeadSeries += status.Series
-			sd.runtime.ProcessSeries += status.TotalSeries
+			c.affinity.add(sd, hash)
+			sd.addSpace(tarSp)
 			sd.scraping[hash] = status
-			assignNoScrapingTargetsTotal.WithLabelValues().Inc()
+			if c.quota != nil {
+				c.quota.add(tar, status.Series)
+			}
+			c.targetEvent(EventAssign, hash, nil, sd, "target is not scraped by any shard")
+			c.metrics.assignTargetsTotal.WithLabelValues().Inc()
 		} else {
 			// no shard avaliable
+			c.markUnassigned(hash, unassignedNoSpace, "no shard has enough space")
 			needSp.add(tarSp)
 		}
 	}
@@ -458,42 +559,75 @@ func (c *Coordinator) assignNoScrapingTargets(
 	return needSp
 }
 
-func (c *Coordinator) isTooBig(tar *target.ScrapeStatus) bool {
-	return (c.option.MaxHeadSeries != 0 && tar.Series > c.option.MaxHeadSeries) ||
-		tar.Series > c.option.MaxProcessSeries
+// markUnassigned record the reason why target is not assigned in current coordinating
+// kind is the category of reason, such as unassignedNoSpace, which is used by metrics
+func (c *Coordinator) markUnassigned(hash uint64, kind, reason string) {
+	if c.unassigned == nil {
+		c.unassigned = map[uint64]string{}
+	}
+	if c.unassignedKinds == nil {
+		c.unassignedKinds = map[uint64]string{}
+	}
+	c.unassigned[hash] = reason
+	c.unassignedKinds[hash] = kind
 }
 
-func (c *Coordinator) getFreeShard(shards []*shardInfo, sp space) *shardInfo {
-	cs := make([]wr.Choice, 0)
+// isTooBig return true if series of target is over the limits of option and limits of all shards
+func isTooBig(tar *target.ScrapeStatus, opt *Option, shards []*shardInfo) bool {
+	if !seriesTooBig(tar.Series, opt.MaxHeadSeries, opt.MaxProcessSeries) {
+		return false
+	}
+
+	// shard with bigger capacity may hold it
 	for _, s := range shards {
-		if !s.changeAble {
-			continue
+		if !seriesTooBig(tar.Series, s.maxHeadSeries, s.maxProcessSeries) {
+			return false
 		}
+	}
+	return true
+}
 
-		if (c.option.MaxHeadSeries == 0 || s.runtime.HeadSeries+sp.headSpace < c.option.MaxHeadSeries) &&
-			s.runtime.ProcessSeries+sp.processSpace < c.option.MaxProcessSeries {
-			if c.option.MaxIdleTime != 0 {
-				return s
-			}
+func seriesTooBig(series, maxHeadSeries, maxProcessSeries int64) bool {
+	return (maxHeadSeries != 0 && series > maxHeadSeries) || series > maxProcessSeries
+}
 
-			p := c.option.MaxProcessSeries - s.runtime.ProcessSeries
-			if c.option.MaxHeadSeries != 0 {
-				p = c.option.MaxHeadSeries - s.runtime.HeadSeries
-			}
+// getFreeShard return a shard that can hold target "hash" with space "sp", the shard is chosen by scheduler
+func (c *Coordinator) getFreeShard(shards []*shardInfo, hash uint64, sp space) *shardInfo {
+	return c.schedule(c.freeShards(shards, sp), hash, sp)
+}
 
-			cs = append(cs, wr.Choice{
-				Item:   s,
-				Weight: uint(p),
-			})
+// freeShards return changeable shards that can hold space "sp", cordoned and alleviating shards are skipped
+func (c *Coordinator) freeShards(shards []*shardInfo, sp space) []*shardInfo {
+	candidates := make([]*shardInfo, 0)
+	for _, s := range shards {
+		if s.changeAble && !s.cordoned && !s.alleviating && c.shardCanHold(s, sp) {
+			candidates = append(candidates, s)
 		}
 	}
+	return candidates
+}
+
+// schedule choose one shard from candidates for target "hash", affinity rules is considered in preference
+func (c *Coordinator) schedule(candidates []*shardInfo, hash uint64, sp space) *shardInfo {
+	candidates = c.affinity.filter(candidates, hash)
+	views := make([]ShardView, 0, len(candidates))
+	for _, s := range candidates {
+		views = append(views, newShardView(s))
+	}
 
-	if len(cs) == 0 {
+	i := c.scheduler.Schedule(views, newDemand(sp))
+	if i < 0 || i >= len(candidates) {
 		return nil
 	}
+	return candidates[i]
+}
 
-	cr, _ := wr.NewChooser(cs...)
-	return cr.Pick().(*shardInfo)
+// shardCanHold return true if shard has enough space to receive "sp"
+func (c *Coordinator) shardCanHold(s *shardInfo, sp space) bool {
+	return (s.maxHeadSeries == 0 || s.runtime.HeadSeries+sp.headSpace < s.maxHeadSeries) &&
+		s.runtime.ProcessSeries+sp.processSpace < s.maxProcessSeries &&
+		s.memoryCanHold(sp.headSpace) &&
+		s.loadCanHold(sp)
 }
 
 func (c *Coordinator) globalScrapeStatus(
@@ -533,7 +667,7 @@ func (c *Coordinator) tryScaleDown(shards []*shardInfo) int32 {
 	// check for scale able shard
 	for ; i >= 0; i-- {
 		s := shards[i]
-		if s.changeAble && s.runtime.IdleStartAt != nil && time.Now().Sub(*s.runtime.IdleStartAt) > c.option.MaxIdleTime {
+		if s.changeAble && !s.cordoned && s.runtime.IdleStartAt != nil && time.Now().Sub(*s.runtime.IdleStartAt) > c.option.MaxIdleTime {
 			c.log.Infof("%s is remove able", s.shard.ID)
 			scale--
 		} else {
@@ -544,8 +678,8 @@ func (c *Coordinator) tryScaleDown(shards []*shardInfo) int32 {
 	// try transfer targets from tail shard to head shards
 	for ; i > 0; i-- {
 		from := shards[i]
-		// skip idle shard
-		if from.runtime.IdleStartAt != nil {
+		// skip idle shard and cordoned shard
+		if from.runtime.IdleStartAt != nil || from.cordoned {
 			continue
 		}
 
@@ -568,29 +702,33 @@ func (c *Coordinator) shardCanBeIdle(src *shardInfo, shards []*shardInfo) bool {
 		return false
 	}
 
-	availableSpaces := make([]space, 0)
+	// used is the space of targets that expected to be transferred to candidates
+	candidates := make([]*shardInfo, 0)
+	used := make([]space, 0)
 	for _, s := range shards {
-		if s != src && s.changeAble {
-			sp := space{
-				processSpace: c.option.MaxProcessSeries - s.runtime.ProcessSeries,
-				headSpace:    c.option.MaxHeadSeries - s.runtime.HeadSeries,
-			}
-
-			availableSpaces = append(availableSpaces, sp)
+		if s != src && s.changeAble && !s.cordoned && !s.alleviating {
+			candidates = append(candidates, s)
+			used = append(used, space{})
 		}
 	}
 
 l1:
-	for _, tar := range src.scraping {
+	for hash, tar := range src.scraping {
 		if tar.TargetState != target.StateNormal || tar.ScrapeTimes < minWaitScrapeTimes {
 			return false
 		}
 
-		for i := range availableSpaces {
-			if (c.option.MaxHeadSeries == 0 || availableSpaces[i].headSpace > tar.Series) &&
-				availableSpaces[i].processSpace > tar.TotalSeries {
-				availableSpaces[i].headSpace -= tar.Series
-				availableSpaces[i].processSpace -= tar.TotalSeries
+		// shard with pinned target can not be idle
+		if c.pinnedTo(hash) != "" {
+			return false
+		}
+
+		tarSp := c.targetSpace(hash, tar)
+		for i, s := range candidates {
+			sp := used[i]
+			sp.add(tarSp)
+			if c.shardCanHold(s, sp) {
+				used[i] = sp
 				continue l1
 			}
 		}
@@ -607,31 +745,56 @@ func (c *Coordinator) shardBecomeIdle(src *shardInfo, shards []*shardInfo) bool
 			continue
 		}
 
-		tarSp := space{
-			headSpace:    tar.Series,
-			processSpace: tar.TotalSeries,
+		tarSp := c.targetSpace(hash, tar)
+		candidates, limited := c.budget.filter(src, c.freeShards(shards, tarSp), tar.Series)
+		// the rest targets will be transferred in later coordinating
+		if limited {
+			return false
 		}
 
-		to := c.getFreeShard(shards, tarSp)
+		to := c.schedule(candidates, hash, tarSp)
 		// no free space to receive target
 		if to == nil || to == src {
 			return false
 		}
 		c.log.Infof("transfer target from %s to %s series = (%d) ", src.shard.ID, to.shard.ID, tar.Series)
-		transferTarget(src, to, hash)
+		c.transferTarget(src, to, hash, "make shard idle to scale down")
 	}
 
 	return true
 }
 
 // tryScaleUp calculate the expect scale according to 'needSpace'
-func (c *Coordinator) tryScaleUp(shard []*shardInfo, sp space) int32 {
+// new shards are sized by the limits they will get, see newShardLimits
+func (c *Coordinator) tryScaleUp(shard []*shardInfo, sp space, opt *Option) int32 {
 	health := changeAbleShardsInfo(shard)
 	exp := int32(len(health))
+	limits := newShardLimits(shard, opt)
+
+	up := shardsNeeded(float64(sp.processSpace), float64(limits.maxProcessSeries))
+	if up == 0 {
+		up = 1
+	}
+	if n := shardsNeeded(float64(sp.headSpace), float64(limits.maxHeadSeries)); n > up {
+		up = n
+	}
 
-	up := int32((sp.processSpace / c.option.MaxProcessSeries) + 1)
-	if c.option.MaxHeadSeries != 0 && int32((sp.headSpace/c.option.MaxHeadSeries)+1) > up {
-		up = int32((sp.headSpace / c.option.MaxHeadSeries) + 1)
+	// head series is converted to memory by the average memory cost of series
+	if bps := avgBytesPerSeries(health); limits.maxMemoryBytes != 0 && bps != 0 {
+		if m := int32(float64(sp.headSpace)*bps/float64(limits.maxMemoryBytes)) + 1; m > up {
+			up = m
+		}
+	}
+
+	// other dimensions are only considered if their limits are set
+	for _, n := range []int32{
+		shardsNeeded(sp.samplesRate, limits.maxSamplesRate),
+		shardsNeeded(float64(sp.targets), float64(limits.maxTargets)),
+		shardsNeeded(sp.scrapeDuration, limits.maxScrapeDuration),
+	} {
+		if n > up {
+			up = n
+		}
 	}
 
 	exp += up
@@ -0,0 +1,197 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"fmt"
+
+	wr "github.com/mroth/weightedrand"
+)
+
+const (
+	// SchedulerFirstFit place target to the first shard that can hold it
+	// lower-numbered shards is preferred, which makes tail shards become idle easily
+	SchedulerFirstFit = "first-fit"
+	// SchedulerWeightedRandom place target to a random shard, shard with more free space has higher probability
+	SchedulerWeightedRandom = "weighted-random"
+	// SchedulerBestFit place target to the shard with least free space that can still hold it (bin packing)
+	SchedulerBestFit = "best-fit"
+	// SchedulerLeastLoaded place target to the shard with most free space, targets are spread to all shards
+	SchedulerLeastLoaded = "least-loaded"
+)
+
+// Schedulers contains all built-in scheduler names
+var Schedulers = []string{
+	SchedulerFirstFit,
+	SchedulerWeightedRandom,
+	SchedulerBestFit,
+	SchedulerLeastLoaded,
+}
+
+// ShardView is the read-only state of a candidate shard given to Scheduler
+type ShardView struct {
+	// ID is the shard ID
+	ID string
+	// HeadSeries is the head series of shard, targets assigned in current coordinating are included
+	HeadSeries int64
+	// MaxHeadSeries is max head series this shard can assign, skipped if 0
+	MaxHeadSeries int64
+	// ProcessSeries is the process series of shard, targets assigned in current coordinating are included
+	ProcessSeries int64
+	// MaxProcessSeries is max process series this shard can assign
+	MaxProcessSeries int64
+	// Targets is the number of targets of shard
+	Targets int64
+}
+
+// FreeSpace return the series space that shard can still receive
+// head series is used if max head series of shard is set, otherwise process series is used
+func (v *ShardView) FreeSpace() int64 {
+	if v.MaxHeadSeries != 0 {
+		return v.MaxHeadSeries - v.HeadSeries
+	}
+	return v.MaxProcessSeries - v.ProcessSeries
+}
+
+// Demand is the space a target need
+type Demand struct {
+	// HeadSeries is the series of target after metrics_relabels
+	HeadSeries int64
+	// ProcessSeries is the series of target before metrics_relabels
+	ProcessSeries int64
+	// SamplesRate is the ingested samples per second of target, 0 if unknown
+	SamplesRate float64
+	// ScrapeDuration is the seconds of last scraping of target
+	ScrapeDuration float64
+}
+
+// Scheduler decide which shard a target should be placed to
+// custom Scheduler can be set by Option.CustomScheduler
+type Scheduler interface {
+	// Schedule pick one shard from candidates for a target that need "demand", the index of it is returned
+	// all candidates can hold this target, -1 should be returned if candidates is empty
+	Schedule(candidates []ShardView, demand Demand) int
+}
+
+// newScheduler create a built-in Scheduler by name, Option.CustomScheduler is used if it is set
+// if name is empty, first-fit is used when scale down is enabled, otherwise weighted-random is used
+func newScheduler(option *Option) (Scheduler, error) {
+	if option.CustomScheduler != nil {
+		return option.CustomScheduler, nil
+	}
+
+	name := option.Scheduler
+	if name == "" {
+		name = SchedulerWeightedRandom
+		if option.MaxIdleTime != 0 {
+			name = SchedulerFirstFit
+		}
+	}
+
+	switch name {
+	case SchedulerFirstFit:
+		return &firstFitScheduler{}, nil
+	case SchedulerWeightedRandom:
+		return &weightedRandomScheduler{}, nil
+	case SchedulerBestFit:
+		return &bestFitScheduler{}, nil
+	case SchedulerLeastLoaded:
+		return &leastLoadedScheduler{}, nil
+	default:
+		return nil, fmt.Errorf("unknown scheduler %s", name)
+	}
+}
+
+func newShardView(s *shardInfo) ShardView {
+	return ShardView{
+		ID:               s.shard.ID,
+		HeadSeries:       s.runtime.HeadSeries,
+		MaxHeadSeries:    s.maxHeadSeries,
+		ProcessSeries:    s.runtime.ProcessSeries,
+		MaxProcessSeries: s.maxProcessSeries,
+		Targets:          s.targets,
+	}
+}
+
+func newDemand(sp space) Demand {
+	return Demand{
+		HeadSeries:     sp.headSpace,
+		ProcessSeries:  sp.processSpace,
+		SamplesRate:    sp.samplesRate,
+		ScrapeDuration: sp.scrapeDuration,
+	}
+}
+
+type firstFitScheduler struct{}
+
+// Schedule return the first candidate
+func (f *firstFitScheduler) Schedule(candidates []ShardView, demand Demand) int {
+	if len(candidates) == 0 {
+		return -1
+	}
+	return 0
+}
+
+type weightedRandomScheduler struct{}
+
+// Schedule return a random candidate weighted by free space
+func (w *weightedRandomScheduler) Schedule(candidates []ShardView, demand Demand) int {
+	cs := make([]wr.Choice, 0, len(candidates))
+	for i := range candidates {
+		cs = append(cs, wr.Choice{
+			Item:   i,
+			Weight: uint(candidates[i].FreeSpace()),
+		})
+	}
+
+	if len(cs) == 0 {
+		return -1
+	}
+
+	cr, err := wr.NewChooser(cs...)
+	if err != nil {
+		return 0
+	}
+	return cr.Pick().(int)
+}
+
+type bestFitScheduler struct{}
+
+// Schedule return the candidate with least free space
+func (b *bestFitScheduler) Schedule(candidates []ShardView, demand Demand) int {
+	ret := -1
+	for i := range candidates {
+		if ret == -1 || candidates[i].FreeSpace() < candidates[ret].FreeSpace() {
+			ret = i
+		}
+	}
+	return ret
+}
+
+type leastLoadedScheduler struct{}
+
+// Schedule return the candidate with most free space
+func (l *leastLoadedScheduler) Schedule(candidates []ShardView, demand Demand) int {
+	ret := -1
+	for i := range candidates {
+		if ret == -1 || candidates[i].FreeSpace() > candidates[ret].FreeSpace() {
+			ret = i
+		}
+	}
+	return ret
+}
@@ -0,0 +1,166 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/shard"
+)
+
+func newTestingShardInfo(id string, headSeries int64) *shardInfo {
+	s := newShardInfo(shard.NewShard(id, "", true, logrus.New()), &Option{MaxHeadSeries: 100, MaxProcessSeries: 1000})
+	s.changeAble = true
+	s.runtime.HeadSeries = headSeries
+	return s
+}
+
+func shardViews(shards []*shardInfo) []ShardView {
+	ret := make([]ShardView, 0, len(shards))
+	for _, s := range shards {
+		ret = append(ret, newShardView(s))
+	}
+	return ret
+}
+
+// lastScheduler always choose the last candidate
+type lastScheduler struct{}
+
+func (l *lastScheduler) Schedule(candidates []ShardView, demand Demand) int {
+	return len(candidates) - 1
+}
+
+func TestNewScheduler(t *testing.T) {
+	var cases = []struct {
+		name    string
+		option  *Option
+		want    Scheduler
+		wantErr bool
+	}{
+		{
+			name:   "default with scale down enabled",
+			option: &Option{MaxIdleTime: time.Second},
+			want:   &firstFitScheduler{},
+		},
+		{
+			name:   "default with scale down disabled",
+			option: &Option{},
+			want:   &weightedRandomScheduler{},
+		},
+		{
+			name:   "best fit",
+			option: &Option{Scheduler: SchedulerBestFit},
+			want:   &bestFitScheduler{},
+		},
+		{
+			name:   "least loaded",
+			option: &Option{Scheduler: SchedulerLeastLoaded},
+			want:   &leastLoadedScheduler{},
+		},
+		{
+			name:   "custom",
+			option: &Option{Scheduler: "xx", CustomScheduler: &lastScheduler{}},
+			want:   &lastScheduler{},
+		},
+		{
+			name:    "unknown",
+			option:  &Option{Scheduler: "xx"},
+			wantErr: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			s, err := newScheduler(cs.option)
+			if cs.wantErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			r.IsType(cs.want, s)
+		})
+	}
+}
+
+func TestScheduler_Schedule(t *testing.T) {
+	var cases = []struct {
+		name      string
+		scheduler Scheduler
+		wantIndex int
+	}{
+		{
+			name:      "first fit",
+			scheduler: &firstFitScheduler{},
+			wantIndex: 0,
+		},
+		{
+			name:      "best fit",
+			scheduler: &bestFitScheduler{},
+			wantIndex: 1,
+		},
+		{
+			name:      "least loaded",
+			scheduler: &leastLoadedScheduler{},
+			wantIndex: 2,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			shards := []*shardInfo{
+				newTestingShardInfo("0", 50),
+				newTestingShardInfo("1", 80),
+				newTestingShardInfo("2", 10),
+			}
+			r.Equal(-1, cs.scheduler.Schedule(nil, Demand{HeadSeries: 1}))
+			r.Equal(cs.wantIndex, cs.scheduler.Schedule(shardViews(shards), Demand{HeadSeries: 1}))
+		})
+	}
+}
+
+func TestWeightedRandomScheduler_Schedule(t *testing.T) {
+	r := require.New(t)
+	s := &weightedRandomScheduler{}
+	r.Equal(-1, s.Schedule(nil, Demand{}))
+
+	shards := []*shardInfo{
+		newTestingShardInfo("0", 100),
+		newTestingShardInfo("1", 10),
+	}
+	// shard 0 has no free space, it never be chosen
+	for i := 0; i < 10; i++ {
+		r.Equal(1, s.Schedule(shardViews(shards), Demand{HeadSeries: 1}))
+	}
+}
+
+func TestCoordinator_ScheduleCustom(t *testing.T) {
+	r := require.New(t)
+	c := newTestingMoveCoordinator()
+	c.scheduler = &lastScheduler{}
+	shards := []*shardInfo{
+		newTestingShardInfo("0", 0),
+		newTestingShardInfo("1", 0),
+	}
+	r.Equal(shards[1], c.schedule(shards, 1, space{headSpace: 1}))
+	r.Nil(c.schedule(nil, 1, space{headSpace: 1}))
+}
@@ -19,8 +19,10 @@ package coordinator
 
 import (
 	"fmt"
+	"io"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -43,61 +45,94 @@ import (
 	"tkestack.io/kvass/pkg/target"
 )
 
+// ServiceOption contains the data sources and operations used by Service
+// nil functions must not be used by requests, most of them are methods of Coordinator
+type ServiceOption struct {
+	// ConfigFile is the config file reloaded by /-/reload
+	ConfigFile string
+	// ConfigManager is the config manager of coordinator
+	ConfigManager *prom.ConfigManager
+	// PromRegistry is the registry of metrics of /metrics
+	PromRegistry *prometheus.Registry
+	// IsLeader return false if this coordinator is a follower, write requests are rejected on followers
+	IsLeader func() bool
+	// GetLeader return the identity of current leader, it is returned to clients of followers
+	GetLeader func() string
+
+	GetLastScrapeStatistics func(jobName string, withoutMetricsDetail bool) (map[string]*kscrape.StatisticsSeriesResult, error)
+	GetScrapeStatus         func() map[uint64]*target.ScrapeStatus
+	GetActiveTargets        func() map[string][]*discovery.SDTargets
+	GetDropTargets          func() map[string][]*discovery.SDTargets
+	GetUnassigned           func() map[uint64]string
+	GetPlan                 func() *Plan
+	GetEvents               func() []*Event
+	ExportState             func() *State
+	ImportState             func(st *State) error
+	MoveTarget              func(hash uint64, to string, pin bool) error
+	UnpinTarget             func(hash uint64) error
+	GetPins                 func() map[uint64]string
+	CordonShard             func(id string, drain bool) error
+	UncordonShard           func(id string) error
+	GetCordons              func() []*ShardCordon
+}
+
 // Service is the api server of coordinator
 type Service struct {
 	// gin.Engine is the gin engine for handle http request
 	*gin.Engine
-	configFile              string
-	lg                      logrus.FieldLogger
-	cfgManager              *prom.ConfigManager
-	getScrapeStatus         func() map[uint64]*target.ScrapeStatus
-	getLastScrapeStatistics func(jobName string, withoutMetricsDetail bool) (map[string]*kscrape.StatisticsSeriesResult, error)
-	getActiveTargets        func() map[string][]*discovery.SDTargets
-	getDropTargets          func() map[string][]*discovery.SDTargets
+	ServiceOption
+	lg logrus.FieldLogger
 }
 
 // NewService return a new web server
-func NewService(
-	configFile string,
-	cfgManager *prom.ConfigManager,
-	getLastScrapeStatistics func(jobName string, withoutMetricsDetail bool) (map[string]*kscrape.StatisticsSeriesResult, error),
-	getScrapeStatus func() map[uint64]*target.ScrapeStatus,
-	getActiveTargets func() map[string][]*discovery.SDTargets,
-	getDropTargets func() map[string][]*discovery.SDTargets,
-	promRegistry *prometheus.Registry,
-	lg logrus.FieldLogger) *Service {
-
+func NewService(opt ServiceOption, lg logrus.FieldLogger) *Service {
 	w := &Service{
-		configFile:              configFile,
-		Engine:                  gin.Default(),
-		lg:                      lg,
-		cfgManager:              cfgManager,
-		getScrapeStatus:         getScrapeStatus,
-		getActiveTargets:        getActiveTargets,
-		getDropTargets:          getDropTargets,
-		getLastScrapeStatistics: getLastScrapeStatistics,
+		Engine:        gin.Default(),
+		ServiceOption: opt,
+		lg:            lg,
 	}
 
 	pprof.Register(w.Engine)
 
-	h := api.NewHelper(lg, promRegistry, "kvass_coordinator")
+	h := api.NewHelper(lg, opt.PromRegistry, "kvass_coordinator")
 	w.GET("/metrics", h.MetricsHandler)
 	w.GET("/api/v1/targets", h.Wrap(w.targets))
 	w.GET("/api/v1/runtimeinfo", h.Wrap(w.runtimeInfo))
 	w.GET("/api/v1/samples", h.Wrap(w.samples))
+	w.GET("/api/v1/plan", h.Wrap(w.plan))
+	w.GET("/api/v1/state", h.Wrap(func(ctx *gin.Context) *api.Result {
+		return api.Data(w.ExportState())
+	}))
+	w.POST("/api/v1/state", h.Wrap(w.leaderOnly(w.importStateHandler)))
+	w.GET("/api/v1/events", h.Wrap(w.events))
+	w.POST("/api/v1/targets/:hash/move", h.Wrap(w.leaderOnly(w.moveTargetHandler)))
+	w.DELETE("/api/v1/targets/:hash/pin", h.Wrap(w.leaderOnly(w.unpinTargetHandler)))
+	w.GET("/api/v1/pins", h.Wrap(func(ctx *gin.Context) *api.Result {
+		return api.Data(w.GetPins())
+	}))
+	w.POST("/api/v1/shards/:id/cordon", h.Wrap(w.leaderOnly(func(ctx *gin.Context) *api.Result {
+		return w.cordonHandler(ctx, false)
+	})))
+	w.POST("/api/v1/shards/:id/drain", h.Wrap(w.leaderOnly(func(ctx *gin.Context) *api.Result {
+		return w.cordonHandler(ctx, true)
+	})))
+	w.POST("/api/v1/shards/:id/uncordon", h.Wrap(w.leaderOnly(w.uncordonHandler)))
+	w.GET("/api/v1/cordons", h.Wrap(func(ctx *gin.Context) *api.Result {
+		return api.Data(w.GetCordons())
+	}))
 	w.POST("/-/reload", h.Wrap(func(ctx *gin.Context) *api.Result {
-		if err := w.cfgManager.ReloadFromFile(configFile); err != nil {
+		if err := w.ConfigManager.ReloadFromFile(w.ConfigFile); err != nil {
 			return api.BadDataErr(err, "reload failed")
 		}
 		return api.Data(nil)
 	}))
 
 	w.GET("/api/v1/status/config", h.Wrap(func(ctx *gin.Context) *api.Result {
-		return api.Data(gin.H{"yaml": string(cfgManager.ConfigInfo().RawContent)})
+		return api.Data(gin.H{"yaml": string(w.ConfigManager.ConfigInfo().RawContent)})
 	}))
 	w.POST("/api/v1/status/extra_config", h.Wrap(w.updateExtraConfig))
 	w.GET("/api/v1/status/extra_config", h.Wrap(func(ctx *gin.Context) *api.Result {
-		return api.Data(gin.H{"json": test.MustJSON(cfgManager.ConfigInfo().ExtraConfig)})
+		return api.Data(gin.H{"json": test.MustJSON(w.ConfigManager.ConfigInfo().ExtraConfig)})
 	}))
 	return w
 }
@@ -108,14 +143,14 @@ func (s *Service) samples(ctx *gin.Context) *api.Result {
 		targetJob  = ctx.Query("job")
 		withDetail = ctx.Query("with_metrics_detail")
 	)
-	smp, err := s.getLastScrapeStatistics(targetJob, withDetail == "true")
+	smp, err := s.GetLastScrapeStatistics(targetJob, withDetail == "true")
 	if err != nil {
 		s.lg.Errorf(err.Error())
 		return api.InternalErr(err, "")
 	}
 
 	ret := map[string]*kscrape.StatisticsSeriesResult{}
-	for _, job := range s.cfgManager.ConfigInfo().Config.ScrapeConfigs {
+	for _, job := range s.ConfigManager.ConfigInfo().Config.ScrapeConfigs {
 		if targetJob != "" && !strings.Contains(job.JobName, targetJob) {
 			continue
 		}
@@ -138,13 +173,146 @@ func (s *Service) samples(ctx *gin.Context) *api.Result {
 	return api.Data(ret)
 }
 
+// plan return the decisions made by last coordinating
+// nothing is applied to shards if coordinator is running in dry-run mode
+func (s *Service) plan(ctx *gin.Context) *api.Result {
+	p := s.GetPlan()
+	if p == nil {
+		return api.Data(&Plan{Replicas: []*ReplicaPlan{}})
+	}
+	return api.Data(p)
+}
+
+// events return the latest coordinating events, the oldest is the first one
+// we support some query param:
+// - type: "assign", "transfer-start" .etc (return events with specific type)
+// - job: "job_name" (return events of targets with specific job_name)
+// - hash: "target_hash" (return events of specific target)
+// - limit: "n" (return the latest n events only)
+func (s *Service) events(ctx *gin.Context) *api.Result {
+	var (
+		hash  uint64
+		limit int
+		err   error
+	)
+
+	if h := ctx.Query("hash"); h != "" {
+		if hash, err = strconv.ParseUint(h, 10, 64); err != nil {
+			return api.BadDataErr(fmt.Errorf("wrong format of hash"), "")
+		}
+	}
+
+	if l := ctx.Query("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			return api.BadDataErr(fmt.Errorf("wrong format of limit"), "")
+		}
+	}
+
+	ret := filterEvents(s.GetEvents(), ctx.Query("type"), ctx.Query("job"), hash)
+	if limit != 0 && len(ret) > limit {
+		ret = ret[len(ret)-limit:]
+	}
+	return api.Data(ret)
+}
+
+// leaderOnly reject the request with 503 and the identity of current leader if this coordinator is not the leader
+// write requests accepted by followers would be lost since only the leader coordinates and saves state
+func (s *Service) leaderOnly(f func(ctx *gin.Context) *api.Result) func(ctx *gin.Context) *api.Result {
+	return func(ctx *gin.Context) *api.Result {
+		if s.IsLeader == nil || s.IsLeader() {
+			return f(ctx)
+		}
+
+		leader := ""
+		if s.GetLeader != nil {
+			leader = s.GetLeader()
+		}
+		return &api.Result{
+			ErrorType: api.ErrorNotLeader,
+			Status:    api.StatusError,
+			Err:       fmt.Sprintf("coordinator is not leader, current leader is %q", leader),
+			Data:      gin.H{"leader": leader},
+		}
+	}
+}
+
+// MoveTargetRequest is the request body of POST /api/v1/targets/{hash}/move
+type MoveTargetRequest struct {
+	// Shard is the ID of destination shard, a free shard is chosen if it is empty
+	Shard string `json:"shard"`
+	// Pin keep target on destination shard until DELETE /api/v1/targets/{hash}/pin
+	Pin bool `json:"pin"`
+}
+
+// moveTargetHandler request moving one target to other shard in next coordinating
+// the request body is optional
+func (s *Service) moveTargetHandler(ctx *gin.Context) *api.Result {
+	hash, err := strconv.ParseUint(ctx.Param("hash"), 10, 64)
+	if err != nil {
+		return api.BadDataErr(fmt.Errorf("wrong format of hash"), "")
+	}
+
+	req := &MoveTargetRequest{}
+	if err := ctx.ShouldBindJSON(req); err != nil && err != io.EOF {
+		return api.BadDataErr(err, "bind json")
+	}
+
+	if err := s.MoveTarget(hash, req.Shard, req.Pin); err != nil {
+		return api.BadDataErr(err, "move target")
+	}
+	return api.Data(nil)
+}
+
+// unpinTargetHandler allow pinned target to be transferred by coordinator again
+func (s *Service) unpinTargetHandler(ctx *gin.Context) *api.Result {
+	hash, err := strconv.ParseUint(ctx.Param("hash"), 10, 64)
+	if err != nil {
+		return api.BadDataErr(fmt.Errorf("wrong format of hash"), "")
+	}
+
+	if err := s.UnpinTarget(hash); err != nil {
+		return api.BadDataErr(err, "unpin target")
+	}
+	return api.Data(nil)
+}
+
+// cordonHandler mark shard unschedulable, all targets of shard are transferred to other shards if "drain" is true
+// drain progress can be got from GET /api/v1/cordons
+func (s *Service) cordonHandler(ctx *gin.Context, drain bool) *api.Result {
+	if err := s.CordonShard(ctx.Param("id"), drain); err != nil {
+		return api.BadDataErr(err, "cordon shard")
+	}
+	return api.Data(nil)
+}
+
+// uncordonHandler mark shard schedulable again
+func (s *Service) uncordonHandler(ctx *gin.Context) *api.Result {
+	if err := s.UncordonShard(ctx.Param("id")); err != nil {
+		return api.BadDataErr(err, "uncordon shard")
+	}
+	return api.Data(nil)
+}
+
+// importStateHandler warm-start coordinator with state exported from another coordinator
+func (s *Service) importStateHandler(g *gin.Context) *api.Result {
+	st := &State{}
+	if err := g.BindJSON(st); err != nil {
+		return api.BadDataErr(err, "bind json")
+	}
+
+	if err := s.ImportState(st); err != nil {
+		return api.BadDataErr(err, "import state")
+	}
+	return api.Data(nil)
+}
+
 func (s *Service) updateExtraConfig(g *gin.Context) *api.Result {
 	c := prom.ExtraConfig{}
 	if err := g.BindJSON(&c); err != nil {
 		return api.BadDataErr(err, "bind json")
 	}
 
-	if err := s.cfgManager.UpdateExtraConfig(c); err != nil {
+	if err := s.ConfigManager.UpdateExtraConfig(c); err != nil {
 		return api.BadDataErr(err, "reload failed")
 	}
 
@@ -154,10 +322,10 @@ func (s *Service) updateExtraConfig(g *gin.Context) *api.Result {
 // runtimeInfo return statistics runtimeInfo of all shards
 func (s *Service) runtimeInfo(ctx *gin.Context) *api.Result {
 	rt := &shard.RuntimeInfo{
-		ConfigHash: s.cfgManager.ConfigInfo().ConfigHash,
+		ConfigHash: s.ConfigManager.ConfigInfo().ConfigHash,
 	}
 
-	for _, st := range s.getScrapeStatus() {
+	for _, st := range s.GetScrapeStatus() {
 		rt.HeadSeries += st.Series
 		rt.ProcessSeries += st.TotalSeries
 	}
@@ -175,6 +343,8 @@ type ExtendTarget struct {
 	TotalSeries int64 `json:"totalSeries"`
 	// Shards contains ID of shards that is scraping this target
 	Shards []string `json:"shards"`
+	// UnassignedReason is the reason why target is not assigned to any shard, e.g. quota exceeded
+	UnassignedReason string `json:"unassignedReason,omitempty"`
 }
 
 // TargetDiscovery has all the active targets.
@@ -245,7 +415,7 @@ func (s *Service) getTargets(state string, statistics string, health []string, j
 	}
 
 	if showDropped && statistics != "only" {
-		tDropped := flatten(s.getDropTargets())
+		tDropped := flatten(s.GetDropTargets())
 		res.DroppedTargets = make([]*v1.DroppedTarget, 0, len(tDropped))
 		for _, t := range tDropped {
 			res.DroppedTargets = append(res.DroppedTargets, &v1.DroppedTarget{
@@ -260,8 +430,12 @@ func (s *Service) getTargets(state string, statistics string, health []string, j
 
 func (s *Service) statisticActiveTargets(jobRegexp []*regexp.Regexp, health []string) ([]*ExtendTarget, []TargetStatistics) {
 	sts := make([]TargetStatistics, 0)
-	status := s.getScrapeStatus()
-	activeTargets := s.getActiveTargets()
+	status := s.GetScrapeStatus()
+	unassigned := map[uint64]string{}
+	if s.GetUnassigned != nil {
+		unassigned = s.GetUnassigned()
+	}
+	activeTargets := s.GetActiveTargets()
 	activeKeys, numTargets := sortKeys(activeTargets)
 	targets := make([]*ExtendTarget, 0, numTargets)
 	for _, jobName := range activeKeys {
@@ -285,7 +459,9 @@ func (s *Service) statisticActiveTargets(jobRegexp []*regexp.Regexp, health []st
 			if len(health) != 0 && !types.FindString(string(rt.Health), health...) {
 				continue
 			}
-			targets = append(targets, makeTarget(jobName, t.PromTarget, rt))
+			et := makeTarget(jobName, t.PromTarget, rt)
+			et.UnassignedReason = unassigned[t.ShardTarget.Hash]
+			targets = append(targets, et)
 		}
 
 		sts = append(sts, jobSts)
@@ -18,6 +18,7 @@
 package coordinator
 
 import (
+	"fmt"
 	"net/http"
 	"net/url"
 	"testing"
@@ -217,8 +218,17 @@ func TestAPI_Targets(t *testing.T) {
 	}
 	for _, cs := range cases {
 		t.Run(cs.name, func(t *testing.T) {
-			a := NewService("", prom.NewConfigManager(), nil, getScrapeStatus, getActive, getDrop,
-				prometheus.NewRegistry(), logrus.New())
+			getUnassigned := func() map[uint64]string {
+				return map[uint64]string{1: "over quota"}
+			}
+			a := NewService(ServiceOption{
+				ConfigManager:    prom.NewConfigManager(),
+				PromRegistry:     prometheus.NewRegistry(),
+				GetScrapeStatus:  getScrapeStatus,
+				GetActiveTargets: getActive,
+				GetDropTargets:   getDrop,
+				GetUnassigned:    getUnassigned,
+			}, logrus.New())
 			uri := "/api/v1/targets"
 			if len(cs.param) != 0 {
 				uri += "?" + cs.param.Encode()
@@ -228,23 +238,299 @@ func TestAPI_Targets(t *testing.T) {
 			r, _ := api.TestCall(t, a.Engine.ServeHTTP, uri, http.MethodGet, "", res)
 			r.Equal(cs.wantActive, len(res.ActiveTargets))
 			r.Equal(cs.wantDropped, len(res.DroppedTargets))
+			for _, tar := range res.ActiveTargets {
+				r.Equal("over quota", tar.UnassignedReason)
+			}
 			r.JSONEq(test.MustJSON(cs.wantStatistics), test.MustJSON(res.ActiveStatistics))
 		})
 	}
 }
 
 func TestAPI_RuntimeInfo(t *testing.T) {
-	a := NewService("", prom.NewConfigManager(), nil, func() map[uint64]*target.ScrapeStatus {
-		return map[uint64]*target.ScrapeStatus{
-			1: {
-				Series: 100,
-			},
-			2: {
-				Series: 100,
-			},
-		}
-	}, nil, nil, prometheus.NewRegistry(), logrus.New())
+	a := NewService(ServiceOption{
+		ConfigManager: prom.NewConfigManager(),
+		PromRegistry:  prometheus.NewRegistry(),
+		GetScrapeStatus: func() map[uint64]*target.ScrapeStatus {
+			return map[uint64]*target.ScrapeStatus{
+				1: {
+					Series: 100,
+				},
+				2: {
+					Series: 100,
+				},
+			}
+		},
+	}, logrus.New())
 	res := &shard.RuntimeInfo{}
 	r, _ := api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/runtimeinfo", http.MethodGet, "", res)
 	r.Equal(int64(200), res.HeadSeries)
 }
+
+func TestAPI_Plan(t *testing.T) {
+	var plan *Plan
+	a := NewService(ServiceOption{
+		ConfigManager: prom.NewConfigManager(),
+		PromRegistry:  prometheus.NewRegistry(),
+		GetPlan: func() *Plan {
+			return plan
+		},
+	}, logrus.New())
+
+	res := &Plan{}
+	r, _ := api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/plan", http.MethodGet, "", res)
+	r.Equal(0, len(res.Replicas))
+
+	plan = &Plan{DryRun: true, Replicas: []*ReplicaPlan{{ExpectScale: 2}}}
+	r, _ = api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/plan", http.MethodGet, "", res)
+	r.True(res.DryRun)
+	r.Equal(int32(2), res.Replicas[0].ExpectScale)
+}
+
+func TestAPI_State(t *testing.T) {
+	var imported *State
+	a := NewService(ServiceOption{
+		ConfigManager: prom.NewConfigManager(),
+		PromRegistry:  prometheus.NewRegistry(),
+		ExportState: func() *State {
+			return &State{ExploreResults: map[uint64]*target.ScrapeStatus{1: {Series: 10}}}
+		},
+		ImportState: func(st *State) error {
+			imported = st
+			return nil
+		},
+	}, logrus.New())
+
+	res := &State{}
+	r, _ := api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/state", http.MethodGet, "", res)
+	r.Equal(int64(10), res.ExploreResults[1].Series)
+
+	r, _ = api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/state", http.MethodPost, test.MustJSON(res), nil)
+	r.Equal(int64(10), imported.ExploreResults[1].Series)
+
+	r, result := api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/state", http.MethodPost, "a", nil)
+	r.Equal(api.StatusError, result.Status)
+}
+
+func TestAPI_Events(t *testing.T) {
+	events := []*Event{
+		{Type: EventAssign, Hash: 1, Job: "job1", To: "s0"},
+		{Type: EventTransferStart, Hash: 1, Job: "job1", From: "s0", To: "s1"},
+		{Type: EventAssign, Hash: 2, Job: "job2", To: "s1"},
+		{Type: EventScaleUp, FromScale: 1, ToScale: 2},
+	}
+	a := NewService(ServiceOption{
+		ConfigManager: prom.NewConfigManager(),
+		PromRegistry:  prometheus.NewRegistry(),
+		GetEvents: func() []*Event {
+			return events
+		},
+	}, logrus.New())
+
+	var cases = []struct {
+		name      string
+		param     url.Values
+		wantTypes []string
+		wantErr   bool
+	}{
+		{
+			name:      "all events",
+			wantTypes: []string{EventAssign, EventTransferStart, EventAssign, EventScaleUp},
+		},
+		{
+			name:      "type",
+			param:     url.Values{"type": []string{EventAssign}},
+			wantTypes: []string{EventAssign, EventAssign},
+		},
+		{
+			name:      "job",
+			param:     url.Values{"job": []string{"job1"}},
+			wantTypes: []string{EventAssign, EventTransferStart},
+		},
+		{
+			name:      "hash",
+			param:     url.Values{"hash": []string{"2"}},
+			wantTypes: []string{EventAssign},
+		},
+		{
+			name:      "limit",
+			param:     url.Values{"limit": []string{"1"}},
+			wantTypes: []string{EventScaleUp},
+		},
+		{
+			name:    "wrong hash",
+			param:   url.Values{"hash": []string{"a"}},
+			wantErr: true,
+		},
+		{
+			name:    "wrong limit",
+			param:   url.Values{"limit": []string{"-1"}},
+			wantErr: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			uri := "/api/v1/events?" + cs.param.Encode()
+			if cs.wantErr {
+				r, result := api.TestCall(t, a.Engine.ServeHTTP, uri, http.MethodGet, "", nil)
+				r.Equal(api.StatusError, result.Status)
+				return
+			}
+
+			res := make([]*Event, 0)
+			r, _ := api.TestCall(t, a.Engine.ServeHTTP, uri, http.MethodGet, "", &res)
+
+			types := make([]string, 0)
+			for _, e := range res {
+				types = append(types, e.Type)
+			}
+			r.Equal(cs.wantTypes, types)
+		})
+	}
+}
+
+func TestAPI_MoveTarget(t *testing.T) {
+	var (
+		moved  = map[uint64]*MoveTargetRequest{}
+		pinned = map[uint64]string{2: "s1"}
+	)
+
+	a := NewService(ServiceOption{
+		ConfigManager: prom.NewConfigManager(),
+		PromRegistry:  prometheus.NewRegistry(),
+		MoveTarget: func(hash uint64, to string, pin bool) error {
+			if hash == 3 {
+				return fmt.Errorf("target %d is not active", hash)
+			}
+			moved[hash] = &MoveTargetRequest{Shard: to, Pin: pin}
+			return nil
+		},
+		UnpinTarget: func(hash uint64) error {
+			if _, exist := pinned[hash]; !exist {
+				return fmt.Errorf("target %d is not pinned", hash)
+			}
+			delete(pinned, hash)
+			return nil
+		},
+		GetPins: func() map[uint64]string {
+			return pinned
+		},
+	}, logrus.New())
+
+	r, _ := api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/targets/1/move", http.MethodPost, "", nil)
+	r.Equal(&MoveTargetRequest{}, moved[1])
+
+	r, _ = api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/targets/2/move", http.MethodPost,
+		test.MustJSON(&MoveTargetRequest{Shard: "s1", Pin: true}), nil)
+	r.Equal(&MoveTargetRequest{Shard: "s1", Pin: true}, moved[2])
+
+	r, result := api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/targets/3/move", http.MethodPost, "", nil)
+	r.Equal(api.StatusError, result.Status)
+
+	r, result = api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/targets/a/move", http.MethodPost, "", nil)
+	r.Equal(api.StatusError, result.Status)
+
+	pins := map[uint64]string{}
+	r, _ = api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/pins", http.MethodGet, "", &pins)
+	r.Equal("s1", pins[2])
+
+	r, result = api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/targets/2/pin", http.MethodDelete, "", nil)
+	r.Equal(api.StatusSuccess, result.Status)
+	r.Empty(pinned)
+
+	r, result = api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/targets/2/pin", http.MethodDelete, "", nil)
+	r.Equal(api.StatusError, result.Status)
+}
+
+func TestAPI_Cordon(t *testing.T) {
+	cordons := map[string]*ShardCordon{}
+	a := NewService(ServiceOption{
+		ConfigManager: prom.NewConfigManager(),
+		PromRegistry:  prometheus.NewRegistry(),
+		CordonShard: func(id string, drain bool) error {
+			cordons[id] = &ShardCordon{Shard: id, Drain: drain}
+			return nil
+		},
+		UncordonShard: func(id string) error {
+			if cordons[id] == nil {
+				return fmt.Errorf("shard %s is not cordoned", id)
+			}
+			delete(cordons, id)
+			return nil
+		},
+		GetCordons: func() []*ShardCordon {
+			ret := make([]*ShardCordon, 0)
+			for _, cd := range cordons {
+				ret = append(ret, cd)
+			}
+			return ret
+		},
+	}, logrus.New())
+
+	r, _ := api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/shards/s0/cordon", http.MethodPost, "", nil)
+	r.False(cordons["s0"].Drain)
+
+	r, _ = api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/shards/s0/drain", http.MethodPost, "", nil)
+	r.True(cordons["s0"].Drain)
+
+	res := make([]*ShardCordon, 0)
+	r, _ = api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/cordons", http.MethodGet, "", &res)
+	r.Equal(1, len(res))
+	r.Equal("s0", res[0].Shard)
+
+	r, result := api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/shards/s0/uncordon", http.MethodPost, "", nil)
+	r.Equal(api.StatusSuccess, result.Status)
+	r.Empty(cordons)
+
+	r, result = api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/shards/s0/uncordon", http.MethodPost, "", nil)
+	r.Equal(api.StatusError, result.Status)
+}
+
+func TestAPI_NotLeader(t *testing.T) {
+	called := false
+	a := NewService(ServiceOption{
+		ConfigManager: prom.NewConfigManager(),
+		PromRegistry:  prometheus.NewRegistry(),
+		IsLeader:      func() bool { return false },
+		GetLeader:     func() string { return "coordinator-0" },
+		ExportState:   func() *State { return &State{} },
+		ImportState: func(st *State) error {
+			called = true
+			return nil
+		},
+		MoveTarget: func(hash uint64, to string, pin bool) error {
+			called = true
+			return nil
+		},
+		UnpinTarget: func(hash uint64) error {
+			called = true
+			return nil
+		},
+		CordonShard: func(id string, drain bool) error {
+			called = true
+			return nil
+		},
+		UncordonShard: func(id string) error {
+			called = true
+			return nil
+		},
+	}, logrus.New())
+
+	for _, uri := range []string{
+		"/api/v1/state",
+		"/api/v1/targets/1/move",
+		"/api/v1/shards/s0/cordon",
+		"/api/v1/shards/s0/drain",
+		"/api/v1/shards/s0/uncordon",
+	} {
+		r, result := api.TestCall(t, a.Engine.ServeHTTP, uri, http.MethodPost, "{}", nil)
+		r.Equal(api.ErrorNotLeader, result.ErrorType)
+		r.Contains(result.Err, "coordinator-0")
+	}
+
+	r, result := api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/targets/1/pin", http.MethodDelete, "", nil)
+	r.Equal(api.ErrorNotLeader, result.ErrorType)
+	r.False(called)
+
+	r, _ = api.TestCall(t, a.Engine.ServeHTTP, "/api/v1/state", http.MethodGet, "", &State{})
+}
@@ -0,0 +1,206 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"tkestack.io/kvass/pkg/state"
+	"tkestack.io/kvass/pkg/target"
+	"tkestack.io/kvass/pkg/utils/wait"
+)
+
+// State is the snapshot of coordinator
+type State struct {
+	// CreatedAt is the time this snapshot is made
+	CreatedAt time.Time `json:"createdAt"`
+	// GlobalScrapeStatus is the last scraping status of all targets, include shards that scraping them
+	GlobalScrapeStatus map[uint64]*target.ScrapeStatus `json:"globalScrapeStatus"`
+	// ExploreResults is the explored results of targets, results explored before explore TTL are dropped when restoring
+	ExploreResults map[uint64]*target.ScrapeStatus `json:"exploreResults"`
+	// Plan is the decisions made by last coordinating
+	Plan *Plan `json:"plan,omitempty"`
+	// Pins is the pinned targets and the ID of shards they are pinned to
+	Pins map[uint64]string `json:"pins,omitempty"`
+	// Cordons is the cordon status of cordoned shards
+	Cordons []*ShardCordon `json:"cordons,omitempty"`
+}
+
+// StateManager export coordinator state and warm-start coordinator from it
+// state is saved to store periodically if store is not nil
+type StateManager struct {
+	store          state.Store
+	coordinator    *Coordinator
+	exploreResults func() map[uint64]*target.ScrapeStatus
+	exploreRestore func(results map[uint64]*target.ScrapeStatus)
+	interval       time.Duration
+	exploreTTL     time.Duration
+	lg             logrus.FieldLogger
+}
+
+// NewStateManager create a new StateManager
+// explored results in state explored before "exploreTTL" are not restored, 0 means never expire
+func NewStateManager(
+	store state.Store,
+	coordinator *Coordinator,
+	exploreResults func() map[uint64]*target.ScrapeStatus,
+	exploreRestore func(results map[uint64]*target.ScrapeStatus),
+	interval time.Duration,
+	exploreTTL time.Duration,
+	lg logrus.FieldLogger,
+) *StateManager {
+	return &StateManager{
+		store:          store,
+		coordinator:    coordinator,
+		exploreResults: exploreResults,
+		exploreRestore: exploreRestore,
+		interval:       interval,
+		exploreTTL:     exploreTTL,
+		lg:             lg,
+	}
+}
+
+// Export return current state of coordinator
+func (s *StateManager) Export() *State {
+	return &State{
+		CreatedAt:          time.Now(),
+		GlobalScrapeStatus: s.coordinator.LastGlobalScrapeStatus(),
+		ExploreResults:     s.exploreResults(),
+		Plan:               s.coordinator.LastPlan(),
+		Pins:               s.coordinator.Pins(),
+		Cordons:            s.coordinator.Cordons(),
+	}
+}
+
+// Import warm-start coordinator and explore with state "st"
+// global scraping status will be overwritten by the next coordinating
+func (s *StateManager) Import(st *State) error {
+	if st == nil {
+		return errors.New("state is empty")
+	}
+
+	s.coordinator.restoreLast(st.GlobalScrapeStatus, st.Plan)
+
+	if st.Pins != nil {
+		s.coordinator.setPins(st.Pins)
+	}
+
+	if st.Cordons != nil {
+		s.coordinator.setCordons(st.Cordons)
+	}
+
+	s.exploreRestore(s.unexpiredResults(st.ExploreResults))
+	return nil
+}
+
+// unexpiredResults return explored results that are explored in explore TTL
+// every result keeps the time its target is explored, so restored results are not refreshed by saving state
+func (s *StateManager) unexpiredResults(results map[uint64]*target.ScrapeStatus) map[uint64]*target.ScrapeStatus {
+	if s.exploreTTL == 0 {
+		return results
+	}
+
+	ret := map[uint64]*target.ScrapeStatus{}
+	for hash, rt := range results {
+		if time.Since(rt.LastScrape) < s.exploreTTL {
+			ret[hash] = rt
+		}
+	}
+
+	if len(ret) != len(results) {
+		s.lg.Infof("%d explored results are expired, skip restoring them", len(results)-len(ret))
+	}
+	return ret
+}
+
+// Restore load state from store and import it
+func (s *StateManager) Restore() error {
+	if s.store == nil {
+		return nil
+	}
+
+	data, err := s.store.Load()
+	if err != nil {
+		return errors.Wrapf(err, "load state")
+	}
+
+	if data == nil {
+		s.lg.Infof("no state is saved, skip restoring")
+		return nil
+	}
+
+	st := &State{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return errors.Wrapf(err, "wrong format of state")
+	}
+
+	s.lg.Infof("restore state created at %s", st.CreatedAt.String())
+	return s.Import(st)
+}
+
+// Run save state to store periodically until ctx done
+func (s *StateManager) Run(ctx context.Context) error {
+	if s.store == nil {
+		return nil
+	}
+	return wait.RunUntil(ctx, s.lg, s.interval, s.save)
+}
+
+func (s *StateManager) save() error {
+	// only leader save state, followers may share the same store
+	if s.coordinator.isLeader != nil && !s.coordinator.isLeader() {
+		return nil
+	}
+
+	// nothing to save before first coordinating
+	if s.coordinator.LastGlobalScrapeStatus() == nil {
+		return nil
+	}
+
+	st := s.Export()
+	// optional sections are dropped in turn if state is too large for store, pins and cordons are always kept
+	// scraping status and plan are rebuilt by the next coordinating, targets without explored results are explored again
+	shrinks := []func(){
+		func() {
+			st.GlobalScrapeStatus = nil
+			st.Plan = nil
+		},
+		func() {
+			st.ExploreResults = nil
+		},
+	}
+
+	for i := 0; ; i++ {
+		data, err := json.Marshal(st)
+		if err != nil {
+			return errors.Wrapf(err, "marshal state")
+		}
+
+		err = s.store.Save(data)
+		if errors.Cause(err) != state.ErrTooLarge || i == len(shrinks) {
+			return errors.Wrapf(err, "save state")
+		}
+
+		s.lg.Warnf("%s, drop optional sections of state and try again", err.Error())
+		shrinks[i]()
+	}
+}
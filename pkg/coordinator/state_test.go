@@ -0,0 +1,217 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/state"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func TestStateManager_SaveAndRestore(t *testing.T) {
+	r := require.New(t)
+	store := state.NewFileStore(t.TempDir() + "/state.json")
+	results := map[uint64]*target.ScrapeStatus{1: {Series: 10}}
+
+	src := &Coordinator{
+		lastGlobalScrapeStatus: map[uint64]*target.ScrapeStatus{1: {Series: 10, Shards: []string{"s0"}}},
+		lastPlan:               &Plan{DryRun: true},
+		pins:                   map[uint64]string{1: "s0"},
+		cordons:                map[string]*ShardCordon{"s1": {Shard: "s1", Drain: true}},
+	}
+	sm := NewStateManager(store, src, func() map[uint64]*target.ScrapeStatus {
+		return results
+	}, nil, 0, 0, logrus.New())
+	r.NoError(sm.save())
+
+	var restored map[uint64]*target.ScrapeStatus
+	dst := &Coordinator{}
+	sm = NewStateManager(store, dst, nil, func(res map[uint64]*target.ScrapeStatus) {
+		restored = res
+	}, 0, 0, logrus.New())
+	r.NoError(sm.Restore())
+	r.Equal([]string{"s0"}, dst.LastGlobalScrapeStatus()[1].Shards)
+	r.True(dst.LastPlan().DryRun)
+	r.Equal(map[uint64]string{1: "s0"}, dst.Pins())
+	r.True(dst.Cordons()[0].Drain)
+	r.Equal(int64(10), restored[1].Series)
+}
+
+func TestStateManager_Save(t *testing.T) {
+	var cases = []struct {
+		name       string
+		status     map[uint64]*target.ScrapeStatus
+		isLeader   func() bool
+		wantSaving bool
+	}{
+		{
+			name:       "leader",
+			status:     map[uint64]*target.ScrapeStatus{},
+			isLeader:   func() bool { return true },
+			wantSaving: true,
+		},
+		{
+			name:     "follower",
+			status:   map[uint64]*target.ScrapeStatus{},
+			isLeader: func() bool { return false },
+		},
+		{
+			name: "never coordinated",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			store := state.NewFileStore(t.TempDir() + "/state.json")
+			c := &Coordinator{lastGlobalScrapeStatus: cs.status, isLeader: cs.isLeader}
+			sm := NewStateManager(store, c, func() map[uint64]*target.ScrapeStatus {
+				return nil
+			}, nil, 0, 0, logrus.New())
+			r.NoError(sm.save())
+
+			data, err := store.Load()
+			r.NoError(err)
+			r.Equal(cs.wantSaving, data != nil)
+		})
+	}
+}
+
+func TestStateManager_Restore(t *testing.T) {
+	r := require.New(t)
+	sm := NewStateManager(nil, &Coordinator{}, nil, nil, 0, 0, logrus.New())
+	r.NoError(sm.Restore())
+
+	store := state.NewFileStore(t.TempDir() + "/state.json")
+	sm = NewStateManager(store, &Coordinator{}, nil, nil, 0, 0, logrus.New())
+	r.NoError(sm.Restore())
+
+	r.NoError(store.Save([]byte("a")))
+	r.Error(sm.Restore())
+}
+
+func TestStateManager_ExploreTTL(t *testing.T) {
+	var cases = []struct {
+		name        string
+		exploredAt  time.Time
+		ttl         time.Duration
+		wantRestore bool
+	}{
+		{
+			name:        "fresh",
+			exploredAt:  time.Now().Add(-time.Minute),
+			ttl:         time.Hour,
+			wantRestore: true,
+		},
+		{
+			name:       "expired in fresh state",
+			exploredAt: time.Now().Add(-time.Hour * 2),
+			ttl:        time.Hour,
+		},
+		{
+			name:        "never expire",
+			exploredAt:  time.Now().Add(-time.Hour * 2),
+			wantRestore: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			var restored map[uint64]*target.ScrapeStatus
+			c := &Coordinator{}
+			sm := NewStateManager(nil, c, nil, func(res map[uint64]*target.ScrapeStatus) {
+				restored = res
+			}, 0, cs.ttl, logrus.New())
+			r.NoError(sm.Import(&State{
+				CreatedAt: time.Now(),
+				ExploreResults: map[uint64]*target.ScrapeStatus{
+					1: {Series: 10, LastScrape: cs.exploredAt},
+					2: {Series: 10, LastScrape: time.Now()},
+				},
+				Pins: map[uint64]string{1: "s0"},
+			}))
+			r.NotNil(restored[2])
+			r.Equal(cs.wantRestore, restored[1] != nil)
+			r.Equal(map[uint64]string{1: "s0"}, c.Pins())
+		})
+	}
+}
+
+type sizeLimitedStore struct {
+	maxSize int
+	data    []byte
+}
+
+func (s *sizeLimitedStore) Save(data []byte) error {
+	if len(data) > s.maxSize {
+		return errors.Wrapf(state.ErrTooLarge, "size %d", len(data))
+	}
+	s.data = data
+	return nil
+}
+
+func (s *sizeLimitedStore) Load() ([]byte, error) {
+	return s.data, nil
+}
+
+func TestStateManager_SaveTooLarge(t *testing.T) {
+	newState := func(r *require.Assertions, maxSize int) (*State, error) {
+		store := &sizeLimitedStore{maxSize: maxSize}
+		c := &Coordinator{
+			lastGlobalScrapeStatus: map[uint64]*target.ScrapeStatus{1: {Series: 10, Shards: []string{"s0"}}},
+			lastPlan:               &Plan{DryRun: true},
+			pins:                   map[uint64]string{1: "s0"},
+		}
+		sm := NewStateManager(store, c, func() map[uint64]*target.ScrapeStatus {
+			return map[uint64]*target.ScrapeStatus{1: {Series: 10}}
+		}, nil, 0, 0, logrus.New())
+		if err := sm.save(); err != nil {
+			return nil, err
+		}
+
+		st := &State{}
+		r.NoError(json.Unmarshal(store.data, st))
+		return st, nil
+	}
+
+	r := require.New(t)
+	st, err := newState(r, 1024*1024)
+	r.NoError(err)
+	r.NotNil(st.GlobalScrapeStatus)
+	r.NotNil(st.ExploreResults)
+
+	// find the size that only pins and explore results can be saved
+	full, err := json.Marshal(&State{Pins: map[uint64]string{1: "s0"}, ExploreResults: map[uint64]*target.ScrapeStatus{1: {Series: 10}}})
+	r.NoError(err)
+	st, err = newState(r, len(full)+50)
+	r.NoError(err)
+	r.Nil(st.GlobalScrapeStatus)
+	r.Nil(st.Plan)
+	r.NotNil(st.ExploreResults)
+	r.Equal(map[uint64]string{1: "s0"}, st.Pins)
+
+	_, err = newState(r, 10)
+	r.Equal(state.ErrTooLarge, errors.Cause(err))
+}
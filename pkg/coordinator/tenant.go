@@ -0,0 +1,186 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/gin-contrib/pprof"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"tkestack.io/kvass/pkg/api"
+)
+
+var tenantNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// TenantOption indicate an independent Prometheus configuration served by the same coordinator process
+// every tenant has its own config file, service discovery, explore, shards and coordinating limits
+// limits with zero value and rule files with empty value inherit from the global Option
+type TenantOption struct {
+	// Name is the tenant name, APIs of this tenant are served under /tenants/{Name}
+	Name string `yaml:"name" json:"name"`
+	// ConfigFile is the Prometheus config file of this tenant
+	ConfigFile string `yaml:"configFile" json:"configFile"`
+	// ShardSelector is the label selector of shard StatefulSets of this tenant, the global one is used if empty
+	ShardSelector string `yaml:"shardSelector,omitempty" json:"shardSelector,omitempty"`
+	// ShardStaticFile is the static shards config file of this tenant, the global one is used if empty
+	ShardStaticFile string `yaml:"shardStaticFile,omitempty" json:"shardStaticFile,omitempty"`
+	// StateFile is the file that state of this tenant is saved to, state is not persisted if it and StateConfigMap are empty
+	StateFile string `yaml:"stateFile,omitempty" json:"stateFile,omitempty"`
+	// StateConfigMap is the ConfigMap that state of this tenant is saved to [shard.type must be 'k8s']
+	StateConfigMap string `yaml:"stateConfigMap,omitempty" json:"stateConfigMap,omitempty"`
+	// ExploreCacheFile is the file that explored results of this tenant are cached to, cache is disabled if empty
+	ExploreCacheFile string `yaml:"exploreCacheFile,omitempty" json:"exploreCacheFile,omitempty"`
+	// AffinityFile is the affinity rules file of this tenant, the global rules are used if empty
+	AffinityFile string `yaml:"affinityFile,omitempty" json:"affinityFile,omitempty"`
+	// QuotaFile is the quota rules file of this tenant, the global rules are used if empty
+	QuotaFile string `yaml:"quotaFile,omitempty" json:"quotaFile,omitempty"`
+	// PoolsFile is the shard pools file of this tenant, the global pools are used if empty
+	PoolsFile string `yaml:"poolsFile,omitempty" json:"poolsFile,omitempty"`
+	// MaxHeadSeries is max series after metrics_relabels every shard of this tenant can assign
+	MaxHeadSeries int64 `yaml:"maxHeadSeries,omitempty" json:"maxHeadSeries,omitempty"`
+	// MaxProcessSeries is max series before metrics_relabels every shard of this tenant can assign
+	MaxProcessSeries int64 `yaml:"maxProcessSeries,omitempty" json:"maxProcessSeries,omitempty"`
+	// MaxShard is the max number shards of this tenant can scale up to
+	MaxShard int32 `yaml:"maxShard,omitempty" json:"maxShard,omitempty"`
+	// MinShard is the min shard number of this tenant
+	MinShard int32 `yaml:"minShard,omitempty" json:"minShard,omitempty"`
+}
+
+// LoadTenants load tenants from a yaml file
+func LoadTenants(file string) ([]TenantOption, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read tenants file")
+	}
+
+	tenants := make([]TenantOption, 0)
+	if err := yaml.Unmarshal(data, &tenants); err != nil {
+		return nil, errors.Wrapf(err, "wrong format of tenants file")
+	}
+
+	names := map[string]bool{}
+	for _, t := range tenants {
+		if !tenantNameRegexp.MatchString(t.Name) {
+			return nil, errors.Errorf("tenant name %q is invalid, only letters, digits, '_' and '-' are allowed", t.Name)
+		}
+
+		if names[t.Name] {
+			return nil, errors.Errorf("tenant %s is duplicated", t.Name)
+		}
+		names[t.Name] = true
+
+		if t.ConfigFile == "" {
+			return nil, errors.Errorf("config file of tenant %s is empty", t.Name)
+		}
+	}
+	return tenants, nil
+}
+
+// Option return a copy of "global" with the limits and rule files of this tenant
+func (t *TenantOption) Option(global *Option) (*Option, error) {
+	opt := *global
+	if t.AffinityFile != "" {
+		rules, err := LoadAffinityRules(t.AffinityFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", t.Name)
+		}
+		opt.Affinity = rules
+	}
+	if t.QuotaFile != "" {
+		rules, err := LoadQuotaRules(t.QuotaFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", t.Name)
+		}
+		opt.Quotas = rules
+	}
+	if t.PoolsFile != "" {
+		pools, err := LoadPools(t.PoolsFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", t.Name)
+		}
+		opt.Pools = pools
+	}
+	if t.MaxHeadSeries != 0 {
+		opt.MaxHeadSeries = t.MaxHeadSeries
+	}
+	if t.MaxProcessSeries != 0 {
+		opt.MaxProcessSeries = t.MaxProcessSeries
+	}
+	if t.MaxShard != 0 {
+		opt.MaxShard = t.MaxShard
+	}
+	if t.MinShard != 0 {
+		opt.MinShard = t.MinShard
+	}
+	return &opt, nil
+}
+
+// TenantsService serve APIs of all tenants, APIs of tenant "name" are served under /tenants/{name}
+type TenantsService struct {
+	// gin.Engine is the gin engine for handle http request
+	*gin.Engine
+	services map[string]*Service
+}
+
+// NewTenantsService create a TenantsService, "services" is the api server of every tenant
+// process metrics in "promRegistry" are served at /metrics
+func NewTenantsService(services map[string]*Service, promRegistry *prometheus.Registry, lg logrus.FieldLogger) *TenantsService {
+	w := &TenantsService{
+		Engine:   gin.Default(),
+		services: services,
+	}
+
+	pprof.Register(w.Engine)
+
+	h := api.NewHelper(lg, promRegistry, "kvass_coordinator_tenants")
+	w.GET("/metrics", h.MetricsHandler)
+	w.GET("/tenants", h.Wrap(func(ctx *gin.Context) *api.Result {
+		names := make([]string, 0, len(w.services))
+		for name := range w.services {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return api.Data(names)
+	}))
+	w.Any("/tenants/:tenant/*path", w.proxy)
+	return w
+}
+
+// proxy forward request to the api server of tenant
+func (w *TenantsService) proxy(ctx *gin.Context) {
+	svc := w.services[ctx.Param("tenant")]
+	if svc == nil {
+		ctx.JSON(http.StatusNotFound, &api.Result{
+			ErrorType: api.ErrorBadData,
+			Status:    api.StatusError,
+			Err:       "tenant not found",
+		})
+		return
+	}
+
+	ctx.Request.URL.Path = ctx.Param("path")
+	ctx.Request.URL.RawPath = ""
+	svc.ServeHTTP(ctx.Writer, ctx.Request)
+}
@@ -0,0 +1,147 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/api"
+	"tkestack.io/kvass/pkg/prom"
+)
+
+func TestLoadTenants(t *testing.T) {
+	var cases = []struct {
+		name        string
+		content     string
+		wantTenants int
+		wantErr     bool
+	}{
+		{
+			name: "success",
+			content: `
+- name: team-a
+  configFile: /etc/prometheus/a.yaml
+  shardSelector: app.kubernetes.io/name=prometheus-a
+  maxHeadSeries: 100
+- name: team-b
+  configFile: /etc/prometheus/b.yaml
+  maxShard: 3
+`,
+			wantTenants: 2,
+		},
+		{
+			name:    "empty name",
+			content: `- configFile: a.yaml`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid name",
+			content: `- {name: a/b, configFile: a.yaml}`,
+			wantErr: true,
+		},
+		{
+			name: "duplicated name",
+			content: `
+- {name: a, configFile: a.yaml}
+- {name: a, configFile: b.yaml}
+`,
+			wantErr: true,
+		},
+		{
+			name:    "empty config file",
+			content: `- name: a`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong format",
+			content: `a: b`,
+			wantErr: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			file := t.TempDir() + "/tenants.yaml"
+			r.NoError(ioutil.WriteFile(file, []byte(cs.content), 0644))
+
+			tenants, err := LoadTenants(file)
+			if cs.wantErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			r.Equal(cs.wantTenants, len(tenants))
+		})
+	}
+}
+
+func TestTenantOption_Option(t *testing.T) {
+	r := require.New(t)
+	global := &Option{
+		MaxHeadSeries:    100,
+		MaxProcessSeries: 1000,
+		MaxShard:         10,
+		MinShard:         1,
+	}
+
+	tn := &TenantOption{Name: "a", MaxHeadSeries: 50, MinShard: 2}
+	opt, err := tn.Option(global)
+	r.NoError(err)
+	r.Equal(int64(50), opt.MaxHeadSeries)
+	r.Equal(int64(1000), opt.MaxProcessSeries)
+	r.Equal(int32(10), opt.MaxShard)
+	r.Equal(int32(2), opt.MinShard)
+	r.Equal(int64(100), global.MaxHeadSeries)
+}
+
+func TestTenantsService(t *testing.T) {
+	newService := func(scale int32) *Service {
+		return NewService(ServiceOption{
+			ConfigManager: prom.NewConfigManager(),
+			PromRegistry:  prometheus.NewRegistry(),
+			GetPlan: func() *Plan {
+				return &Plan{Replicas: []*ReplicaPlan{{ExpectScale: scale}}}
+			},
+		}, logrus.New())
+	}
+
+	s := NewTenantsService(map[string]*Service{
+		"a": newService(1),
+		"b": newService(2),
+	}, prometheus.NewRegistry(), logrus.New())
+
+	names := make([]string, 0)
+	r, _ := api.TestCall(t, s.ServeHTTP, "/tenants", http.MethodGet, "", &names)
+	r.Equal([]string{"a", "b"}, names)
+
+	res := &Plan{}
+	r, _ = api.TestCall(t, s.ServeHTTP, "/tenants/a/api/v1/plan", http.MethodGet, "", res)
+	r.Equal(int32(1), res.Replicas[0].ExpectScale)
+
+	r, _ = api.TestCall(t, s.ServeHTTP, "/tenants/b/api/v1/plan", http.MethodGet, "", res)
+	r.Equal(int32(2), res.Replicas[0].ExpectScale)
+
+	r, result := api.TestCall(t, s.ServeHTTP, "/tenants/c/api/v1/plan", http.MethodGet, "", nil)
+	r.Equal(api.StatusError, result.Status)
+}
@@ -0,0 +1,94 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+// transferBudget limit targets transfer during one coordinating of a replica
+// targets that can not be transferred because of budget will be tried again in later coordinating
+type transferBudget struct {
+	option       *Option
+	targets      int
+	series       int64
+	shardTargets map[*shardInfo]int
+	shardSeries  map[*shardInfo]int64
+	// backlogTargets is the number of transfers deferred because of budget
+	backlogTargets int
+	// backlogSeries is the series of transfers deferred because of budget
+	backlogSeries int64
+}
+
+func newTransferBudget(option *Option) *transferBudget {
+	b := &transferBudget{option: option}
+	b.reset()
+	return b
+}
+
+// reset clean all used budget, it should be called at the beginning of coordinating of a replica
+func (b *transferBudget) reset() {
+	b.targets = 0
+	b.series = 0
+	b.shardTargets = map[*shardInfo]int{}
+	b.shardSeries = map[*shardInfo]int64{}
+	b.backlogTargets = 0
+	b.backlogSeries = 0
+}
+
+// allow return true if a target with "series" can be transferred from shard "from" to shard "to"
+// limits of shard are applied to both source shard and destination shard
+func (b *transferBudget) allow(from, to *shardInfo, series int64) bool {
+	o := b.option
+	if (o.MaxTransferTargets != 0 && b.targets+1 > o.MaxTransferTargets) ||
+		(o.MaxTransferSeries != 0 && b.series+series > o.MaxTransferSeries) {
+		return false
+	}
+
+	for _, s := range []*shardInfo{from, to} {
+		if (o.MaxShardTransferTargets != 0 && b.shardTargets[s]+1 > o.MaxShardTransferTargets) ||
+			(o.MaxShardTransferSeries != 0 && b.shardSeries[s]+series > o.MaxShardTransferSeries) {
+			return false
+		}
+	}
+	return true
+}
+
+// use record a transfer of target with "series" from shard "from" to shard "to"
+func (b *transferBudget) use(from, to *shardInfo, series int64) {
+	b.targets++
+	b.series += series
+	for _, s := range []*shardInfo{from, to} {
+		b.shardTargets[s]++
+		b.shardSeries[s] += series
+	}
+}
+
+// filter return candidates that a target with "series" can be transferred to from "from" within budget
+// the transfer is recorded as backlog and true is returned if all candidates are limited by budget
+func (b *transferBudget) filter(from *shardInfo, candidates []*shardInfo, series int64) ([]*shardInfo, bool) {
+	ret := make([]*shardInfo, 0, len(candidates))
+	for _, s := range candidates {
+		if b.allow(from, s, series) {
+			ret = append(ret, s)
+		}
+	}
+
+	if len(candidates) != 0 && len(ret) == 0 {
+		b.backlogTargets++
+		b.backlogSeries += series
+		return ret, true
+	}
+	return ret, false
+}
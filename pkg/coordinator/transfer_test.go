@@ -0,0 +1,141 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func TestTransferBudget_Allow(t *testing.T) {
+	var cases = []struct {
+		name   string
+		option *Option
+		series int64
+		want   bool
+	}{
+		{
+			name:   "no limit",
+			option: &Option{},
+			series: 100,
+			want:   true,
+		},
+		{
+			name:   "over max targets",
+			option: &Option{MaxTransferTargets: 1},
+			series: 1,
+			want:   false,
+		},
+		{
+			name:   "over max series",
+			option: &Option{MaxTransferSeries: 15},
+			series: 10,
+			want:   false,
+		},
+		{
+			name:   "over max shard targets",
+			option: &Option{MaxShardTransferTargets: 1},
+			series: 1,
+			want:   false,
+		},
+		{
+			name:   "over max shard series",
+			option: &Option{MaxShardTransferSeries: 15},
+			series: 10,
+			want:   false,
+		},
+		{
+			name:   "within limits",
+			option: &Option{MaxTransferTargets: 2, MaxTransferSeries: 20, MaxShardTransferTargets: 2, MaxShardTransferSeries: 20},
+			series: 10,
+			want:   true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			s0 := newTestingShardInfo("0", 0)
+			s1 := newTestingShardInfo("1", 0)
+			b := newTransferBudget(cs.option)
+			r.True(b.allow(s0, s1, cs.series))
+			b.use(s0, s1, cs.series)
+			r.Equal(cs.want, b.allow(s0, s1, cs.series))
+
+			b.reset()
+			r.True(b.allow(s0, s1, cs.series))
+		})
+	}
+}
+
+func TestTransferBudget_Filter(t *testing.T) {
+	r := require.New(t)
+	s0 := newTestingShardInfo("0", 0)
+	s1 := newTestingShardInfo("1", 0)
+	s2 := newTestingShardInfo("2", 0)
+	b := newTransferBudget(&Option{MaxShardTransferTargets: 1})
+
+	ret, limited := b.filter(s0, nil, 10)
+	r.Empty(ret)
+	r.False(limited)
+
+	b.use(s2, s1, 10)
+	ret, limited = b.filter(s0, []*shardInfo{s1, s2}, 10)
+	r.Empty(ret)
+	r.True(limited)
+	r.Equal(1, b.backlogTargets)
+	r.Equal(int64(10), b.backlogSeries)
+
+	b.reset()
+	ret, limited = b.filter(s0, []*shardInfo{s1, s2}, 10)
+	r.Equal([]*shardInfo{s1, s2}, ret)
+	r.False(limited)
+}
+
+func TestCoordinator_AlleviateWithBudget(t *testing.T) {
+	r := require.New(t)
+	option := &Option{MaxTransferTargets: 1}
+	c := &Coordinator{
+		option:    option,
+		scheduler: &firstFitScheduler{},
+		affinity:  newAffinity(nil),
+		budget:    newTransferBudget(option),
+		metrics:   newMetrics(),
+		log:       logrus.New(),
+	}
+
+	s0 := newTestingShardInfo("0", 200)
+	s1 := newTestingShardInfo("1", 0)
+	s1.maxHeadSeries = 1000
+	s0.scraping = map[uint64]*target.ScrapeStatus{}
+	s1.scraping = map[uint64]*target.ScrapeStatus{}
+	for i := uint64(0); i < 4; i++ {
+		s0.scraping[i] = &target.ScrapeStatus{Series: 50, Health: scrape.HealthGood, ScrapeTimes: minWaitScrapeTimes}
+	}
+
+	// only one target is transferred, others are deferred and no more space is needed
+	needSpace := c.alleviateShardHeadSeries(s0, []*shardInfo{s0, s1}, 0)
+	r.Equal(int64(0), needSpace)
+	r.Equal(1, len(s1.scraping))
+	r.Equal(3, c.budget.backlogTargets)
+	r.Equal(int64(150), c.budget.backlogSeries)
+}
@@ -0,0 +1,148 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"tkestack.io/kvass/pkg/utils/wait"
+)
+
+// Trigger request a coordinating as soon as possible, "reason" is only used for logging
+// triggers are debounced, and coordinating is never triggered within MinInterval since last one
+// it does nothing if EventTrigger is not enabled
+func (c *Coordinator) Trigger(reason string) {
+	if !c.option.EventTrigger {
+		return
+	}
+
+	select {
+	case c.trigger <- reason:
+	default:
+	}
+}
+
+// Run do coordinate periodically until ctx done, coordinating is also triggered by Trigger
+func (c *Coordinator) Run(ctx context.Context) error {
+	if c.option.EventTrigger && c.option.ShardWatchInterval != 0 {
+		go c.watchShards(ctx)
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		case reason := <-c.trigger:
+			c.log.Infof("coordinating is triggered: %s", reason)
+			if !c.debounce(ctx) {
+				return nil
+			}
+		}
+
+		if err := c.runOnce(); err != nil {
+			c.log.Errorf(err.Error())
+		}
+		c.lastRunAt = time.Now()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(c.option.Period)
+	}
+}
+
+// debounce wait until no more trigger in TriggerDebounce and MinInterval since last coordinating is passed
+// it never waits longer than Period, false is returned if ctx done
+func (c *Coordinator) debounce(ctx context.Context) bool {
+	var (
+		start    = time.Now()
+		deadline = c.lastRunAt.Add(c.option.MinInterval)
+	)
+
+	for {
+		d := c.option.TriggerDebounce
+		if rest := time.Until(deadline); rest > d {
+			d = rest
+		}
+
+		if c.option.Period != 0 && time.Since(start)+d > c.option.Period {
+			d = c.option.Period - time.Since(start)
+		}
+
+		if d <= 0 {
+			return true
+		}
+
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return false
+		case <-c.trigger:
+			t.Stop()
+		case <-t.C:
+			return true
+		}
+	}
+}
+
+// watchShards trigger coordinating when shards are added, deleted or their readiness changed
+func (c *Coordinator) watchShards(ctx context.Context) {
+	var last map[string]bool
+	_ = wait.RunUntil(ctx, c.log, c.option.ShardWatchInterval, func() error {
+		cur, err := c.shardsReadiness()
+		if err != nil {
+			return err
+		}
+
+		if last != nil && !reflect.DeepEqual(last, cur) {
+			c.Trigger("shards changed")
+		}
+		last = cur
+		return nil
+	})
+}
+
+// shardsReadiness return the readiness of all shards, key is shard ID
+func (c *Coordinator) shardsReadiness() (map[string]bool, error) {
+	replicas, err := c.reManager.Replicas()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string]bool{}
+	for _, rep := range replicas {
+		shards, err := rep.Shards()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range shards {
+			ret[s.ID] = s.Ready
+		}
+	}
+	return ret, nil
+}
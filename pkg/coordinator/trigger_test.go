@@ -0,0 +1,153 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/prom"
+	"tkestack.io/kvass/pkg/shard"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func newTestingTriggerCoordinator(option *Option, sm *fakeShardsManager) *Coordinator {
+	return NewCoordinator(option,
+		&fakeReplicasManager{sm},
+		func() *prom.ConfigInfo {
+			return prom.DefaultConfig
+		},
+		func(hash uint64) *target.ScrapeStatus {
+			return nil
+		},
+		func() map[uint64]*discovery.SDTargets {
+			return map[uint64]*discovery.SDTargets{}
+		},
+		nil,
+		prometheus.NewRegistry(),
+		logrus.New(),
+	)
+}
+
+func TestCoordinator_Trigger(t *testing.T) {
+	r := require.New(t)
+	c := newTestingTriggerCoordinator(&Option{
+		MaxProcessSeries: 1000,
+		MaxShard:         10,
+		Period:           time.Hour,
+		EventTrigger:     true,
+		TriggerDebounce:  time.Millisecond * 10,
+	}, &fakeShardsManager{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		r.NoError(c.Run(ctx))
+	}()
+
+	// the first coordinating is done immediately
+	r.Eventually(func() bool { return c.LastPlan() != nil }, time.Second, time.Millisecond*10)
+	first := c.LastPlan()
+
+	c.Trigger("test")
+	r.Eventually(func() bool { return c.LastPlan() != first }, time.Second, time.Millisecond*10)
+}
+
+func TestCoordinator_TriggerDisabled(t *testing.T) {
+	c := newTestingTriggerCoordinator(&Option{MaxProcessSeries: 1000, MaxShard: 10}, &fakeShardsManager{})
+	c.Trigger("test")
+	require.Equal(t, 0, len(c.trigger))
+}
+
+func TestCoordinator_Debounce(t *testing.T) {
+	var cases = []struct {
+		name      string
+		option    *Option
+		lastRunAt time.Time
+		triggers  int
+		wantMin   time.Duration
+		wantMax   time.Duration
+	}{
+		{
+			name:    "debounce",
+			option:  &Option{TriggerDebounce: time.Millisecond * 50},
+			wantMin: time.Millisecond * 50,
+			wantMax: time.Millisecond * 500,
+		},
+		{
+			name:      "wait for min interval",
+			option:    &Option{TriggerDebounce: time.Millisecond * 10, MinInterval: time.Millisecond * 200},
+			lastRunAt: time.Now(),
+			wantMin:   time.Millisecond * 100,
+			wantMax:   time.Millisecond * 800,
+		},
+		{
+			name:     "more triggers extend waiting",
+			option:   &Option{EventTrigger: true, TriggerDebounce: time.Millisecond * 50},
+			triggers: 1,
+			wantMin:  time.Millisecond * 50,
+			wantMax:  time.Millisecond * 800,
+		},
+		{
+			name:    "never longer than period",
+			option:  &Option{TriggerDebounce: time.Hour, Period: time.Millisecond * 50},
+			wantMin: time.Millisecond * 40,
+			wantMax: time.Millisecond * 500,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			c := newTestingTriggerCoordinator(cs.option, &fakeShardsManager{})
+			c.lastRunAt = cs.lastRunAt
+			for i := 0; i < cs.triggers; i++ {
+				c.Trigger("test")
+			}
+
+			start := time.Now()
+			r.True(c.debounce(context.Background()))
+			cost := time.Since(start)
+			r.True(cost >= cs.wantMin, cost.String())
+			r.True(cost <= cs.wantMax, cost.String())
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := newTestingTriggerCoordinator(&Option{TriggerDebounce: time.Hour}, &fakeShardsManager{})
+	require.False(t, c.debounce(ctx))
+}
+
+func TestCoordinator_ShardsReadiness(t *testing.T) {
+	r := require.New(t)
+	sm := &fakeShardsManager{
+		shards: []*testingShard{
+			{rtInfo: &shard.RuntimeInfo{}},
+		},
+	}
+	c := newTestingTriggerCoordinator(&Option{}, sm)
+	ret, err := c.shardsReadiness()
+	r.NoError(err)
+	r.Equal(map[string]bool{"0-r0": true}, ret)
+}
@@ -17,6 +17,8 @@
 
 package coordinator
 
+import "fmt"
+
 // SamplesInfo contains statistic of sample scraped rate
 type SamplesInfo struct {
 	// SamplesRate is total sample rate in last scrape
@@ -38,13 +40,27 @@ type JobSamplesInfo struct {
 type space struct {
 	headSpace    int64
 	processSpace int64
+	// samplesRate is the ingested samples per second, which is head series divided by scrape interval
+	samplesRate float64
+	// targets is the number of targets
+	targets int64
+	// scrapeDuration is the cumulative seconds of last scraping of targets
+	scrapeDuration float64
 }
 
 func (s *space) add(src space) {
 	s.headSpace += src.headSpace
 	s.processSpace += src.processSpace
+	s.samplesRate += src.samplesRate
+	s.targets += src.targets
+	s.scrapeDuration += src.scrapeDuration
 }
 
 func (s *space) isZero() bool {
-	return s.headSpace == 0 && s.processSpace == 0
+	return s.headSpace == 0 && s.processSpace == 0 && s.samplesRate == 0 && s.targets == 0 && s.scrapeDuration == 0
+}
+
+func (s *space) String() string {
+	return fmt.Sprintf("head series = %d, process series = %d, samples rate = %.2f, targets = %d, scrape duration = %.2fs",
+		s.headSpace, s.processSpace, s.samplesRate, s.targets, s.scrapeDuration)
 }
@@ -0,0 +1,61 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package election
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	leaderGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kvass_coordinator_leader",
+		Help: "1 if this coordinator is the leader, otherwise 0",
+	}, []string{})
+)
+
+// Elector decide which one of several coordinators is the leader
+type Elector interface {
+	// Run campaign for leadership until ctx done
+	Run(ctx context.Context) error
+	// IsLeader return true if this coordinator is the leader now
+	IsLeader() bool
+	// Leader return the identity of current leader, return empty string if it is unknown
+	Leader() string
+}
+
+// leaderState record whether the elector is the leader now
+type leaderState struct {
+	leader int32
+}
+
+func (l *leaderState) set(leader bool) {
+	v := int32(0)
+	if leader {
+		v = 1
+	}
+	atomic.StoreInt32(&l.leader, v)
+	leaderGauge.WithLabelValues().Set(float64(v))
+}
+
+// IsLeader return true if this coordinator is the leader now
+func (l *leaderState) IsLeader() bool {
+	return atomic.LoadInt32(&l.leader) == 1
+}
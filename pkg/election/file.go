@@ -0,0 +1,121 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package election
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// FileElector elect leader using an exclusive flock of a file
+// the coordinator holding the lock is the leader, the lock is released automatically if the leader exits
+// the file should be on a volume shared by all coordinators
+type FileElector struct {
+	leaderState
+	path        string
+	identity    string
+	retryPeriod time.Duration
+	lg          logrus.FieldLogger
+}
+
+// NewFileElector create a FileElector that use file "path" as the lock
+func NewFileElector(
+	path string,
+	identity string,
+	retryPeriod time.Duration,
+	promRegisterer prometheus.Registerer,
+	lg logrus.FieldLogger,
+) *FileElector {
+	_ = promRegisterer.Register(leaderGauge)
+	return &FileElector{
+		path:        path,
+		identity:    identity,
+		retryPeriod: retryPeriod,
+		lg:          lg,
+	}
+}
+
+// Run campaign for leadership until ctx done
+func (f *FileElector) Run(ctx context.Context) error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "open lock file")
+	}
+	defer file.Close()
+
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+
+		if err != syscall.EWOULDBLOCK {
+			return errors.Wrapf(err, "lock file")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(f.retryPeriod):
+		}
+	}
+
+	f.lg.Infof("%s become leader", f.identity)
+	// record the holder, followers read it to find out the leader
+	if err := file.Truncate(0); err == nil {
+		_, _ = file.WriteAt([]byte(f.identity), 0)
+	}
+	f.set(true)
+
+	<-ctx.Done()
+	f.set(false)
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
+
+// Leader return the identity recorded in the lock file by current leader
+// empty string is returned if no one holds the lock, e.g. the leader exited and the file still records it
+func (f *FileElector) Leader() string {
+	if f.IsLeader() {
+		return f.identity
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	// a shared lock can be got only if no one holds the exclusive lock
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err == nil {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		return ""
+	}
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,73 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package election
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileElector_Run(t *testing.T) {
+	r := require.New(t)
+	path := t.TempDir() + "/lock"
+	e1 := NewFileElector(path, "e1", time.Millisecond*10, prometheus.NewRegistry(), logrus.New())
+	e2 := NewFileElector(path, "e2", time.Millisecond*10, prometheus.NewRegistry(), logrus.New())
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	done1 := make(chan error)
+	go func() { done1 <- e1.Run(ctx1) }()
+	r.Eventually(e1.IsLeader, time.Second, time.Millisecond*10)
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go func() { _ = e2.Run(ctx2) }()
+	time.Sleep(time.Millisecond * 50)
+	r.False(e2.IsLeader())
+	r.Equal("e1", e2.Leader())
+
+	// e2 become leader after e1 exit
+	cancel1()
+	r.NoError(<-done1)
+	r.False(e1.IsLeader())
+	r.Eventually(e2.IsLeader, time.Second, time.Millisecond*10)
+	r.Equal("e2", e1.Leader())
+}
+
+func TestFileElector_LeaderExited(t *testing.T) {
+	r := require.New(t)
+	path := t.TempDir() + "/lock"
+	e1 := NewFileElector(path, "e1", time.Millisecond*10, prometheus.NewRegistry(), logrus.New())
+	e2 := NewFileElector(path, "e2", time.Millisecond*10, prometheus.NewRegistry(), logrus.New())
+	r.Equal("", e2.Leader())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e1.Run(ctx) }()
+	r.Eventually(e1.IsLeader, time.Second, time.Millisecond*10)
+	r.Equal("e1", e2.Leader())
+
+	// identity is still in lock file, but no one holds the lock
+	cancel()
+	r.NoError(<-done)
+	r.Equal("", e2.Leader())
+}
@@ -0,0 +1,116 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package election
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaseOption contains timing arguments of kubernetes Lease election
+type LeaseOption struct {
+	// LeaseDuration is the duration that followers will wait to force acquire leadership
+	LeaseDuration time.Duration
+	// RenewDeadline is the duration that the leader will retry refreshing leadership before giving up
+	RenewDeadline time.Duration
+	// RetryPeriod is the duration between every acquiring or renewing
+	RetryPeriod time.Duration
+}
+
+// LeaseElector elect leader using kubernetes Lease
+type LeaseElector struct {
+	leaderState
+	elector *leaderelection.LeaderElector
+	lg      logrus.FieldLogger
+}
+
+// NewLeaseElector create a LeaseElector that use Lease "namespace/name" as the lock
+func NewLeaseElector(
+	cli kubernetes.Interface,
+	namespace string,
+	name string,
+	identity string,
+	option *LeaseOption,
+	promRegisterer prometheus.Registerer,
+	lg logrus.FieldLogger,
+) (*LeaseElector, error) {
+	_ = promRegisterer.Register(leaderGauge)
+	l := &LeaseElector{lg: lg}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Client: cli.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   option.LeaseDuration,
+		RenewDeadline:   option.RenewDeadline,
+		RetryPeriod:     option.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				lg.Infof("%s become leader", identity)
+				l.set(true)
+			},
+			OnStoppedLeading: func() {
+				lg.Warnf("%s lost leadership", identity)
+				l.set(false)
+			},
+			OnNewLeader: func(id string) {
+				lg.Infof("current leader is %s", id)
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "create leader elector")
+	}
+
+	l.elector = elector
+	return l, nil
+}
+
+// Run campaign for leadership until ctx done
+// elector will campaign again after leadership is lost
+func (l *LeaseElector) Run(ctx context.Context) error {
+	for {
+		l.elector.Run(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// Leader return the identity of current leader observed from the Lease
+func (l *LeaseElector) Leader() string {
+	return l.elector.GetLeader()
+}
@@ -0,0 +1,67 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package election
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaseElector_Run(t *testing.T) {
+	r := require.New(t)
+	cli := fake.NewSimpleClientset()
+	option := &LeaseOption{
+		LeaseDuration: time.Millisecond * 500,
+		RenewDeadline: time.Millisecond * 300,
+		RetryPeriod:   time.Millisecond * 50,
+	}
+
+	e1, err := NewLeaseElector(cli, "default", "kvass", "e1", option, prometheus.NewRegistry(), logrus.New())
+	r.NoError(err)
+	e2, err := NewLeaseElector(cli, "default", "kvass", "e2", option, prometheus.NewRegistry(), logrus.New())
+	r.NoError(err)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	done1 := make(chan error)
+	go func() { done1 <- e1.Run(ctx1) }()
+	r.Eventually(e1.IsLeader, time.Second*2, time.Millisecond*10)
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go func() { _ = e2.Run(ctx2) }()
+	time.Sleep(time.Millisecond * 200)
+	r.False(e2.IsLeader())
+
+	// lease is released when e1 exit
+	cancel1()
+	r.NoError(<-done1)
+	r.False(e1.IsLeader())
+	r.Eventually(e2.IsLeader, time.Second*2, time.Millisecond*10)
+}
+
+func TestNewLeaseElector(t *testing.T) {
+	_, err := NewLeaseElector(fake.NewSimpleClientset(), "default", "kvass", "e1", &LeaseOption{},
+		prometheus.NewRegistry(), logrus.New())
+	require.Error(t, err)
+}
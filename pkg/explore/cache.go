@@ -0,0 +1,135 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package explore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	scrape2 "github.com/prometheus/prometheus/scrape"
+	"github.com/sirupsen/logrus"
+	"tkestack.io/kvass/pkg/state"
+	"tkestack.io/kvass/pkg/target"
+	"tkestack.io/kvass/pkg/utils/wait"
+)
+
+// CacheEntry is the cached explored result of a target
+type CacheEntry struct {
+	// Series is the series of target after metrics_relabel_configs
+	Series int64 `json:"series"`
+	// TotalSeries is the series of target before metrics_relabel_configs
+	TotalSeries int64 `json:"totalSeries"`
+	// Health is the health of target when it is explored
+	Health scrape2.TargetHealth `json:"health"`
+	// ExploredAt is the time target is explored
+	ExploredAt time.Time `json:"exploredAt"`
+}
+
+// Cache persist explored results of targets to store and load them when coordinator start
+// so that known targets can be assigned without exploring, cached targets are explored again in the background
+type Cache struct {
+	store    state.Store
+	explore  *Explore
+	ttl      time.Duration
+	interval time.Duration
+	lg       logrus.FieldLogger
+}
+
+// NewCache create a new Cache, entries explored before "ttl" are dropped, "ttl" 0 means never expire
+// entries are saved to store every "interval"
+func NewCache(store state.Store, explore *Explore, ttl, interval time.Duration, lg logrus.FieldLogger) *Cache {
+	return &Cache{
+		store:    store,
+		explore:  explore,
+		ttl:      ttl,
+		interval: interval,
+		lg:       lg,
+	}
+}
+
+// Load load cached entries from store and use them as explored results of targets
+func (c *Cache) Load() error {
+	data, err := c.store.Load()
+	if err != nil {
+		return errors.Wrapf(err, "load explore cache")
+	}
+
+	if data == nil {
+		c.lg.Infof("no explore cache is saved, skip loading")
+		return nil
+	}
+
+	entries := map[uint64]*CacheEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return errors.Wrapf(err, "wrong format of explore cache")
+	}
+
+	results := map[uint64]*target.ScrapeStatus{}
+	for hash, en := range entries {
+		rt := target.NewScrapeStatus(en.Series, en.TotalSeries)
+		rt.Health = en.Health
+		rt.LastScrape = en.ExploredAt
+		results[hash] = rt
+	}
+
+	results = unexpired(results, c.ttl)
+	c.lg.Infof("load %d explore cache entries", len(results))
+	c.explore.Restore(results)
+	return nil
+}
+
+// Run save cached entries to store periodically until ctx done
+func (c *Cache) Run(ctx context.Context) error {
+	return wait.RunUntil(ctx, c.lg, c.interval, c.save)
+}
+
+func (c *Cache) save() error {
+	entries := map[uint64]*CacheEntry{}
+	for hash, rt := range unexpired(c.explore.Results(), c.ttl) {
+		entries[hash] = &CacheEntry{
+			Series:      rt.Series,
+			TotalSeries: rt.TotalSeries,
+			Health:      rt.Health,
+			ExploredAt:  rt.LastScrape,
+		}
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return errors.Wrapf(err, "marshal explore cache")
+	}
+	return errors.Wrapf(c.store.Save(data), "save explore cache")
+}
+
+// unexpired return results explored in "ttl", results are never expired if "ttl" is 0
+// restored results keep the time they are explored, so a result is not refreshed until target is explored again
+func unexpired(results map[uint64]*target.ScrapeStatus, ttl time.Duration) map[uint64]*target.ScrapeStatus {
+	if ttl == 0 {
+		return results
+	}
+
+	ret := map[uint64]*target.ScrapeStatus{}
+	for hash, rt := range results {
+		if time.Since(rt.LastScrape) < ttl {
+			ret[hash] = rt
+		}
+	}
+	return ret
+}
@@ -0,0 +1,125 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package explore
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	scrape2 "github.com/prometheus/prometheus/scrape"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/discovery"
+	"tkestack.io/kvass/pkg/scrape"
+	"tkestack.io/kvass/pkg/state"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func loadCacheEntries(r *require.Assertions, store state.Store) map[uint64]*CacheEntry {
+	data, err := store.Load()
+	r.NoError(err)
+	entries := map[uint64]*CacheEntry{}
+	r.NoError(json.Unmarshal(data, &entries))
+	return entries
+}
+
+func TestCache_Load(t *testing.T) {
+	r := require.New(t)
+	store := state.NewFileStore(t.TempDir() + "/cache.json")
+	data, err := json.Marshal(map[uint64]*CacheEntry{
+		1: {Series: 10, TotalSeries: 20, Health: scrape2.HealthGood, ExploredAt: time.Now()},
+		2: {Series: 10, Health: scrape2.HealthGood, ExploredAt: time.Now().Add(-time.Hour * 2)},
+		3: {Series: 30, Health: scrape2.HealthGood, ExploredAt: time.Now()},
+	})
+	r.NoError(err)
+	r.NoError(store.Save(data))
+
+	e := New(scrape.New(true, logrus.New()), prometheus.NewRegistry(), logrus.New())
+	e.UpdateTargets(map[string][]*discovery.SDTargets{
+		"job1": {{ShardTarget: &target.Target{Hash: 1}}, {ShardTarget: &target.Target{Hash: 2}}},
+	})
+	c := NewCache(store, e, time.Hour, time.Minute, logrus.New())
+	r.NoError(c.Load())
+
+	// cached target can be used immediately, and is revalidated with the lowest priority
+	res := e.Get(1)
+	r.Equal(int64(10), res.Series)
+	r.Equal(int64(20), e.targets[1].target.TotalSeries)
+	r.Equal(scrape2.HealthGood, res.Health)
+	r.Equal(priorityRevalidate, e.queue.items[1].priority)
+
+	// expired entry is dropped
+	r.Equal(scrape2.HealthUnknown, e.Get(2).Health)
+	r.Equal(priorityNew, e.queue.items[2].priority)
+
+	// new target use cache when it is added
+	e.UpdateTargets(map[string][]*discovery.SDTargets{
+		"job1": {{ShardTarget: &target.Target{Hash: 1}}, {ShardTarget: &target.Target{Hash: 3}}},
+	})
+	r.Equal(int64(30), e.Get(3).Series)
+}
+
+func TestCache_Save(t *testing.T) {
+	r := require.New(t)
+	store := state.NewFileStore(t.TempDir() + "/cache.json")
+	e := New(scrape.New(true, logrus.New()), prometheus.NewRegistry(), logrus.New())
+	e.UpdateTargets(map[string][]*discovery.SDTargets{
+		"job1": {{ShardTarget: &target.Target{Hash: 1}}, {ShardTarget: &target.Target{Hash: 2}}, {ShardTarget: &target.Target{Hash: 3}}},
+	})
+	e.targets[1].rt.Series = 10
+	e.targets[1].rt.SetScrapeErr(time.Now(), nil)
+	e.targets[2].rt.SetScrapeErr(time.Now(), fmt.Errorf("test"))
+	e.targets[3].rt.SetScrapeErr(time.Now().Add(-time.Hour*2), nil)
+
+	c := NewCache(store, e, time.Hour, time.Minute, logrus.New())
+	r.NoError(c.save())
+
+	entries := loadCacheEntries(r, store)
+	r.Equal(1, len(entries))
+	r.Equal(int64(10), entries[1].Series)
+	r.Equal(scrape2.HealthGood, entries[1].Health)
+}
+
+func TestCache_ExpireByExploredTime(t *testing.T) {
+	r := require.New(t)
+	store := state.NewFileStore(t.TempDir() + "/cache.json")
+	exploredAt := time.Now().Add(-time.Minute * 50)
+	data, err := json.Marshal(map[uint64]*CacheEntry{
+		1: {Series: 10, Health: scrape2.HealthGood, ExploredAt: exploredAt},
+	})
+	r.NoError(err)
+	r.NoError(store.Save(data))
+
+	e := New(scrape.New(true, logrus.New()), prometheus.NewRegistry(), logrus.New())
+	e.UpdateTargets(map[string][]*discovery.SDTargets{"job1": {{ShardTarget: &target.Target{Hash: 1}}}})
+	c := NewCache(store, e, time.Hour, time.Minute, logrus.New())
+	r.NoError(c.Load())
+
+	// saving a restored entry does not refresh it
+	r.NoError(c.save())
+	entries := loadCacheEntries(r, store)
+	r.True(entries[1].ExploredAt.Equal(exploredAt))
+
+	// entry expires by the time it is explored
+	c.ttl = time.Minute * 30
+	r.NoError(c.save())
+	r.Empty(loadCacheEntries(r, store))
+}
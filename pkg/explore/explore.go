@@ -29,31 +29,29 @@ import (
 	"sync"
 	"time"
 
-	parser "github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/prometheus"
-
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	scrape2 "github.com/prometheus/prometheus/scrape"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 	"tkestack.io/kvass/pkg/target"
 )
 
-var (
-	exploredTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "kvass_explore_explored_total",
-	}, []string{"job", "success"})
-	exploringTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "kvass_explore_exploring_total",
-	}, []string{"job"})
-)
-
 type exploringTarget struct {
 	exploring bool
 	job       string
 	target    *target.Target
 	rt        *target.ScrapeStatus
+	// failures is the number of continuous explore failures
+	failures int
+	// restored is true if explored result is restored from snapshot and not explored again yet
+	restored bool
 }
 
+// Delegate explore target somewhere else instead of local, e.g. sidecars
+// handled is false if target is not explored by delegate, then it is explored locally
+type Delegate func(job string, t *target.Target) (result *scrape.StatisticsSeriesResult, handled bool, err error)
+
 // Explore will explore Target before it assigned to Shard
 type Explore struct {
 	logger        logrus.FieldLogger
@@ -61,24 +59,52 @@ type Explore struct {
 
 	targets     map[uint64]*exploringTarget
 	targetsLock sync.Mutex
-
-	retryInterval time.Duration
-	needExplore   chan *exploringTarget
-	explore       func(log logrus.FieldLogger, scrapeInfo *scrape.JobInfo, url string) (*scrape.StatisticsSeriesResult, error)
+	// restored contains explored results restored from snapshot, used when target is added
+	restored map[uint64]*target.ScrapeStatus
+
+	// exploredJobs contains jobs that have at least one target explored successfully
+	exploredJobs map[string]bool
+	// retryInterval is the backoff of the first retry, it is doubled after every failure until maxRetryInterval
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+	queue            *exploreQueue
+	// exploredCallbacks is called after target is explored successfully for the first time
+	// or its series changed more than changeRatio
+	exploredCallbacks []func(hash uint64)
+	changeRatio       float64
+	delegate          Delegate
+	explore           func(log logrus.FieldLogger, scrapeInfo *scrape.JobInfo, url string) (*scrape.StatisticsSeriesResult, error)
+
+	exploredTotal  *prometheus.CounterVec
+	exploringTotal *prometheus.GaugeVec
 }
 
 // New create a new Explore
 func New(scrapeManager *scrape.Manager, promRegistry prometheus.Registerer, log logrus.FieldLogger) *Explore {
-	_ = promRegistry.Register(exploredTotal)
-	_ = promRegistry.Register(exploringTotal)
-	return &Explore{
-		logger:        log,
-		scrapeManager: scrapeManager,
-		needExplore:   make(chan *exploringTarget, 10000),
-		retryInterval: time.Second * 5,
-		targets:       map[uint64]*exploringTarget{},
-		explore:       explore,
+	e := &Explore{
+		logger:           log,
+		scrapeManager:    scrapeManager,
+		queue:            newExploreQueue(),
+		retryInterval:    time.Second * 5,
+		maxRetryInterval: time.Minute * 5,
+		targets:          map[uint64]*exploringTarget{},
+		restored:         map[uint64]*target.ScrapeStatus{},
+		exploredJobs:     map[string]bool{},
+		explore:          scrape.Statistics,
+		changeRatio:      0.2,
+		exploredTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kvass_explore_explored_total",
+		}, []string{"job", "success"}),
+		exploringTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_explore_exploring_total",
+		}, []string{"job"}),
 	}
+
+	_ = promRegistry.Register(e.exploredTotal)
+	_ = promRegistry.Register(e.exploringTotal)
+	_ = promRegistry.Register(e.queue.queueDepth)
+	_ = promRegistry.Register(e.queue.backoffTargets)
+	return e
 }
 
 // Get return the target scrape status of the target by hash
@@ -94,12 +120,31 @@ func (e *Explore) Get(hash uint64) *target.ScrapeStatus {
 
 	if !r.exploring {
 		r.exploring = true
-		e.needExplore <- r
+		priority := priorityNew
+		if r.restored {
+			priority = priorityRevalidate
+		}
+		e.queue.push(r, priority, 0)
 	}
 
 	return r.rt
 }
 
+// SetDelegate make targets explored by "d" first, targets not handled by "d" are explored locally
+func (e *Explore) SetDelegate(d Delegate) {
+	e.delegate = d
+}
+
+// AddExploredCallbacks add callbacks of target explored successfully for the first time or its series changed
+func (e *Explore) AddExploredCallbacks(f ...func(hash uint64)) {
+	e.exploredCallbacks = append(e.exploredCallbacks, f...)
+}
+
+// SetChangeRatio set the min ratio of series change of an explored target that calls explored callbacks
+func (e *Explore) SetChangeRatio(ratio float64) {
+	e.changeRatio = ratio
+}
+
 // ApplyConfig delete invalid job's targets according to config
 // the new targets will be add by UpdateTargets
 func (e *Explore) ApplyConfig(cfg *prom.ConfigInfo) error {
@@ -118,13 +163,15 @@ func (e *Explore) ApplyConfig(cfg *prom.ConfigInfo) error {
 			newTargets[hash] = v
 		} else {
 			deletedJobs[v.job] = struct{}{}
+			e.queue.remove(hash)
 		}
 	}
 
 	for job := range deletedJobs {
-		exploredTotal.DeleteLabelValues(job, "true")
-		exploredTotal.DeleteLabelValues(job, "false")
-		exploringTotal.DeleteLabelValues(job)
+		delete(e.exploredJobs, job)
+		e.exploredTotal.DeleteLabelValues(job, "true")
+		e.exploredTotal.DeleteLabelValues(job, "false")
+		e.exploringTotal.DeleteLabelValues(job)
 	}
 
 	e.targets = newTargets
@@ -148,39 +195,98 @@ func (e *Explore) UpdateTargets(targets map[string][]*discovery.SDTargets) {
 					rt:     target.NewScrapeStatus(0, 0),
 					target: t.ShardTarget,
 				}
+				e.tryRestore(all[hash])
 			}
 		}
 	}
+
+	for hash := range e.targets {
+		if all[hash] == nil {
+			e.queue.remove(hash)
+		}
+	}
 	e.targets = all
 }
 
+// Results return a copy of explored results of all targets
+func (e *Explore) Results() map[uint64]*target.ScrapeStatus {
+	e.targetsLock.Lock()
+	defer e.targetsLock.Unlock()
+
+	ret := map[uint64]*target.ScrapeStatus{}
+	for hash, t := range e.targets {
+		if t.rt.Health == scrape2.HealthGood {
+			rt := *t.rt
+			ret[hash] = &rt
+		}
+	}
+	return ret
+}
+
+// Restore use explored results in "results" as the results of targets
+// restored targets can be assigned immediately, and they are explored again with the lowest priority
+// targets that not exist now will be restored when they are added by UpdateTargets
+// results may be restored from state and explore cache both, the latest explored one is used
+func (e *Explore) Restore(results map[uint64]*target.ScrapeStatus) {
+	e.targetsLock.Lock()
+	defer e.targetsLock.Unlock()
+
+	for hash, rt := range results {
+		if old := e.restored[hash]; old == nil || old.LastScrape.Before(rt.LastScrape) {
+			e.restored[hash] = rt
+		}
+	}
+
+	for _, t := range e.targets {
+		if !t.exploring && t.rt.Health == scrape2.HealthUnknown {
+			e.tryRestore(t)
+		}
+	}
+}
+
+func (e *Explore) tryRestore(t *exploringTarget) {
+	rt := e.restored[t.target.Hash]
+	if rt == nil {
+		return
+	}
+	delete(e.restored, t.target.Hash)
+
+	if rt.LastScrapeStatistics == nil {
+		rt.LastScrapeStatistics = scrape.NewStatisticsSeriesResult()
+	}
+	t.rt = rt
+	t.target.Series = rt.Series
+	t.target.TotalSeries = rt.TotalSeries
+	t.restored = true
+	if rt.Health == scrape2.HealthGood {
+		e.exploredJobs[t.job] = true
+	}
+}
+
 // Run start Explore exploring engine
-// every Target will be explore MaxExploreTime times
+// never explored targets are explored first, failed targets are retried with exponential backoff
 // "con" is the max worker goroutines
 func (e *Explore) Run(ctx context.Context, con int) error {
 	var g errgroup.Group
 	for i := 0; i < con; i++ {
 		g.Go(func() error {
 			for {
-				select {
-				case <-ctx.Done():
+				tar := e.queue.pop(ctx)
+				if tar == nil {
 					return nil
-				case temp := <-e.needExplore:
-					if temp == nil {
-						continue
-					}
-					tar := temp
-					hash := tar.target.Hash
-					err := e.exploreOnce(ctx, tar)
-					if err != nil {
-						go func() {
-							time.Sleep(e.retryInterval)
-							e.targetsLock.Lock()
-							defer e.targetsLock.Unlock()
-							if e.targets[hash] != nil {
-								e.needExplore <- tar
-							}
-						}()
+				}
+
+				var (
+					prevHealth = tar.rt.Health
+					prevSeries = tar.target.Series
+				)
+
+				err := e.exploreOnce(ctx, tar)
+				e.explored(tar, err)
+				if err == nil && (prevHealth != scrape2.HealthGood ||
+					seriesChanged(prevSeries, tar.target.Series, e.changeRatio)) {
+					for _, f := range e.exploredCallbacks {
+						f(tar.target.Hash)
 					}
 				}
 			}
@@ -190,12 +296,58 @@ func (e *Explore) Run(ctx context.Context, con int) error {
 	return g.Wait()
 }
 
+// explored record the explore result of target, failed target is queued again after backoff
+func (e *Explore) explored(t *exploringTarget, err error) {
+	e.targetsLock.Lock()
+	defer e.targetsLock.Unlock()
+
+	t.restored = false
+	if err == nil {
+		t.failures = 0
+		e.exploredJobs[t.job] = true
+		return
+	}
+
+	if e.targets[t.target.Hash] != t {
+		return
+	}
+
+	priority := priorityRetry
+	if !e.exploredJobs[t.job] {
+		priority = priorityUnexploredJob
+	}
+	t.failures++
+	e.queue.push(t, priority, e.backoff(t.failures))
+}
+
+// seriesChanged return true if the difference between "prev" and "cur" is more than "ratio" of "prev"
+func seriesChanged(prev, cur int64, ratio float64) bool {
+	diff := cur - prev
+	if diff < 0 {
+		diff = -diff
+	}
+	return float64(diff) > float64(prev)*ratio
+}
+
+// backoff return the wait time before the next retry of a target that failed "failures" times
+func (e *Explore) backoff(failures int) time.Duration {
+	d := e.retryInterval
+	for i := 1; i < failures && d < e.maxRetryInterval; i++ {
+		d *= 2
+	}
+
+	if d > e.maxRetryInterval {
+		d = e.maxRetryInterval
+	}
+	return d
+}
+
 func (e *Explore) exploreOnce(ctx context.Context, t *exploringTarget) (err error) {
 	defer t.rt.SetScrapeErr(time.Now(), err)
-	exploringTotal.WithLabelValues(t.job).Inc()
+	e.exploringTotal.WithLabelValues(t.job).Inc()
 	defer func() {
-		exploringTotal.WithLabelValues(t.job).Dec()
-		exploredTotal.WithLabelValues(t.job, fmt.Sprint(err == nil)).Inc()
+		e.exploringTotal.WithLabelValues(t.job).Dec()
+		e.exploredTotal.WithLabelValues(t.job, fmt.Sprint(err == nil)).Inc()
 	}()
 
 	info := e.scrapeManager.GetJob(t.job)
@@ -203,8 +355,20 @@ func (e *Explore) exploreOnce(ctx context.Context, t *exploringTarget) (err erro
 		return fmt.Errorf("can not found %s  scrape info", t.job)
 	}
 
-	url := t.target.URL(info.Config).String()
-	result, err := e.explore(e.logger, info, url)
+	var (
+		url     = t.target.URL(info.Config).String()
+		result  *scrape.StatisticsSeriesResult
+		handled bool
+	)
+
+	if e.delegate != nil {
+		result, handled, err = e.delegate(t.job, t.target)
+	}
+
+	if !handled {
+		result, err = e.explore(e.logger, info, url)
+	}
+
 	if err != nil {
 		return errors.Wrapf(err, "explore failed : %s/%s", t.job, url)
 	}
@@ -216,16 +380,3 @@ func (e *Explore) exploreOnce(ctx context.Context, t *exploringTarget) (err erro
 
 	return nil
 }
-
-func explore(log logrus.FieldLogger, scrapeInfo *scrape.JobInfo, url string) (*scrape.StatisticsSeriesResult, error) {
-	scraper := scrape.NewScraper(scrapeInfo, url, log)
-	if err := scraper.RequestTo(); err != nil {
-		return nil, errors.Wrap(err, "request to ")
-	}
-
-	r := scrape.NewStatisticsSeriesResult()
-	return r, scraper.ParseResponse(func(rows []parser.Row) error {
-		scrape.StatisticSeries(rows, scrapeInfo.Config.MetricRelabelConfigs, r)
-		return nil
-	})
-}
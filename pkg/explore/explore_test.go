@@ -19,6 +19,7 @@ package explore
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -26,6 +27,7 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/model/labels"
@@ -72,6 +74,10 @@ func TestExplore_Run(t *testing.T) {
 
 	e := New(sm, prometheus.NewRegistry(), logrus.New())
 	e.retryInterval = time.Millisecond * 10
+	explored := make(chan uint64, 1)
+	e.AddExploredCallbacks(func(hash uint64) {
+		explored <- hash
+	})
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go func() {
@@ -120,6 +126,7 @@ func TestExplore_Run(t *testing.T) {
 	r.Equal(scrape2.HealthGood, res.Health)
 	r.Equal(int64(1), res.Series)
 	r.Equal("", res.LastError)
+	r.Equal(uint64(1), <-explored)
 }
 
 func TestExplore_ApplyConfig(t *testing.T) {
@@ -136,3 +143,216 @@ func TestExplore_ApplyConfig(t *testing.T) {
 	r.NoError(e.ApplyConfig(&prom.ConfigInfo{Config: &config.Config{}}))
 	require.Nil(t, e.Get(1))
 }
+
+func TestExplore_Restore(t *testing.T) {
+	r := require.New(t)
+	e := New(scrape.New(true, logrus.New()), prometheus.NewRegistry(), logrus.New())
+	sdTargets := func(hashes ...uint64) map[string][]*discovery.SDTargets {
+		ret := map[string][]*discovery.SDTargets{}
+		for _, h := range hashes {
+			ret["job1"] = append(ret["job1"], &discovery.SDTargets{ShardTarget: &target.Target{Hash: h}})
+		}
+		return ret
+	}
+
+	e.UpdateTargets(sdTargets(1))
+	e.Restore(map[uint64]*target.ScrapeStatus{
+		1: {Series: 10, Health: scrape2.HealthGood},
+		2: {Series: 20, Health: scrape2.HealthGood},
+	})
+
+	// exist target is restored, and is explored again with the lowest priority
+	r.Equal(int64(10), e.Get(1).Series)
+	r.Equal(int64(10), e.targets[1].target.Series)
+	r.Equal(1, e.queue.len())
+	r.Equal(priorityRevalidate, e.queue.items[1].priority)
+
+	// new target is restored when it is added
+	e.UpdateTargets(sdTargets(1, 2, 3))
+	r.Equal(int64(20), e.Get(2).Series)
+	r.Equal(priorityRevalidate, e.queue.items[2].priority)
+	r.Equal(int64(0), e.Get(3).Series)
+	r.Equal(priorityNew, e.queue.items[3].priority)
+	r.Equal(3, e.queue.len())
+
+	res := e.Results()
+	r.Equal(2, len(res))
+	r.Equal(int64(20), res[2].Series)
+
+	// explored target is not restored any more
+	e.explored(e.targets[1], nil)
+	r.False(e.targets[1].restored)
+}
+
+func TestExplore_Backoff(t *testing.T) {
+	e := New(scrape.New(true, logrus.New()), prometheus.NewRegistry(), logrus.New())
+	e.retryInterval = time.Second
+	e.maxRetryInterval = time.Second * 5
+
+	var cases = []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: time.Second},
+		{failures: 2, want: time.Second * 2},
+		{failures: 3, want: time.Second * 4},
+		{failures: 4, want: time.Second * 5},
+		{failures: 100, want: time.Second * 5},
+	}
+
+	for _, cs := range cases {
+		require.Equal(t, cs.want, e.backoff(cs.failures))
+	}
+}
+
+func TestSeriesChanged(t *testing.T) {
+	var cases = []struct {
+		prev int64
+		cur  int64
+		want bool
+	}{
+		{prev: 100, cur: 100, want: false},
+		{prev: 100, cur: 120, want: false},
+		{prev: 100, cur: 121, want: true},
+		{prev: 100, cur: 79, want: true},
+		{prev: 0, cur: 1, want: true},
+	}
+
+	for _, cs := range cases {
+		require.Equal(t, cs.want, seriesChanged(cs.prev, cs.cur, 0.2))
+	}
+}
+
+func TestExplore_Explored(t *testing.T) {
+	r := require.New(t)
+	e := New(scrape.New(true, logrus.New()), prometheus.NewRegistry(), logrus.New())
+	e.retryInterval = 0
+	e.UpdateTargets(map[string][]*discovery.SDTargets{
+		"job1": {
+			{ShardTarget: &target.Target{Hash: 1}},
+			{ShardTarget: &target.Target{Hash: 2}},
+		},
+		"job2": {
+			{ShardTarget: &target.Target{Hash: 3}},
+		},
+	})
+
+	// job1 has no explored target
+	e.explored(e.targets[1], fmt.Errorf("test"))
+	r.Equal(1, e.targets[1].failures)
+	r.Equal(priorityUnexploredJob, e.queue.items[1].priority)
+
+	e.explored(e.targets[2], nil)
+	e.explored(e.targets[3], fmt.Errorf("test"))
+	r.Equal(priorityUnexploredJob, e.queue.items[3].priority)
+	r.Equal([]uint64{1, 3}, popHashes(e.queue))
+
+	e.explored(e.targets[1], fmt.Errorf("test"))
+	r.Equal(2, e.targets[1].failures)
+	r.Equal(priorityRetry, e.queue.items[1].priority)
+
+	// removed target is not queued again
+	e.UpdateTargets(map[string][]*discovery.SDTargets{})
+	r.Equal(0, e.queue.len())
+}
+
+func TestExplore_Delegate(t *testing.T) {
+	r := require.New(t)
+	sm := scrape.New(true, logrus.New())
+	r.NoError(sm.ApplyConfig(&prom.ConfigInfo{
+		Config: &config.Config{
+			ScrapeConfigs: []*config.ScrapeConfig{{JobName: "job1", ScrapeTimeout: model.Duration(time.Second)}},
+		},
+	}))
+
+	var cases = []struct {
+		name        string
+		handled     bool
+		err         error
+		wantLocal   bool
+		wantErr     bool
+		wantTotal   int64
+		delegateRet *scrape.StatisticsSeriesResult
+	}{
+		{
+			name:        "explored by delegate",
+			handled:     true,
+			delegateRet: &scrape.StatisticsSeriesResult{ScrapedTotal: 10, Total: 20},
+			wantTotal:   20,
+		},
+		{
+			name:    "failed by delegate",
+			handled: true,
+			err:     fmt.Errorf("test"),
+			wantErr: true,
+		},
+		{
+			name:      "fallback to local",
+			wantLocal: true,
+			wantTotal: 30,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			e := New(sm, prometheus.NewRegistry(), logrus.New())
+			local := false
+			e.explore = func(log logrus.FieldLogger, scrapeInfo *scrape.JobInfo, url string) (*scrape.StatisticsSeriesResult, error) {
+				local = true
+				return &scrape.StatisticsSeriesResult{ScrapedTotal: 30, Total: 30}, nil
+			}
+			e.SetDelegate(func(job string, t *target.Target) (*scrape.StatisticsSeriesResult, bool, error) {
+				return cs.delegateRet, cs.handled, cs.err
+			})
+
+			tar := &exploringTarget{job: "job1", target: &target.Target{Hash: 1}, rt: target.NewScrapeStatus(0, 0)}
+			err := e.exploreOnce(context.Background(), tar)
+			r.Equal(cs.wantLocal, local)
+			if cs.wantErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			r.Equal(cs.wantTotal, tar.target.TotalSeries)
+		})
+	}
+}
+
+func TestExplore_TenantMetrics(t *testing.T) {
+	r := require.New(t)
+	reg := prometheus.NewRegistry()
+	ea := New(scrape.New(true, logrus.New()), prometheus.WrapRegistererWith(prometheus.Labels{"tenant": "a"}, reg), logrus.New())
+	eb := New(scrape.New(true, logrus.New()), prometheus.WrapRegistererWith(prometheus.Labels{"tenant": "b"}, reg), logrus.New())
+
+	ea.queue.push(newTestingExploringTarget(1), priorityNew, 0)
+	r.Equal(float64(1), testutil.ToFloat64(ea.queue.queueDepth.WithLabelValues("new")))
+	r.Equal(float64(0), testutil.ToFloat64(eb.queue.queueDepth.WithLabelValues("new")))
+
+	mfs, err := reg.Gather()
+	r.NoError(err)
+	for _, mf := range mfs {
+		if mf.GetName() == "kvass_explore_queue_depth" {
+			r.Equal(2, len(mf.GetMetric()))
+		}
+	}
+}
+
+func TestExplore_RestoreLatest(t *testing.T) {
+	r := require.New(t)
+	e := New(scrape.New(true, logrus.New()), prometheus.NewRegistry(), logrus.New())
+	e.Restore(map[uint64]*target.ScrapeStatus{
+		1: {Series: 10, Health: scrape2.HealthGood, LastScrape: time.Now()},
+		2: {Series: 10, Health: scrape2.HealthGood, LastScrape: time.Now().Add(-time.Hour)},
+	})
+	e.Restore(map[uint64]*target.ScrapeStatus{
+		1: {Series: 20, Health: scrape2.HealthGood, LastScrape: time.Now().Add(-time.Hour)},
+		2: {Series: 20, Health: scrape2.HealthGood, LastScrape: time.Now()},
+	})
+
+	e.UpdateTargets(map[string][]*discovery.SDTargets{
+		"job1": {{ShardTarget: &target.Target{Hash: 1}}, {ShardTarget: &target.Target{Hash: 2}}},
+	})
+	r.Equal(int64(10), e.Get(1).Series)
+	r.Equal(int64(20), e.Get(2).Series)
+}
@@ -0,0 +1,194 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package explore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// priorityNew is the priority of targets that are never explored
+	priorityNew = iota
+	// priorityUnexploredJob is the priority of retrying targets whose job has no explored target
+	// targets of such job can not be assigned to any shard
+	priorityUnexploredJob
+	// priorityRetry is the priority of other retrying targets
+	priorityRetry
+	// priorityRevalidate is the priority of targets whose explored result is restored from snapshot
+	priorityRevalidate
+	priorityNum
+)
+
+var priorityNames = [priorityNum]string{"new", "unexplored_job", "retry", "revalidate"}
+
+type queueItem struct {
+	target   *exploringTarget
+	priority int
+	// timer is not nil if item is waiting for backoff
+	timer *time.Timer
+}
+
+// exploreQueue is a priority queue of targets waiting for exploring
+// a target is never queued twice, and targets with the same priority are popped in FIFO order
+type exploreQueue struct {
+	lock   sync.Mutex
+	queues [priorityNum][]*queueItem
+	// items contains all queued or backoff targets, an item in queues is invalid if it is not in items
+	items map[uint64]*queueItem
+	// queued is the number of valid items in queues
+	queued int
+	// notify is used to wake up one waiting pop
+	notify chan struct{}
+
+	queueDepth     *prometheus.GaugeVec
+	backoffTargets prometheus.Gauge
+}
+
+func newExploreQueue() *exploreQueue {
+	return &exploreQueue{
+		items:  map[uint64]*queueItem{},
+		notify: make(chan struct{}, 1),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvass_explore_queue_depth",
+			Help: "number of targets waiting for exploring, by priority",
+		}, []string{"priority"}),
+		backoffTargets: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "kvass_explore_backoff_targets",
+			Help: "number of failed targets waiting for backoff before they are queued again",
+		}),
+	}
+}
+
+// push add target to the queue after "delay", false is returned if target is already queued
+func (q *exploreQueue) push(t *exploringTarget, priority int, delay time.Duration) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	hash := t.target.Hash
+	if q.items[hash] != nil {
+		return false
+	}
+
+	item := &queueItem{target: t, priority: priority}
+	q.items[hash] = item
+	if delay <= 0 {
+		q.enqueue(item)
+		return true
+	}
+
+	q.backoffTargets.Inc()
+	item.timer = time.AfterFunc(delay, func() {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		if q.items[hash] != item {
+			return
+		}
+		q.backoffTargets.Dec()
+		item.timer = nil
+		q.enqueue(item)
+	})
+	return true
+}
+
+func (q *exploreQueue) enqueue(item *queueItem) {
+	q.queues[item.priority] = append(q.queues[item.priority], item)
+	q.queued++
+	q.queueDepth.WithLabelValues(priorityNames[item.priority]).Inc()
+	q.wakeup()
+}
+
+func (q *exploreQueue) wakeup() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// remove delete target from the queue
+func (q *exploreQueue) remove(hash uint64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	item := q.items[hash]
+	if item == nil {
+		return
+	}
+	delete(q.items, hash)
+
+	if item.timer != nil {
+		item.timer.Stop()
+		q.backoffTargets.Dec()
+		return
+	}
+	q.queued--
+	q.queueDepth.WithLabelValues(priorityNames[item.priority]).Dec()
+}
+
+// tryPop return the queued target with the highest priority, nil is returned if queue is empty
+func (q *exploreQueue) tryPop() *exploringTarget {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for p := range q.queues {
+		for len(q.queues[p]) != 0 {
+			item := q.queues[p][0]
+			q.queues[p][0] = nil
+			q.queues[p] = q.queues[p][1:]
+
+			hash := item.target.target.Hash
+			if q.items[hash] != item {
+				continue
+			}
+			delete(q.items, hash)
+			q.queued--
+			q.queueDepth.WithLabelValues(priorityNames[p]).Dec()
+
+			if q.queued != 0 {
+				q.wakeup()
+			}
+			return item.target
+		}
+	}
+	return nil
+}
+
+// pop wait until a target is queued and return it, nil is returned if ctx is done
+func (q *exploreQueue) pop(ctx context.Context) *exploringTarget {
+	for {
+		if t := q.tryPop(); t != nil {
+			return t
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-q.notify:
+		}
+	}
+}
+
+// len return the number of queued targets, backoff targets are not included
+func (q *exploreQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.queued
+}
@@ -0,0 +1,104 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package explore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"tkestack.io/kvass/pkg/target"
+)
+
+func newTestingExploringTarget(hash uint64) *exploringTarget {
+	return &exploringTarget{
+		job:    "job1",
+		target: &target.Target{Hash: hash},
+		rt:     target.NewScrapeStatus(0, 0),
+	}
+}
+
+func popHashes(q *exploreQueue) []uint64 {
+	ret := make([]uint64, 0)
+	for t := q.tryPop(); t != nil; t = q.tryPop() {
+		ret = append(ret, t.target.Hash)
+	}
+	return ret
+}
+
+func TestExploreQueue_Priority(t *testing.T) {
+	r := require.New(t)
+	q := newExploreQueue()
+	r.True(q.push(newTestingExploringTarget(1), priorityRetry, 0))
+	r.True(q.push(newTestingExploringTarget(2), priorityUnexploredJob, 0))
+	r.True(q.push(newTestingExploringTarget(3), priorityNew, 0))
+	r.True(q.push(newTestingExploringTarget(4), priorityNew, 0))
+	r.Equal(4, q.len())
+	r.Equal([]uint64{3, 4, 2, 1}, popHashes(q))
+	r.Equal(0, q.len())
+}
+
+func TestExploreQueue_Dedupe(t *testing.T) {
+	r := require.New(t)
+	q := newExploreQueue()
+	r.True(q.push(newTestingExploringTarget(1), priorityRetry, 0))
+	r.False(q.push(newTestingExploringTarget(1), priorityNew, 0))
+	r.True(q.push(newTestingExploringTarget(2), priorityRetry, time.Hour))
+	r.False(q.push(newTestingExploringTarget(2), priorityNew, 0))
+	r.Equal([]uint64{1}, popHashes(q))
+
+	// target can be queued again after it is popped
+	r.True(q.push(newTestingExploringTarget(1), priorityNew, 0))
+	r.Equal([]uint64{1}, popHashes(q))
+}
+
+func TestExploreQueue_Remove(t *testing.T) {
+	r := require.New(t)
+	q := newExploreQueue()
+	r.True(q.push(newTestingExploringTarget(1), priorityNew, 0))
+	r.True(q.push(newTestingExploringTarget(2), priorityNew, 0))
+	r.True(q.push(newTestingExploringTarget(3), priorityNew, time.Millisecond))
+	q.remove(1)
+	q.remove(3)
+	r.Equal(1, q.len())
+
+	// removed then queued again target is popped only once
+	r.True(q.push(newTestingExploringTarget(1), priorityNew, 0))
+	time.Sleep(time.Millisecond * 10)
+	r.Equal([]uint64{2, 1}, popHashes(q))
+}
+
+func TestExploreQueue_Pop(t *testing.T) {
+	r := require.New(t)
+	q := newExploreQueue()
+	r.True(q.push(newTestingExploringTarget(1), priorityNew, time.Millisecond*50))
+	r.Nil(q.tryPop())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	tar := q.pop(ctx)
+	r.NotNil(tar)
+	r.Equal(uint64(1), tar.target.Hash)
+	r.True(time.Since(start) >= time.Millisecond*40)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	r.Nil(q.pop(ctx))
+}
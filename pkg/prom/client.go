@@ -18,6 +18,14 @@
 package prom
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/expfmt"
 	"tkestack.io/kvass/pkg/api"
 
 	v1 "github.com/prometheus/prometheus/web/api/v1"
@@ -26,12 +34,24 @@ import (
 // Client is a client to do prometheus API request
 type Client struct {
 	url string
+	cli *http.Client
+	// timeout is the max duration of getting metrics of prometheus
+	timeout time.Duration
+
+	memoryLock sync.Mutex
+	memory     *MemoryInfo
+	memoryAt   time.Time
+	// memoryCacheTTL is the duration memory usage is reused before getting it again
+	memoryCacheTTL time.Duration
 }
 
 // NewClient return an cli with url
 func NewClient(url string) *Client {
 	return &Client{
-		url: url,
+		url:            url,
+		cli:            &http.Client{Timeout: time.Second * 10},
+		timeout:        time.Second * 10,
+		memoryCacheTTL: time.Second * 15,
 	}
 }
 
@@ -41,6 +61,64 @@ func (c *Client) TSDBInfo() (*TSDBInfo, error) {
 	return ret, api.Get(c.url+"/api/v1/status/tsdb", ret)
 }
 
+// MemoryInfo return the memory usage of prometheus process from its own metrics
+// the result is cached for memoryCacheTTL, so that /metrics of prometheus is not parsed on every call
+func (c *Client) MemoryInfo() (*MemoryInfo, error) {
+	c.memoryLock.Lock()
+	defer c.memoryLock.Unlock()
+
+	if c.memory != nil && time.Since(c.memoryAt) < c.memoryCacheTTL {
+		return c.memory, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	m, err := c.getMemoryInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.memory = m
+	c.memoryAt = time.Now()
+	return m, nil
+}
+
+func (c *Client) getMemoryInfo(ctx context.Context) (*MemoryInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/metrics", nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new request")
+	}
+
+	resp, err := c.cli.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get metrics")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get metrics failed, status code is %d", resp.StatusCode)
+	}
+
+	mfs, err := (&expfmt.TextParser{}).TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse metrics")
+	}
+
+	gauge := func(name string) int64 {
+		mf := mfs[name]
+		if mf == nil || len(mf.Metric) == 0 || mf.Metric[0].Gauge == nil {
+			return 0
+		}
+		return int64(mf.Metric[0].Gauge.GetValue())
+	}
+
+	return &MemoryInfo{
+		ResidentMemory: gauge("process_resident_memory_bytes"),
+		HeapMemory:     gauge("go_memstats_heap_inuse_bytes"),
+	}, nil
+}
+
 // Targets is compatible with prometheusURL /api/v1/targets
 // the origin prometheusURL's Config is injected, so the targets it report must be adjusted by cli sidecar
 func (c *Client) Targets(state string) (*v1.TargetDiscovery, error) {
@@ -20,7 +20,9 @@ package prom
 import (
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -47,6 +49,62 @@ func TestClient_TSDBInfo(t *testing.T) {
 	require.Equal(t, int64(508), r.HeadStats.NumSeries)
 }
 
+func TestClient_MemoryInfo(t *testing.T) {
+	w := dataServer(`# TYPE process_resident_memory_bytes gauge
+process_resident_memory_bytes 1.048576e+06
+# TYPE go_memstats_heap_inuse_bytes gauge
+go_memstats_heap_inuse_bytes 524288
+`)
+	defer w.Close()
+	c := NewClient(w.URL)
+	r, err := c.MemoryInfo()
+	require.NoError(t, err)
+	require.Equal(t, int64(1048576), r.ResidentMemory)
+	require.Equal(t, int64(524288), r.HeapMemory)
+}
+
+func TestClient_MemoryInfoCache(t *testing.T) {
+	r := require.New(t)
+	var requests int32
+	w := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		wr.Write([]byte("# TYPE process_resident_memory_bytes gauge\nprocess_resident_memory_bytes 100\n"))
+	}))
+	defer w.Close()
+
+	c := NewClient(w.URL)
+	for i := 0; i < 3; i++ {
+		m, err := c.MemoryInfo()
+		r.NoError(err)
+		r.Equal(int64(100), m.ResidentMemory)
+	}
+	r.Equal(int32(1), atomic.LoadInt32(&requests))
+
+	// get again after cache expired
+	c.memoryAt = time.Now().Add(-c.memoryCacheTTL)
+	_, err := c.MemoryInfo()
+	r.NoError(err)
+	r.Equal(int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestClient_MemoryInfoTimeout(t *testing.T) {
+	done := make(chan struct{})
+	w := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		select {
+		case <-done:
+		case <-req.Context().Done():
+		}
+	}))
+	defer w.Close()
+	defer close(done)
+
+	c := NewClient(w.URL)
+	c.timeout = time.Millisecond * 100
+	_, err := c.MemoryInfo()
+	require.Error(t, err)
+	require.Nil(t, c.memory)
+}
+
 func TestClient_ConfigReload(t *testing.T) {
 	w := dataServer(``)
 	defer w.Close()
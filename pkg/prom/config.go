@@ -20,10 +20,12 @@ package prom
 import (
 	"fmt"
 	"io/ioutil"
+	"reflect"
 
 	"github.com/go-kit/log"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/model/labels"
 )
@@ -40,11 +42,48 @@ global:
 type ExtraConfig struct {
 	// StopScrapeReason ,if not empty, all scrape will failed
 	StopScrapeReason string `json:"stopScrapeReason"`
+	// Alleviate ,if not nil, overwrite the shard alleviation flags of coordinator
+	Alleviate *AlleviateConfig `json:"alleviate,omitempty"`
+	// Quotas ,if not nil, overwrite the quota rules of coordinator
+	Quotas []QuotaRule `json:"quotas,omitempty"`
 }
 
 // EQ return true if all ExtraConfig fields is eq
 func (c *ExtraConfig) EQ(e *ExtraConfig) bool {
-	return c.StopScrapeReason == e.StopScrapeReason
+	return c.StopScrapeReason == e.StopScrapeReason &&
+		reflect.DeepEqual(c.Alleviate, e.Alleviate) &&
+		reflect.DeepEqual(c.Quotas, e.Quotas)
+}
+
+// QuotaRule limit head series and number of targets of a group of targets
+// targets are grouped by job, or by the value of Label if it is set
+type QuotaRule struct {
+	// Jobs is the jobs this rule applied to, rule is applied to all jobs if empty
+	Jobs []string `json:"jobs,omitempty" yaml:"jobs,omitempty"`
+	// Label is the target label used to group targets, e.g. namespace, targets are grouped by job if empty
+	Label string `json:"label,omitempty" yaml:"label,omitempty"`
+	// MaxSeries is the max head series of every group, skipped if 0
+	MaxSeries int64 `json:"maxSeries,omitempty" yaml:"maxSeries,omitempty"`
+	// MaxTargets is the max number of targets of every group, skipped if 0
+	MaxTargets int `json:"maxTargets,omitempty" yaml:"maxTargets,omitempty"`
+}
+
+// AlleviateThreshold is one tier of shard alleviation
+type AlleviateThreshold struct {
+	// MaxSeriesRate indicate that shard is in this tier if head series >= max head series * MaxSeriesRate
+	MaxSeriesRate float64 `json:"maxSeriesRate"`
+	// ExpectSeriesRate is the rate of max head series that shard in this tier is alleviated to
+	ExpectSeriesRate float64 `json:"expectSeriesRate"`
+}
+
+// AlleviateConfig indicate how coordinator alleviate overloaded shards
+type AlleviateConfig struct {
+	// Thresholds is the tiers of alleviation, default tiers are used if it is empty
+	Thresholds []AlleviateThreshold `json:"thresholds,omitempty"`
+	// Hysteresis is the rate of max head series that head series must drop below MaxSeriesRate before shard leave a tier
+	Hysteresis float64 `json:"hysteresis,omitempty"`
+	// Cooldown is the min interval between two alleviations of one shard
+	Cooldown model.Duration `json:"cooldown,omitempty"`
 }
 
 // ConfigInfo include all information of current config
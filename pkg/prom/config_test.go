@@ -133,3 +133,15 @@ func TestConfigManager_UpdateExtraConfig(t *testing.T) {
 	})
 	require.True(t, updated)
 }
+
+func TestExtraConfig_EQ(t *testing.T) {
+	r := require.New(t)
+	a := &ExtraConfig{Alleviate: &AlleviateConfig{Hysteresis: 0.1}}
+	r.True(a.EQ(&ExtraConfig{Alleviate: &AlleviateConfig{Hysteresis: 0.1}}))
+	r.False(a.EQ(&ExtraConfig{Alleviate: &AlleviateConfig{Hysteresis: 0.2}}))
+	r.False(a.EQ(&ExtraConfig{}))
+
+	b := &ExtraConfig{Quotas: []QuotaRule{{MaxSeries: 10}}}
+	r.True(b.EQ(&ExtraConfig{Quotas: []QuotaRule{{MaxSeries: 10}}}))
+	r.False(b.EQ(&ExtraConfig{Quotas: []QuotaRule{{MaxTargets: 10}}}))
+}
@@ -14,3 +14,11 @@ type TSDBInfo struct {
 		NumSeries int64 `json:"numSeries"`
 	} `json:"headStats"`
 }
+
+// MemoryInfo include memory usage of prometheus process, got from its /metrics
+type MemoryInfo struct {
+	// ResidentMemory is the resident memory bytes of prometheus process (process_resident_memory_bytes)
+	ResidentMemory int64
+	// HeapMemory is the heap bytes in use of prometheus process (go_memstats_heap_inuse_bytes)
+	HeapMemory int64
+}
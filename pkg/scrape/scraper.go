@@ -109,6 +109,20 @@ func (s *Scraper) ParseResponse(do func(rows []parser.Row) error) error {
 		})
 }
 
+// Statistics scrape "url" once and return the series statistics of it
+func Statistics(log logrus.FieldLogger, scrapeInfo *JobInfo, url string) (*StatisticsSeriesResult, error) {
+	scraper := NewScraper(scrapeInfo, url, log)
+	if err := scraper.RequestTo(); err != nil {
+		return nil, errors.Wrap(err, "request to ")
+	}
+
+	r := NewStatisticsSeriesResult()
+	return r, scraper.ParseResponse(func(rows []parser.Row) error {
+		StatisticSeries(rows, scrapeInfo.Config.MetricRelabelConfigs, r)
+		return nil
+	})
+}
+
 // StatisticsSeriesResult is the samples count in one scrape
 type StatisticsSeriesResult struct {
 	lk sync.Mutex `json:"-"`
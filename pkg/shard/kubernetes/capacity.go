@@ -0,0 +1,63 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"tkestack.io/kvass/pkg/shard"
+)
+
+// MemoryCapacity derive series limits and max memory of shards from the memory limit of Prometheus container
+// changing resources of StatefulSet will change the capacity of shards after pods are recreated
+type MemoryCapacity struct {
+	// Container is the name of Prometheus container in shard pod
+	Container string
+	// BytesPerSeries is the memory cost of every head series, max head series of shard is memory limit / BytesPerSeries
+	BytesPerSeries int64
+	// LimitRatio is the ratio of memory limit that Prometheus can use, max memory of shard is memory limit * LimitRatio
+	LimitRatio float64
+}
+
+// capacity return the capacity of shard pod "p"
+// nil is returned if capacity can not be derived, limits of coordinator option are used in this case
+func (m *MemoryCapacity) capacity(p *v1.Pod) *shard.Capacity {
+	if m == nil || (m.BytesPerSeries <= 0 && m.LimitRatio <= 0) {
+		return nil
+	}
+
+	for _, c := range p.Spec.Containers {
+		if c.Name != m.Container {
+			continue
+		}
+
+		limit := c.Resources.Limits.Memory()
+		if limit == nil || limit.IsZero() {
+			return nil
+		}
+
+		cp := &shard.Capacity{}
+		if m.BytesPerSeries > 0 {
+			cp.MaxHeadSeries = limit.Value() / m.BytesPerSeries
+		}
+		if m.LimitRatio > 0 {
+			cp.MaxMemoryBytes = int64(float64(limit.Value()) * m.LimitRatio)
+		}
+		return cp
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes/fake"
+	"tkestack.io/kvass/pkg/shard"
+)
+
+func newTestingCapacityPod(name, container, memory string) v1.Pod {
+	p := v1.Pod{}
+	p.Name = name
+	c := v1.Container{Name: container}
+	if memory != "" {
+		c.Resources.Limits = v1.ResourceList{v1.ResourceMemory: resource.MustParse(memory)}
+	}
+	p.Spec.Containers = []v1.Container{{Name: "sidecar"}, c}
+	return p
+}
+
+func TestMemoryCapacity_Capacity(t *testing.T) {
+	var cases = []struct {
+		name     string
+		capacity *MemoryCapacity
+		pod      v1.Pod
+		want     *shard.Capacity
+	}{
+		{
+			name: "not set",
+			pod:  newTestingCapacityPod("rep1-0", "prometheus", "16Gi"),
+		},
+		{
+			name:     "bytes per series and limit ratio are 0",
+			capacity: &MemoryCapacity{Container: "prometheus"},
+			pod:      newTestingCapacityPod("rep1-0", "prometheus", "16Gi"),
+		},
+		{
+			name:     "container not found",
+			capacity: &MemoryCapacity{Container: "prometheus", LimitRatio: 0.8},
+			pod:      newTestingCapacityPod("rep1-0", "prom", "16Gi"),
+		},
+		{
+			name:     "memory limit not set",
+			capacity: &MemoryCapacity{Container: "prometheus", LimitRatio: 0.8},
+			pod:      newTestingCapacityPod("rep1-0", "prometheus", ""),
+		},
+		{
+			name:     "head series derived from memory limit",
+			capacity: &MemoryCapacity{Container: "prometheus", BytesPerSeries: 4096},
+			pod:      newTestingCapacityPod("rep1-0", "prometheus", "16Gi"),
+			want:     &shard.Capacity{MaxHeadSeries: 4 * 1024 * 1024},
+		},
+		{
+			name:     "max memory derived from memory limit",
+			capacity: &MemoryCapacity{Container: "prometheus", LimitRatio: 0.5},
+			pod:      newTestingCapacityPod("rep1-0", "prometheus", "16Gi"),
+			want:     &shard.Capacity{MaxMemoryBytes: 8 * 1024 * 1024 * 1024},
+		},
+		{
+			name:     "both derived from memory limit",
+			capacity: &MemoryCapacity{Container: "prometheus", BytesPerSeries: 4096, LimitRatio: 0.5},
+			pod:      newTestingCapacityPod("rep1-0", "prometheus", "16Gi"),
+			want:     &shard.Capacity{MaxHeadSeries: 4 * 1024 * 1024, MaxMemoryBytes: 8 * 1024 * 1024 * 1024},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			require.Equal(t, cs.want, cs.capacity.capacity(&cs.pod))
+		})
+	}
+}
+
+func TestStatefulSet_ShardsCapacity(t *testing.T) {
+	r := require.New(t)
+	cli := fake.NewSimpleClientset()
+	sf := createStatefulSet(t, cli, "rep1", 2)
+
+	sts := newShardManager(cli, sf, 8080, true, nil, logrus.New())
+	sts.memoryCapacity = &MemoryCapacity{Container: "prometheus", BytesPerSeries: 1024, LimitRatio: 1}
+	sts.getPods = func(lb map[string]string) (list *v1.PodList, e error) {
+		return &v1.PodList{Items: []v1.Pod{
+			newTestingCapacityPod("rep1-0", "prometheus", "1Gi"),
+			newTestingCapacityPod("rep1-1", "prometheus", "4Gi"),
+		}}, nil
+	}
+
+	shards, err := sts.Shards()
+	r.NoError(err)
+	r.Equal(int64(1024*1024), shards[0].Capacity.MaxHeadSeries)
+	r.Equal(int64(4*1024*1024), shards[1].Capacity.MaxHeadSeries)
+	r.Equal(int64(1024*1024*1024), shards[0].Capacity.MaxMemoryBytes)
+	r.Equal(int64(4*1024*1024*1024), shards[1].Capacity.MaxMemoryBytes)
+}
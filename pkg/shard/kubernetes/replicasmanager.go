@@ -22,8 +22,12 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"time"
 	"tkestack.io/kvass/pkg/shard"
 )
@@ -37,8 +41,13 @@ type ReplicasManager struct {
 	lg               logrus.FieldLogger
 	listStatefulSets func(ctx context.Context, opts v12.ListOptions) (*v1.StatefulSetList, error)
 	stsUpdatedTime   map[string]*time.Time
+	recorder         record.EventRecorder
+	memoryCapacity   *MemoryCapacity
 }
 
+// eventComponent is the source component of kubernetes Events reported by coordinator
+const eventComponent = "kvass-coordinator"
+
 // NewReplicasManager create a ReplicasManager
 func NewReplicasManager(
 	cli kubernetes.Interface,
@@ -48,7 +57,10 @@ func NewReplicasManager(
 	deletePVC bool,
 	lg logrus.FieldLogger,
 ) *ReplicasManager {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cli.CoreV1().Events(stsNamespace)})
 	return &ReplicasManager{
+		recorder:         broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent}),
 		cli:              cli,
 		port:             port,
 		deletePVC:        deletePVC,
@@ -59,6 +71,11 @@ func NewReplicasManager(
 	}
 }
 
+// SetMemoryCapacity make max head series and max memory of every shard derived from memory limit of its Prometheus container
+func (g *ReplicasManager) SetMemoryCapacity(m *MemoryCapacity) {
+	g.memoryCapacity = m
+}
+
 // Replicas return all shards manager
 func (g *ReplicasManager) Replicas() ([]shard.Manager, error) {
 	sts, err := g.listStatefulSets(context.TODO(), v12.ListOptions{
@@ -89,7 +106,9 @@ func (g *ReplicasManager) Replicas() ([]shard.Manager, error) {
 		}
 
 		tempS := s
-		ret = append(ret, newShardManager(g.cli, &tempS, g.port, g.deletePVC, g.lg.WithField("sts", s.Name)))
+		sm := newShardManager(g.cli, &tempS, g.port, g.deletePVC, g.recorder, g.lg.WithField("sts", s.Name))
+		sm.memoryCapacity = g.memoryCapacity
+		ret = append(ret, sm)
 	}
 
 	return ret, nil
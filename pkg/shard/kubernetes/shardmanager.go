@@ -28,9 +28,13 @@ import (
 	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"tkestack.io/kvass/pkg/shard"
 )
 
+// PoolLabel is the label of StatefulSet that indicate which shard pool it belongs to
+const PoolLabel = "kvass.tkestack.io/pool"
+
 // shardManager manager shards use kubernetes shardManager
 type shardManager struct {
 	sts *v13.StatefulSet
@@ -38,8 +42,11 @@ type shardManager struct {
 	port      int
 	deletePVC bool
 	cli       kubernetes.Interface
+	recorder  record.EventRecorder
 	lg        logrus.FieldLogger
 	getPods   func(lb map[string]string) (*v1.PodList, error)
+	// memoryCapacity derive capacity of shards from memory limit of pods, skipped if nil
+	memoryCapacity *MemoryCapacity
 }
 
 // newShardManager create a new StatefulSet shards manager
@@ -47,8 +54,10 @@ func newShardManager(cli kubernetes.Interface,
 	sts *v13.StatefulSet,
 	port int,
 	deletePVC bool,
+	recorder record.EventRecorder,
 	log logrus.FieldLogger) *shardManager {
 	return &shardManager{
+		recorder:  recorder,
 		sts:       sts,
 		port:      port,
 		lg:        log,
@@ -79,12 +88,32 @@ func (s *shardManager) Shards() ([]*shard.Shard, error) {
 	for index := range pods.Items {
 		p := ps[fmt.Sprintf("%s-%d", s.sts.Name, index)]
 		url := fmt.Sprintf("http://%s:%d", p.Status.PodIP, s.port)
-		ret = append(ret, shard.NewShard(p.Name, url, p.Status.PodIP != "", s.lg.WithField("shard", p.Name)))
+		sd := shard.NewShard(p.Name, url, p.Status.PodIP != "", s.lg.WithField("shard", p.Name))
+		sd.Capacity = s.memoryCapacity.capacity(&p)
+		ret = append(ret, sd)
 	}
 
 	return ret, nil
 }
 
+// Pool return the value of label PoolLabel of StatefulSet
+func (s *shardManager) Pool() string {
+	return s.sts.Labels[PoolLabel]
+}
+
+// Name return the name of StatefulSet
+func (s *shardManager) Name() string {
+	return s.sts.Name
+}
+
+// ReportEvent create a kubernetes Event on the StatefulSet
+func (s *shardManager) ReportEvent(reason, message string) {
+	if s.recorder == nil {
+		return
+	}
+	s.recorder.Event(s.sts, v1.EventTypeNormal, reason, message)
+}
+
 // ChangeScale create or delete Shards according to "expReplicate"
 func (s *shardManager) ChangeScale(expect int32) error {
 	sts, err := s.cli.AppsV1().StatefulSets(s.sts.Namespace).Get(context.TODO(), s.sts.Name, v12.GetOptions{})
@@ -33,6 +33,7 @@ import (
 
 	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
 func createStatefulSet(t *testing.T, cli kubernetes.Interface, name string, rep int32) *appsv1.StatefulSet {
@@ -72,7 +73,7 @@ func TestStatefulSet_Shards(t *testing.T) {
 	cli := fake.NewSimpleClientset()
 	sf := createStatefulSet(t, cli, "rep1", 2)
 
-	sts := newShardManager(cli, sf, 8080, true, logrus.New())
+	sts := newShardManager(cli, sf, 8080, true, nil, logrus.New())
 	sts.getPods = func(lb map[string]string) (list *v1.PodList, e error) {
 		pl := &v1.PodList{}
 		for i := 0; i < 2; i++ {
@@ -96,6 +97,34 @@ func TestStatefulSet_Shards(t *testing.T) {
 	r.Equal(2, len(shards))
 }
 
+func TestStatefulSet_Pool(t *testing.T) {
+	r := require.New(t)
+	cli := fake.NewSimpleClientset()
+	sf := createStatefulSet(t, cli, "rep1", 2)
+	r.Equal("", newShardManager(cli, sf, 8080, true, nil, logrus.New()).Pool())
+
+	sf.Labels[PoolLabel] = "pool1"
+	r.Equal("pool1", newShardManager(cli, sf, 8080, true, nil, logrus.New()).Pool())
+}
+
+func TestStatefulSet_Name(t *testing.T) {
+	cli := fake.NewSimpleClientset()
+	sf := createStatefulSet(t, cli, "rep1", 2)
+	require.Equal(t, "rep1", newShardManager(cli, sf, 8080, true, nil, logrus.New()).Name())
+}
+
+func TestStatefulSet_ReportEvent(t *testing.T) {
+	r := require.New(t)
+	cli := fake.NewSimpleClientset()
+	sf := createStatefulSet(t, cli, "rep1", 2)
+	recorder := record.NewFakeRecorder(1)
+	newShardManager(cli, sf, 8080, true, recorder, logrus.New()).ReportEvent("scale-up", "scale-up from 1 to 2")
+	r.Equal("Normal scale-up scale-up from 1 to 2", <-recorder.Events)
+
+	// nil recorder is allowed
+	newShardManager(cli, sf, 8080, true, nil, logrus.New()).ReportEvent("scale-up", "scale-up from 1 to 2")
+}
+
 func TestStatefulSet_ChangeScale(t *testing.T) {
 	t.Run("scale up", testScaleUp)
 	t.Run("scale down ,delete pvc", func(t *testing.T) {
@@ -110,7 +139,7 @@ func testScaleUp(t *testing.T) {
 	r := require.New(t)
 	cli := fake.NewSimpleClientset()
 	sf := createStatefulSet(t, cli, "rep1", 2)
-	sts := newShardManager(cli, sf, 8080, true, logrus.New())
+	sts := newShardManager(cli, sf, 8080, true, nil, logrus.New())
 	r.NoError(sts.ChangeScale(10))
 	s, err := cli.AppsV1().StatefulSets("default").Get(context.TODO(), "rep1", v12.GetOptions{})
 	r.NoError(err)
@@ -121,7 +150,7 @@ func testScaleDown(t *testing.T, deletePvc bool) {
 	r := require.New(t)
 	cli := fake.NewSimpleClientset()
 	sf := createStatefulSet(t, cli, "rep1", 2)
-	sts := newShardManager(cli, sf, 8080, deletePvc, logrus.New())
+	sts := newShardManager(cli, sf, 8080, deletePvc, nil, logrus.New())
 	r.NoError(sts.ChangeScale(1))
 	s, err := cli.AppsV1().StatefulSets("default").Get(context.TODO(), "rep1", v12.GetOptions{})
 	r.NoError(err)
@@ -20,6 +20,7 @@ package shard
 import (
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -29,6 +30,8 @@ import (
 	"tkestack.io/kvass/pkg/target"
 )
 
+var timeNow = time.Now
+
 // Shard is a prometheus shard
 type Shard struct {
 	// ID is the unique ID for differentiate different replicate of shard
@@ -41,10 +44,27 @@ type Shard struct {
 	APIPost func(url string, req interface{}, ret interface{}) (err error)
 	// scraping is the cached ScrapeStatus fetched from sidecar last time
 	scraping map[uint64]*target.ScrapeStatus
-	url      string
-	log      logrus.FieldLogger
+	// generation is the targets generation of sidecar, 0 means unknown
+	generation int64
+	url        string
+	log        logrus.FieldLogger
 	// Ready indicate this shard is ready
 	Ready bool
+	// Capacity overwrite the limits of coordinator option for this shard, nil if shard use the same limits as others
+	Capacity *Capacity
+}
+
+// Capacity is the limits of one shard
+// fields with zero value inherit from coordinator option
+type Capacity struct {
+	// MaxHeadSeries is max series after metrics_relabels this shard can assign
+	MaxHeadSeries int64
+	// MaxProcessSeries is max series before metrics_relabels this shard can assign
+	MaxProcessSeries int64
+	// MaxMemoryBytes is max resident memory of Prometheus of this shard
+	MaxMemoryBytes int64
+	// Weight scale all limits of coordinator option that not overwritten, 1 is used if 0
+	Weight float64
 }
 
 // NewShard create a Shard with empty scraping cache
@@ -91,6 +111,8 @@ func (r *Shard) RuntimeInfo() (*RuntimeInfo, error) {
 		return res, fmt.Errorf("get runtime info from %s failed : %s", r.ID, err.Error())
 	}
 
+	r.generation = res.TargetsGeneration
+
 	return res, nil
 }
 
@@ -115,6 +137,16 @@ func (r *Shard) TargetStatus() (map[uint64]*target.ScrapeStatus, error) {
 	return res, nil
 }
 
+// Explore ask sidecar to explore a target of job
+// error is returned only if request failed, the explore error of target is in ExploreResult
+func (r *Shard) Explore(job string, t *target.Target) (*ExploreResult, error) {
+	res := &ExploreResult{}
+	if err := r.APIPost(r.url+"/api/v1/shard/explore/", &ExploreRequest{Job: job, Target: t}, res); err != nil {
+		return nil, errors.Wrapf(err, "explore target by %s failed, url = %s", r.ID, r.url)
+	}
+	return res, nil
+}
+
 // UpdateConfig try update shard config by API
 func (r *Shard) UpdateConfig(req *UpdateConfigRequest) error {
 	return r.APIPost(r.url+"/api/v1/status/config", req, nil)
@@ -127,6 +159,7 @@ func (r *Shard) UpdateExtraConfig(req *prom.ExtraConfig) error {
 
 // UpdateTarget try apply targets to sidecar
 // request will be skipped if nothing changed according to r.scraping
+// only the changes are sent if the targets generation of sidecar is known, otherwise all targets are sent
 func (r *Shard) UpdateTarget(request *UpdateTargetsRequest) error {
 	newTargets := map[uint64]*target.Target{}
 	for _, ts := range request.Targets {
@@ -135,18 +168,74 @@ func (r *Shard) UpdateTarget(request *UpdateTargetsRequest) error {
 		}
 	}
 
-	if r.needUpdate(newTargets) {
-		if len(newTargets) != 0 || len(r.scraping) != 0 {
-			r.log.Infof("%s need update targets", r.ID)
+	if !r.needUpdate(newTargets) {
+		return nil
+	}
+
+	if len(newTargets) != 0 || len(r.scraping) != 0 {
+		r.log.Infof("%s need update targets", r.ID)
+	}
+
+	if r.generation != 0 && r.scraping != nil {
+		resync, err := r.updateTargetDelta(request)
+		if err == nil && !resync {
+			return nil
 		}
-		if err := r.APIPost(r.url+"/api/v1/shard/targets/", &request, nil); err != nil {
-			return err
+
+		if err != nil {
+			r.log.Warnf("update targets delta of %s failed, all targets will be sent: %s", r.ID, err.Error())
+		} else {
+			r.log.Warnf("targets generation of %s changed, all targets will be sent", r.ID)
 		}
 	}
 
+	req := *request
+	req.Generation = timeNow().UnixNano()
+	if err := r.APIPost(r.url+"/api/v1/shard/targets/", &req, nil); err != nil {
+		return err
+	}
+	r.generation = req.Generation
 	return nil
 }
 
+// updateTargetDelta send the changes between request and r.scraping to sidecar
+// true is returned if sidecar rejects the delta because of generation mismatched
+func (r *Shard) updateTargetDelta(request *UpdateTargetsRequest) (bool, error) {
+	req := &UpdateTargetsDeltaRequest{
+		BaseGeneration: r.generation,
+		Generation:     r.generation + 1,
+		Add:            map[string][]*target.Target{},
+		Remove:         []uint64{},
+	}
+
+	exist := map[uint64]bool{}
+	for job, ts := range request.Targets {
+		for _, t := range ts {
+			exist[t.Hash] = true
+			if st := r.scraping[t.Hash]; st == nil || st.TargetState != t.TargetState {
+				req.Add[job] = append(req.Add[job], t)
+			}
+		}
+	}
+
+	for hash := range r.scraping {
+		if !exist[hash] {
+			req.Remove = append(req.Remove, hash)
+		}
+	}
+
+	ret := &UpdateTargetsDeltaResult{}
+	if err := r.APIPost(r.url+"/api/v1/shard/targets/delta/", req, ret); err != nil {
+		return false, err
+	}
+
+	if ret.Resync {
+		return true, nil
+	}
+	r.generation = ret.Generation
+	return false, nil
+}
+
 func (r *Shard) needUpdate(targets map[uint64]*target.Target) bool {
 	if len(targets) != len(r.scraping) || len(targets) == 0 {
 		return true
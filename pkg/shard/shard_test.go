@@ -18,6 +18,7 @@
 package shard
 
 import (
+	"fmt"
 	"net/url"
 	"strings"
 	"testing"
@@ -42,13 +43,15 @@ func TestShard_RuntimeInfo(t *testing.T) {
 	s, r := newTestingShard(t)
 	s.APIGet = func(url string, ret interface{}) error {
 		return test.CopyJSON(ret, &RuntimeInfo{
-			HeadSeries: 10,
+			HeadSeries:        10,
+			TargetsGeneration: 3,
 		})
 	}
 
 	res, err := s.RuntimeInfo()
 	r.NoError(err)
 	r.Equal(int64(10), res.HeadSeries)
+	r.Equal(int64(3), s.generation)
 }
 
 func TestShard_TargetStatus(t *testing.T) {
@@ -71,6 +74,27 @@ func TestShard_TargetStatus(t *testing.T) {
 	r.JSONEq(test.MustJSON(st), test.MustJSON(ret[1]))
 }
 
+func TestShard_Explore(t *testing.T) {
+	s, r := newTestingShard(t)
+	var req *ExploreRequest
+	s.APIPost = func(url string, data interface{}, ret interface{}) error {
+		req = data.(*ExploreRequest)
+		return test.CopyJSON(ret, &ExploreResult{Result: &kscrape.StatisticsSeriesResult{Total: 10}})
+	}
+
+	ret, err := s.Explore("job1", &target.Target{Hash: 1})
+	r.NoError(err)
+	r.Equal("job1", req.Job)
+	r.Equal(uint64(1), req.Target.Hash)
+	r.Equal(float64(10), ret.Result.Total)
+
+	s.APIPost = func(url string, data interface{}, ret interface{}) error {
+		return fmt.Errorf("test")
+	}
+	_, err = s.Explore("job1", &target.Target{Hash: 1})
+	r.Error(err)
+}
+
 func TestShard_UpdateTarget(t *testing.T) {
 	var cases = []struct {
 		name        string
@@ -228,3 +252,97 @@ func TestShard_Samples(t *testing.T) {
 		})
 	}
 }
+
+func TestShard_UpdateTargetDelta(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Unix(100, 0)
+	}
+	defer func() { timeNow = time.Now }()
+
+	var cases = []struct {
+		name           string
+		generation     int64
+		postDeltaErr   error
+		deltaResult    *UpdateTargetsDeltaResult
+		wantDelta      *UpdateTargetsDeltaRequest
+		wantFull       bool
+		wantGeneration int64
+	}{
+		{
+			name:           "generation unknown, send all targets",
+			wantFull:       true,
+			wantGeneration: time.Unix(100, 0).UnixNano(),
+		},
+		{
+			name:        "send delta",
+			generation:  10,
+			deltaResult: &UpdateTargetsDeltaResult{Generation: 11},
+			wantDelta: &UpdateTargetsDeltaRequest{
+				BaseGeneration: 10,
+				Generation:     11,
+				Add: map[string][]*target.Target{
+					"job1": {
+						{Hash: 2},
+						{Hash: 3, TargetState: target.StateInTransfer},
+					},
+				},
+				Remove: []uint64{4},
+			},
+			wantGeneration: 11,
+		},
+		{
+			name:           "generation mismatched, send all targets",
+			generation:     10,
+			deltaResult:    &UpdateTargetsDeltaResult{Generation: 5, Resync: true},
+			wantFull:       true,
+			wantGeneration: time.Unix(100, 0).UnixNano(),
+		},
+		{
+			name:           "delta failed, send all targets",
+			generation:     10,
+			postDeltaErr:   fmt.Errorf("test"),
+			wantFull:       true,
+			wantGeneration: time.Unix(100, 0).UnixNano(),
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			s, r := newTestingShard(t)
+			s.generation = cs.generation
+			s.scraping = map[uint64]*target.ScrapeStatus{
+				1: {TargetState: target.StateNormal},
+				3: {TargetState: target.StateNormal},
+				4: {TargetState: target.StateNormal},
+			}
+
+			full := false
+			s.APIPost = func(url string, req interface{}, ret interface{}) (err error) {
+				if strings.HasSuffix(url, "/delta/") {
+					if cs.wantDelta != nil {
+						r.JSONEq(test.MustJSON(cs.wantDelta), test.MustJSON(req))
+					}
+					if cs.postDeltaErr != nil {
+						return cs.postDeltaErr
+					}
+					return test.CopyJSON(ret, cs.deltaResult)
+				}
+				full = true
+				r.Equal(cs.wantGeneration, req.(*UpdateTargetsRequest).Generation)
+				return nil
+			}
+
+			r.NoError(s.UpdateTarget(&UpdateTargetsRequest{
+				Targets: map[string][]*target.Target{
+					"job1": {
+						{Hash: 1},
+						{Hash: 2},
+						{Hash: 3, TargetState: target.StateInTransfer},
+					},
+				},
+			}))
+			r.Equal(cs.wantFull, full)
+			r.Equal(cs.wantGeneration, s.generation)
+		})
+	}
+}
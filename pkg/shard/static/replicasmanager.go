@@ -18,6 +18,8 @@
 package static
 
 import (
+	"fmt"
+
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
@@ -51,8 +53,18 @@ func (g *ReplicasManager) Replicas() ([]shard.Manager, error) {
 	}
 
 	ret := make([]shard.Manager, 0)
-	for _, r := range config.Replicas {
-		ret = append(ret, newShardManager(r.Shards, g.log))
+	for i, r := range config.Replicas {
+		for _, s := range r.Shards {
+			if s.MaxHeadSeries < 0 || s.MaxProcessSeries < 0 || s.Weight < 0 {
+				return nil, errors.Errorf("capacity of shard %s can not be negative", s.ID)
+			}
+		}
+
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		ret = append(ret, newShardManager(name, r.Pool, r.Shards, g.log))
 	}
 
 	return ret, nil
@@ -28,6 +28,7 @@ func TestReplicasManager_Replicas(t *testing.T) {
 	type caseInfo struct {
 		fileContent  string
 		wantReplicas int
+		wantNames    []string
 		wantErr      bool
 	}
 
@@ -35,7 +36,8 @@ func TestReplicasManager_Replicas(t *testing.T) {
 		return &caseInfo{
 			fileContent: `
 replicas:
-- shards:
+- name: rep-a
+  shards:
   - id: shard-0
     url: http://1.1.1.1
 - shards:
@@ -44,6 +46,7 @@ replicas:
 `,
 			wantErr:      false,
 			wantReplicas: 2,
+			wantNames:    []string{"rep-a", "1"},
 		}
 	}
 
@@ -55,6 +58,36 @@ replicas:
 			desc:       "success",
 			updateCase: func(c *caseInfo) {},
 		},
+		{
+			desc: "with capacity",
+			updateCase: func(c *caseInfo) {
+				c.fileContent = `
+replicas:
+- shards:
+  - id: shard-0
+    url: http://1.1.1.1
+    maxHeadSeries: 4000000
+  - id: shard-1
+    url: http://2.2.2.2
+    weight: 4
+`
+				c.wantReplicas = 1
+				c.wantNames = []string{"0"}
+			},
+		},
+		{
+			desc: "negative capacity",
+			updateCase: func(c *caseInfo) {
+				c.fileContent = `
+replicas:
+- shards:
+  - id: shard-0
+    url: http://1.1.1.1
+    weight: -1
+`
+				c.wantErr = true
+			},
+		},
 		{
 			desc: "wrong config format",
 			updateCase: func(c *caseInfo) {
@@ -88,6 +121,9 @@ replicas:
 				return
 			}
 			r.Equal(c.wantReplicas, len(res))
+			for i, name := range c.wantNames {
+				r.Equal(name, res[i].Name())
+			}
 		})
 	}
 }
@@ -23,12 +23,16 @@ import (
 )
 
 type shardManager struct {
+	name   string
+	pool   string
 	shards []shardConfig
 	log    logrus.FieldLogger
 }
 
-func newShardManager(shards []shardConfig, log logrus.FieldLogger) *shardManager {
+func newShardManager(name, pool string, shards []shardConfig, log logrus.FieldLogger) *shardManager {
 	return &shardManager{
+		name:   name,
+		pool:   pool,
 		shards: shards,
 		log:    log,
 	}
@@ -38,11 +42,23 @@ func newShardManager(shards []shardConfig, log logrus.FieldLogger) *shardManager
 func (s *shardManager) Shards() ([]*shard.Shard, error) {
 	ret := make([]*shard.Shard, 0)
 	for _, sd := range s.shards {
-		ret = append(ret, shard.NewShard(sd.ID, sd.URL, true, s.log.WithField("shard", sd.ID)))
+		sh := shard.NewShard(sd.ID, sd.URL, true, s.log.WithField("shard", sd.ID))
+		sh.Capacity = sd.capacity()
+		ret = append(ret, sh)
 	}
 	return ret, nil
 }
 
+// Pool return the name of shard pool this replica belongs to
+func (s *shardManager) Pool() string {
+	return s.pool
+}
+
+// Name return the name of this replica
+func (s *shardManager) Name() string {
+	return s.name
+}
+
 // ChangeScale create or delete Shards according to "expReplicate"
 // static shard can not change scale
 func (s *shardManager) ChangeScale(expReplicate int32) error {
@@ -21,6 +21,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"tkestack.io/kvass/pkg/shard"
 )
 
 func TestShardManager_Shards(t *testing.T) {
@@ -30,14 +31,32 @@ func TestShardManager_Shards(t *testing.T) {
 			URL: "http://1.1.1.1",
 		},
 	}
-	m := newShardManager(shards, logrus.New())
+	m := newShardManager("0", "pool1", shards, logrus.New())
 	sd, err := m.Shards()
 	require.NoError(t, err)
 	require.Equal(t, 1, len(sd))
 	require.Equal(t, shards[0].ID, sd[0].ID)
+	require.Equal(t, "pool1", m.Pool())
+	require.Equal(t, "0", m.Name())
+	require.Nil(t, sd[0].Capacity)
+}
+
+func TestShardManager_ShardsCapacity(t *testing.T) {
+	shards := []shardConfig{
+		{
+			ID:            "0",
+			URL:           "http://1.1.1.1",
+			MaxHeadSeries: 100,
+			Weight:        4,
+		},
+	}
+	m := newShardManager("0", "", shards, logrus.New())
+	sd, err := m.Shards()
+	require.NoError(t, err)
+	require.Equal(t, &shard.Capacity{MaxHeadSeries: 100, Weight: 4}, sd[0].Capacity)
 }
 
 func TestShardManager_ChangeScale(t *testing.T) {
-	m := newShardManager(nil, logrus.New())
+	m := newShardManager("0", "", nil, logrus.New())
 	require.NoError(t, m.ChangeScale(0))
 }
@@ -17,16 +17,41 @@
 
 package static
 
+import "tkestack.io/kvass/pkg/shard"
+
 type shardConfig struct {
 	// ID is the unique id of this shard
 	ID string `yaml:"id"`
 	// URL for coordinator to communicate with shards
 	URL string `yaml:"url"`
+	// MaxHeadSeries overwrite the max head series of coordinator for this shard, skipped if 0
+	MaxHeadSeries int64 `yaml:"maxHeadSeries,omitempty"`
+	// MaxProcessSeries overwrite the max process series of coordinator for this shard, skipped if 0
+	MaxProcessSeries int64 `yaml:"maxProcessSeries,omitempty"`
+	// Weight scale the limits of coordinator that not overwritten, such as 4 for a shard with 4x memory
+	Weight float64 `yaml:"weight,omitempty"`
+}
+
+// capacity return the capacity of this shard, nil is returned if nothing is overwritten
+func (s *shardConfig) capacity() *shard.Capacity {
+	if s.MaxHeadSeries == 0 && s.MaxProcessSeries == 0 && s.Weight == 0 {
+		return nil
+	}
+
+	return &shard.Capacity{
+		MaxHeadSeries:    s.MaxHeadSeries,
+		MaxProcessSeries: s.MaxProcessSeries,
+		Weight:           s.Weight,
+	}
 }
 
 type staticConfig struct {
 	// Replicas indicate all replicas information
 	Replicas []struct {
+		// Name is the name of this replica, the index of it is used if empty
+		Name string `yaml:"name,omitempty"`
+		// Pool is the name of shard pool this replica belongs to
+		Pool string `yaml:"pool"`
 		// Shards is all shard mem of one replica
 		Shards []shardConfig `yaml:"shards"`
 	} `yaml:"replicas"`
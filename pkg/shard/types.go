@@ -20,6 +20,7 @@ package shard
 import (
 	"time"
 
+	"tkestack.io/kvass/pkg/scrape"
 	"tkestack.io/kvass/pkg/target"
 )
 
@@ -35,6 +36,16 @@ type Manager interface {
 	Shards() ([]*Shard, error)
 	// ChangeScale create or delete Shards according to "expReplicate"
 	ChangeScale(expReplicate int32) error
+	// Pool return the name of shard pool this replica belongs to, empty string means the default pool
+	Pool() string
+	// Name return the name of this replica, which is stable when other replicas are added or deleted
+	Name() string
+}
+
+// EventReporter is an optional interface of Manager that can report coordinating events to the backend
+type EventReporter interface {
+	// ReportEvent report an event with a short reason and a readable message
+	ReportEvent(reason, message string)
 }
 
 // RuntimeInfo contains all running status of this shard
@@ -47,12 +58,57 @@ type RuntimeInfo struct {
 	ConfigHash string `json:"ConfigHash"`
 	// IdleStartAt is the time that shard begin idle
 	IdleStartAt *time.Time `json:"IdleStartAt,omitempty"`
+	// TargetsGeneration is the generation of targets of this shard, 0 means unknown
+	TargetsGeneration int64 `json:"targetsGeneration,omitempty"`
+	// ResidentMemory is the resident memory bytes of prometheus, 0 means unknown
+	ResidentMemory int64 `json:"residentMemory,omitempty"`
+	// HeapMemory is the heap bytes in use of prometheus, 0 means unknown
+	HeapMemory int64 `json:"heapMemory,omitempty"`
 }
 
 // UpdateTargetsRequest contains all information about the targets updating request
 type UpdateTargetsRequest struct {
 	// targets contains all targets this shard should scrape
 	Targets map[string][]*target.Target
+	// Generation is the generation of targets after this request is applied
+	Generation int64 `json:",omitempty"`
+}
+
+// UpdateTargetsDeltaRequest contains the changes of targets since generation BaseGeneration
+type UpdateTargetsDeltaRequest struct {
+	// BaseGeneration is the generation this delta is computed from
+	// the delta is rejected if it is not equal to the current generation of shard
+	BaseGeneration int64
+	// Generation is the generation of targets after this delta is applied
+	Generation int64
+	// Add contains new targets and targets whose state changed
+	Add map[string][]*target.Target
+	// Remove contains the hash of targets that should be deleted
+	Remove []uint64
+}
+
+// UpdateTargetsDeltaResult is the result of UpdateTargetsDeltaRequest
+type UpdateTargetsDeltaResult struct {
+	// Generation is the current generation of targets of shard
+	Generation int64
+	// Resync is true if the delta is rejected and all targets should be sent again
+	Resync bool
+}
+
+// ExploreRequest ask sidecar to explore a target of job
+type ExploreRequest struct {
+	// Job is the job name of target
+	Job string
+	// Target is the target to be explored
+	Target *target.Target
+}
+
+// ExploreResult is the result of ExploreRequest
+type ExploreResult struct {
+	// Result is the series statistics of target, it is nil if Error is not empty
+	Result *scrape.StatisticsSeriesResult `json:",omitempty"`
+	// Error is the error of exploring target
+	Error string `json:",omitempty"`
 }
 
 // UpdateConfigRequest is request struct for POST /
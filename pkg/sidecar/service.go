@@ -43,8 +43,10 @@ type Service struct {
 	ginEngine     *gin.Engine
 	cfgManager    *prom.ConfigManager
 	targetManager *TargetsManager
+	getJob        func(job string) *scrape.JobInfo
 	promURL       string
 	getHeadSeries func() (int64, error)
+	getMemory     func() (*prom.MemoryInfo, error)
 	localPaths    []string
 	runHTTP       func(addr string, handler http.Handler) error
 }
@@ -54,8 +56,10 @@ func NewService(
 	configFile string,
 	promURL string,
 	getHeadSeries func() (int64, error),
+	getMemory func() (*prom.MemoryInfo, error),
 	cfgManager *prom.ConfigManager,
 	targetManager *TargetsManager,
+	getJob func(job string) *scrape.JobInfo,
 	promeRegistry *prometheus.Registry,
 	lg logrus.FieldLogger) *Service {
 
@@ -65,9 +69,11 @@ func NewService(
 		ginEngine:     gin.Default(),
 		lg:            lg,
 		getHeadSeries: getHeadSeries,
+		getMemory:     getMemory,
 		runHTTP:       http.ListenAndServe,
 		cfgManager:    cfgManager,
 		targetManager: targetManager,
+		getJob:        getJob,
 	}
 
 	pprof.Register(s.ginEngine)
@@ -83,6 +89,8 @@ func NewService(
 	}))
 	s.ginEngine.GET(s.localPath("/api/v1/shard/samples/"), h.Wrap(s.samples))
 	s.ginEngine.POST(s.localPath("/api/v1/shard/targets/"), h.Wrap(s.updateTargets))
+	s.ginEngine.POST(s.localPath("/api/v1/shard/targets/delta/"), h.Wrap(s.updateTargetsDelta))
+	s.ginEngine.POST(s.localPath("/api/v1/shard/explore/"), h.Wrap(s.explore))
 	s.ginEngine.POST(s.localPath("/-/reload/"), h.Wrap(func(ctx *gin.Context) *api.Result {
 		if err := s.cfgManager.ReloadFromFile(configFile); err != nil {
 			return api.BadDataErr(err, "reload failed")
@@ -138,12 +146,27 @@ func (s *Service) runtimeInfo(g *gin.Context) *api.Result {
 	if series < min {
 		series = min
 	}
-	return api.Data(&shard.RuntimeInfo{
+
+	ret := &shard.RuntimeInfo{
 		HeadSeries:    series,
 		ProcessSeries: total,
 		ConfigHash:    s.cfgManager.ConfigInfo().ConfigHash,
 		IdleStartAt:   targets.IdleAt,
-	})
+
+		TargetsGeneration: targets.Generation,
+	}
+
+	// memory is optional, coordinator only uses series if it is unknown
+	if s.getMemory != nil {
+		mem, err := s.getMemory()
+		if err != nil {
+			s.lg.Warnf("get memory from prometheus failed: %s", err.Error())
+		} else {
+			ret.ResidentMemory = mem.ResidentMemory
+			ret.HeapMemory = mem.HeapMemory
+		}
+	}
+	return api.Data(ret)
 }
 
 func (s *Service) updateTargets(g *gin.Context) *api.Result {
@@ -159,6 +182,42 @@ func (s *Service) updateTargets(g *gin.Context) *api.Result {
 	return api.Data(nil)
 }
 
+func (s *Service) updateTargetsDelta(g *gin.Context) *api.Result {
+	r := &shard.UpdateTargetsDeltaRequest{}
+	if err := g.BindJSON(&r); err != nil {
+		return api.BadDataErr(err, "bind json")
+	}
+
+	ret, err := s.targetManager.UpdateTargetsDelta(r)
+	if err != nil {
+		return api.InternalErr(err, "")
+	}
+
+	return api.Data(ret)
+}
+
+func (s *Service) explore(g *gin.Context) *api.Result {
+	r := &shard.ExploreRequest{}
+	if err := g.BindJSON(&r); err != nil {
+		return api.BadDataErr(err, "bind json")
+	}
+
+	if r.Target == nil {
+		return api.BadDataErr(fmt.Errorf("target is empty"), "")
+	}
+
+	info := s.getJob(r.Job)
+	if info == nil {
+		return api.BadDataErr(fmt.Errorf("can not found %s scrape info", r.Job), "")
+	}
+
+	result, err := scrape.Statistics(s.lg, info, r.Target.URL(info.Config).String())
+	if err != nil {
+		return api.Data(&shard.ExploreResult{Error: err.Error()})
+	}
+	return api.Data(&shard.ExploreResult{Result: result})
+}
+
 func (s *Service) updateExtraConfig(g *gin.Context) *api.Result {
 	c := prom.ExtraConfig{}
 	if err := g.BindJSON(&c); err != nil {
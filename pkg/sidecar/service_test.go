@@ -22,12 +22,17 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"tkestack.io/kvass/pkg/api"
@@ -67,8 +72,8 @@ func TestService_ServeHTTP(t *testing.T) {
 
 			a := NewService("", tProm.URL, func() (int64, error) {
 				return int64(0), nil
-			}, prom.NewConfigManager(),
-				NewTargetsManager("", prometheus.NewRegistry(), logrus.New()),
+			}, nil, prom.NewConfigManager(),
+				NewTargetsManager("", prometheus.NewRegistry(), logrus.New()), nil,
 				prometheus.NewRegistry(), logrus.New())
 			a.ginEngine.POST(a.localPath("/test"), func(context *gin.Context) {})
 
@@ -79,7 +84,7 @@ func TestService_ServeHTTP(t *testing.T) {
 }
 
 func TestService_Run(t *testing.T) {
-	s := NewService("", "", nil, nil, nil, prometheus.NewRegistry(), logrus.New())
+	s := NewService("", "", nil, nil, nil, nil, nil, prometheus.NewRegistry(), logrus.New())
 	r := require.New(t)
 	called := false
 	s.runHTTP = func(addr string, handler http.Handler) error {
@@ -178,7 +183,7 @@ scrape_configs:
 			cfgMa := prom.NewConfigManager()
 			r.NoError(cfgMa.ReloadFromFile(cfg))
 
-			s := NewService("", "", cs.getPromRuntimeInfo, cfgMa, tm, prometheus.NewRegistry(), logrus.New())
+			s := NewService("", "", cs.getPromRuntimeInfo, nil, cfgMa, tm, nil, prometheus.NewRegistry(), logrus.New())
 			res := s.runtimeInfo(nil)
 			r.Equal(cs.wantAPIResult.Status, res.Status)
 			if res.Status != api.StatusError {
@@ -188,6 +193,46 @@ scrape_configs:
 	}
 }
 
+func TestService_RuntimeInfoMemory(t *testing.T) {
+	var cases = []struct {
+		name      string
+		getMemory func() (*prom.MemoryInfo, error)
+		wantRes   int64
+		wantHeap  int64
+	}{
+		{
+			name: "memory got",
+			getMemory: func() (*prom.MemoryInfo, error) {
+				return &prom.MemoryInfo{ResidentMemory: 100, HeapMemory: 50}, nil
+			},
+			wantRes:  100,
+			wantHeap: 50,
+		},
+		{
+			name: "memory is unknown if failed",
+			getMemory: func() (*prom.MemoryInfo, error) {
+				return nil, fmt.Errorf("test")
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			tm := NewTargetsManager(t.TempDir(), prometheus.NewRegistry(), logrus.New())
+			s := NewService("", "", func() (int64, error) {
+				return 10, nil
+			}, cs.getMemory, prom.NewConfigManager(), tm, nil, prometheus.NewRegistry(), logrus.New())
+
+			res := s.runtimeInfo(nil)
+			r.Equal(api.StatusSuccess, res.Status)
+			info := res.Data.(*shard.RuntimeInfo)
+			r.Equal(cs.wantRes, info.ResidentMemory)
+			r.Equal(cs.wantHeap, info.HeapMemory)
+		})
+	}
+}
+
 func TestService_UpdateTargets(t *testing.T) {
 	data := `
 {
@@ -207,7 +252,7 @@ func TestService_UpdateTargets(t *testing.T) {
 
 	r := require.New(t)
 	tm := NewTargetsManager(t.TempDir(), prometheus.NewRegistry(), logrus.New())
-	s := NewService("", "", nil, nil, tm, prometheus.NewRegistry(), logrus.New())
+	s := NewService("", "", nil, nil, nil, tm, nil, prometheus.NewRegistry(), logrus.New())
 	s.ServeHTTP(w, req)
 	result := w.Result()
 	r.Equal(200, result.StatusCode)
@@ -227,6 +272,69 @@ func TestService_UpdateTargets(t *testing.T) {
 	}), test.MustJSON(tm.TargetsInfo()))
 }
 
+func TestService_UpdateTargetsDelta(t *testing.T) {
+	r := require.New(t)
+	tm := NewTargetsManager(t.TempDir(), prometheus.NewRegistry(), logrus.New())
+	r.NoError(tm.UpdateTargets(&shard.UpdateTargetsRequest{Generation: 1}))
+	s := NewService("", "", nil, nil, nil, tm, nil, prometheus.NewRegistry(), logrus.New())
+
+	ret := &shard.UpdateTargetsDeltaResult{}
+	r, _ = api.TestCall(t, s.ServeHTTP, "/api/v1/shard/targets/delta/", http.MethodPost, test.MustJSON(&shard.UpdateTargetsDeltaRequest{
+		BaseGeneration: 1,
+		Generation:     2,
+		Add: map[string][]*target.Target{
+			"test": {{Hash: 1, Series: 1}},
+		},
+	}), ret)
+	r.Equal(&shard.UpdateTargetsDeltaResult{Generation: 2}, ret)
+	r.Equal(1, len(tm.TargetsInfo().Status))
+}
+
+func TestService_Explore(t *testing.T) {
+	hts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/failed" {
+			w.WriteHeader(502)
+			return
+		}
+		_, _ = w.Write([]byte("metrics0{} 1\nmetrics1{} 1"))
+	}))
+	defer hts.Close()
+	u, err := url.Parse(hts.URL)
+	require.NoError(t, err)
+
+	sm := scrape.New(true, logrus.New())
+	require.NoError(t, sm.ApplyConfig(&prom.ConfigInfo{Config: &config.Config{
+		ScrapeConfigs: []*config.ScrapeConfig{
+			{JobName: "job1", ScrapeTimeout: model.Duration(time.Second)},
+		},
+	}}))
+	s := NewService("", "", nil, nil, nil, nil, sm.GetJob, prometheus.NewRegistry(), logrus.New())
+
+	newRequest := func(job, path string) string {
+		return test.MustJSON(&shard.ExploreRequest{
+			Job: job,
+			Target: &target.Target{Hash: 1, Labels: labels.Labels{
+				{Name: model.AddressLabel, Value: u.Host},
+				{Name: model.SchemeLabel, Value: "http"},
+				{Name: model.MetricsPathLabel, Value: path},
+			}},
+		})
+	}
+
+	ret := &shard.ExploreResult{}
+	r, _ := api.TestCall(t, s.ServeHTTP, "/api/v1/shard/explore/", http.MethodPost, newRequest("job1", "/metrics"), ret)
+	r.Empty(ret.Error)
+	r.Equal(float64(2), ret.Result.Total)
+
+	ret = &shard.ExploreResult{}
+	r, _ = api.TestCall(t, s.ServeHTTP, "/api/v1/shard/explore/", http.MethodPost, newRequest("job1", "/failed"), ret)
+	r.NotEmpty(ret.Error)
+	r.Nil(ret.Result)
+
+	r, result := api.TestCall(t, s.ServeHTTP, "/api/v1/shard/explore/", http.MethodPost, newRequest("job2", "/metrics"), nil)
+	r.Equal(api.StatusError, result.Status)
+}
+
 func TestNewService_UpdateConfig(t *testing.T) {
 	type caseInfo struct {
 		configFile  string
@@ -288,7 +396,7 @@ scrape_configs:
 				return nil
 			})
 
-			svc := NewService(c.configFile, "", nil, cm, nil, prometheus.NewRegistry(), logrus.New())
+			svc := NewService(c.configFile, "", nil, nil, cm, nil, nil, prometheus.NewRegistry(), logrus.New())
 			req := &shard.UpdateConfigRequest{
 				RawContent: c.content,
 			}
@@ -403,7 +511,7 @@ func TestNewService_getJobSamples(t *testing.T) {
 			c := successCase()
 			cs.updateCase(c)
 
-			svc := NewService("", "", nil, nil, c.targetManager, prometheus.NewRegistry(), logrus.New())
+			svc := NewService("", "", nil, nil, nil, c.targetManager, nil, prometheus.NewRegistry(), logrus.New())
 			resp := map[string]*scrape.StatisticsSeriesResult{}
 			r, _ := api.TestCall(t, svc.ginEngine.ServeHTTP, c.uri, http.MethodGet, "", &resp)
 
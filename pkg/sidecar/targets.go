@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
 	"time"
 
 	"github.com/pkg/errors"
@@ -42,6 +43,10 @@ var (
 		Name: "kvass_sidecar_targets_updated_total",
 	}, []string{"success"})
 
+	targetsDeltaTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kvass_sidecar_targets_delta_total",
+	}, []string{"resync"})
+
 	targetsTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "kvass_sidecar_targets_total",
 	}, []string{})
@@ -56,6 +61,8 @@ type TargetsInfo struct {
 	IdleAt *time.Time
 	// Status is the runtime status of all targets
 	Status map[uint64]*target.ScrapeStatus `json:"-"`
+	// Generation is the generation of Targets, it is changed by every updating
+	Generation int64 `json:",omitempty"`
 }
 
 func newTargetsInfo() TargetsInfo {
@@ -67,7 +74,9 @@ func newTargetsInfo() TargetsInfo {
 
 // TargetsManager manager local targets of this shard
 type TargetsManager struct {
-	targets         TargetsInfo
+	targets TargetsInfo
+	// jobs is the job of every target, key is target hash
+	jobs            map[uint64]string
 	updateCallbacks []func(targets map[string][]*target.Target) error
 	storeDir        string
 	log             logrus.FieldLogger
@@ -77,10 +86,12 @@ type TargetsManager struct {
 func NewTargetsManager(storeDir string, promRegistry prometheus.Registerer, log logrus.FieldLogger) *TargetsManager {
 	_ = promRegistry.Register(targetsTotal)
 	_ = promRegistry.Register(targetsUpdatedTotal)
+	_ = promRegistry.Register(targetsDeltaTotal)
 	return &TargetsManager{
 		storeDir: storeDir,
 		log:      log,
 		targets:  newTargetsInfo(),
+		jobs:     map[uint64]string{},
 	}
 }
 
@@ -88,7 +99,10 @@ func NewTargetsManager(storeDir string, promRegistry prometheus.Registerer, log
 func (t *TargetsManager) Load() error {
 	_ = os.MkdirAll(t.storeDir, 0755)
 	defer func() {
-		_ = t.UpdateTargets(&shard.UpdateTargetsRequest{Targets: t.targets.Targets})
+		_ = t.UpdateTargets(&shard.UpdateTargetsRequest{
+			Targets:    t.targets.Targets,
+			Generation: t.targets.Generation,
+		})
 	}()
 
 	data, err := ioutil.ReadFile(t.storePath())
@@ -124,22 +138,126 @@ func (t *TargetsManager) AddUpdateCallbacks(f ...func(targets map[string][]*targ
 
 // UpdateTargets update local targets
 func (t *TargetsManager) UpdateTargets(req *shard.UpdateTargetsRequest) (err error) {
+	t.targets.Targets = req.Targets
+	t.updateStatus()
+	return t.apply(req.Generation)
+}
+
+// apply make current targets take effect and save them to file
+// Generation is set to "generation" only if callbacks succeed, otherwise it is cleaned so that next delta is rejected
+func (t *TargetsManager) apply(generation int64) (err error) {
 	defer func() {
 		targetsUpdatedTotal.WithLabelValues(fmt.Sprint(err == nil)).Inc()
 		targetsTotal.WithLabelValues().Set(float64(len(t.targets.Status)))
 	}()
 
-	t.targets.Targets = req.Targets
-	t.updateStatus()
+	t.targets.Generation = 0
 	t.updateIdleState()
 
 	if err := t.doCallbacks(); err != nil {
 		return errors.Wrapf(err, "do callbacks")
 	}
 
+	t.targets.Generation = generation
 	return errors.Wrapf(t.saveTargets(), "save targets to file")
 }
 
+// UpdateTargetsDelta apply the changes of targets
+// the delta is not applied and Resync is true if BaseGeneration is not equal to current generation
+func (t *TargetsManager) UpdateTargetsDelta(req *shard.UpdateTargetsDeltaRequest) (ret *shard.UpdateTargetsDeltaResult, err error) {
+	if t.targets.Generation == 0 || req.BaseGeneration != t.targets.Generation {
+		t.log.Warnf("targets generation mismatched, want %d, got %d, need resync", t.targets.Generation, req.BaseGeneration)
+		targetsDeltaTotal.WithLabelValues("true").Inc()
+		return &shard.UpdateTargetsDeltaResult{Generation: t.targets.Generation, Resync: true}, nil
+	}
+	targetsDeltaTotal.WithLabelValues("false").Inc()
+
+	changed := false
+	for _, hash := range req.Remove {
+		if t.removeTarget(hash) {
+			changed = true
+		}
+	}
+
+	for job, ts := range req.Add {
+		for _, tar := range ts {
+			if t.addTarget(job, tar) {
+				changed = true
+			}
+		}
+	}
+
+	// nothing is changed, callbacks and saving are skipped
+	// the saved generation falls behind, which only makes coordinator resync once after restarting
+	if !changed {
+		t.targets.Generation = req.Generation
+		return &shard.UpdateTargetsDeltaResult{Generation: t.targets.Generation}, nil
+	}
+
+	if err := t.apply(req.Generation); err != nil {
+		return nil, err
+	}
+
+	return &shard.UpdateTargetsDeltaResult{Generation: t.targets.Generation}, nil
+}
+
+// removeTarget delete target "hash" from current targets, false is returned if it is not found
+func (t *TargetsManager) removeTarget(hash uint64) bool {
+	job, exist := t.jobs[hash]
+	if !exist {
+		return false
+	}
+
+	ts := t.targets.Targets[job]
+	for i, tar := range ts {
+		if tar.Hash == hash {
+			ts = append(ts[:i], ts[i+1:]...)
+			break
+		}
+	}
+
+	if len(ts) == 0 {
+		delete(t.targets.Targets, job)
+	} else {
+		t.targets.Targets[job] = ts
+	}
+	delete(t.jobs, hash)
+	delete(t.targets.Status, hash)
+	return true
+}
+
+// addTarget add target "tar" of "job" or replace the one with the same hash
+// false is returned if the same target exists already
+func (t *TargetsManager) addTarget(job string, tar *target.Target) bool {
+	if old, exist := t.jobs[tar.Hash]; exist {
+		if old != job {
+			t.removeTarget(tar.Hash)
+		} else {
+			ts := t.targets.Targets[job]
+			for i, cur := range ts {
+				if cur.Hash != tar.Hash {
+					continue
+				}
+
+				if reflect.DeepEqual(cur, tar) {
+					return false
+				}
+				ts[i] = tar
+				t.targets.Status[tar.Hash] = t.targetStatus(job, tar)
+				return true
+			}
+		}
+	}
+
+	if t.targets.Targets == nil {
+		t.targets.Targets = map[string][]*target.Target{}
+	}
+	t.targets.Targets[job] = append(t.targets.Targets[job], tar)
+	t.jobs[tar.Hash] = job
+	t.targets.Status[tar.Hash] = t.targetStatus(job, tar)
+	return true
+}
+
 func (t *TargetsManager) updateIdleState() {
 	if len(t.targets.Status) == 0 && t.targets.IdleAt == nil {
 		t.targets.IdleAt = types.TimePtr(timeNow())
@@ -152,22 +270,30 @@ func (t *TargetsManager) updateIdleState() {
 
 func (t *TargetsManager) updateStatus() {
 	status := map[uint64]*target.ScrapeStatus{}
+	jobs := map[uint64]string{}
 	for job, ts := range t.targets.Targets {
 		for _, tar := range ts {
-			if t.targets.Status[tar.Hash] == nil {
-				status[tar.Hash] = target.NewScrapeStatus(tar.Series, tar.TotalSeries)
-			} else {
-				status[tar.Hash] = t.targets.Status[tar.Hash]
-			}
-			if status[tar.Hash].TargetState == target.StateNormal && tar.TargetState == target.StateInTransfer {
-				t.log.Infof("%s/%s begin transfer", job, tar.NoParamURL())
-				status[tar.Hash].ScrapeTimes = 0
-			}
-
-			status[tar.Hash].TargetState = tar.TargetState
+			status[tar.Hash] = t.targetStatus(job, tar)
+			jobs[tar.Hash] = job
 		}
 	}
 	t.targets.Status = status
+	t.jobs = jobs
+}
+
+// targetStatus return the runtime status of target "tar" after it is updated
+func (t *TargetsManager) targetStatus(job string, tar *target.Target) *target.ScrapeStatus {
+	st := t.targets.Status[tar.Hash]
+	if st == nil {
+		st = target.NewScrapeStatus(tar.Series, tar.TotalSeries)
+	}
+
+	if st.TargetState == target.StateNormal && tar.TargetState == target.StateInTransfer {
+		t.log.Infof("%s/%s begin transfer", job, tar.NoParamURL())
+		st.ScrapeTimes = 0
+	}
+	st.TargetState = tar.TargetState
+	return st
 }
 
 func (t *TargetsManager) doCallbacks() error {
@@ -17,6 +17,7 @@
 package sidecar
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"path"
@@ -76,7 +77,7 @@ func TestTargetsManager_Load(t *testing.T) {
 					},
 				},
 				Status: map[uint64]*target.ScrapeStatus{
-					1: target.NewScrapeStatus(1),
+					1: target.NewScrapeStatus(1, 0),
 				},
 			},
 		},
@@ -95,7 +96,7 @@ func TestTargetsManager_Load(t *testing.T) {
 					},
 				},
 				Status: map[uint64]*target.ScrapeStatus{
-					1: target.NewScrapeStatus(1),
+					1: target.NewScrapeStatus(1, 0),
 				},
 			},
 		},
@@ -154,7 +155,7 @@ func TestTargetsManager_UpdateTargets(t *testing.T) {
 					},
 				},
 				Status: map[uint64]*target.ScrapeStatus{
-					1: target.NewScrapeStatus(1),
+					1: target.NewScrapeStatus(1, 0),
 				},
 			},
 		},
@@ -170,7 +171,7 @@ func TestTargetsManager_UpdateTargets(t *testing.T) {
 					},
 				},
 				Status: map[uint64]*target.ScrapeStatus{
-					1: target.NewScrapeStatus(1),
+					1: target.NewScrapeStatus(1, 0),
 				},
 			},
 			req: &shard.UpdateTargetsRequest{
@@ -251,3 +252,133 @@ func TestTargetsManager_AddUpdateCallbacks(t *testing.T) {
 		})
 	}
 }
+
+func TestTargetsManager_UpdateTargetsDelta(t *testing.T) {
+	oldTargets := func() map[string][]*target.Target {
+		return map[string][]*target.Target{
+			"test": {
+				{Hash: 1, Series: 1},
+				{Hash: 2, Series: 1},
+			},
+		}
+	}
+
+	cases := []struct {
+		name            string
+		req             *shard.UpdateTargetsDeltaRequest
+		callbackErr     error
+		wantErr         bool
+		wantResult      *shard.UpdateTargetsDeltaResult
+		wantTargets     map[string][]*target.Target
+		wantGeneration  int64
+		wantSaved       int64
+		wantCallbackRun bool
+	}{
+		{
+			name: "generation mismatched, need resync",
+			req: &shard.UpdateTargetsDeltaRequest{
+				BaseGeneration: 9,
+				Generation:     10,
+			},
+			wantResult:     &shard.UpdateTargetsDeltaResult{Generation: 10, Resync: true},
+			wantTargets:    oldTargets(),
+			wantGeneration: 10,
+			wantSaved:      10,
+		},
+		{
+			name: "add, remove and change state",
+			req: &shard.UpdateTargetsDeltaRequest{
+				BaseGeneration: 10,
+				Generation:     11,
+				Add: map[string][]*target.Target{
+					"test": {
+						{Hash: 2, Series: 1, TargetState: target.StateInTransfer},
+					},
+					"test1": {
+						{Hash: 3, Series: 1},
+					},
+				},
+				Remove: []uint64{1},
+			},
+			wantResult: &shard.UpdateTargetsDeltaResult{Generation: 11},
+			wantTargets: map[string][]*target.Target{
+				"test": {
+					{Hash: 2, Series: 1, TargetState: target.StateInTransfer},
+				},
+				"test1": {
+					{Hash: 3, Series: 1},
+				},
+			},
+			wantGeneration:  11,
+			wantSaved:       11,
+			wantCallbackRun: true,
+		},
+		{
+			name: "nothing changed, skip callbacks and saving",
+			req: &shard.UpdateTargetsDeltaRequest{
+				BaseGeneration: 10,
+				Generation:     11,
+				Add: map[string][]*target.Target{
+					"test": {
+						{Hash: 1, Series: 1},
+					},
+				},
+				Remove: []uint64{4},
+			},
+			wantResult:     &shard.UpdateTargetsDeltaResult{Generation: 11},
+			wantTargets:    oldTargets(),
+			wantGeneration: 11,
+			wantSaved:      10,
+		},
+		{
+			name: "callbacks failed, generation is cleaned",
+			req: &shard.UpdateTargetsDeltaRequest{
+				BaseGeneration: 10,
+				Generation:     11,
+				Remove:         []uint64{1},
+			},
+			callbackErr: fmt.Errorf("test"),
+			wantErr:     true,
+			wantTargets: map[string][]*target.Target{
+				"test": {
+					{Hash: 2, Series: 1},
+				},
+			},
+			wantGeneration:  0,
+			wantSaved:       10,
+			wantCallbackRun: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			r := require.New(t)
+			dir := t.TempDir()
+			tm := NewTargetsManager(dir, prometheus.NewRegistry(), logrus.New())
+			r.NoError(tm.UpdateTargets(&shard.UpdateTargetsRequest{
+				Targets:    oldTargets(),
+				Generation: 10,
+			}))
+
+			called := false
+			tm.AddUpdateCallbacks(func(targets map[string][]*target.Target) error {
+				called = true
+				return cs.callbackErr
+			})
+
+			ret, err := tm.UpdateTargetsDelta(cs.req)
+			r.Equal(cs.wantErr, err != nil)
+			r.Equal(cs.wantResult, ret)
+			r.Equal(cs.wantCallbackRun, called)
+			r.JSONEq(test.MustJSON(cs.wantTargets), test.MustJSON(tm.targets.Targets))
+			r.Equal(cs.wantGeneration, tm.targets.Generation)
+			r.Equal(len(tm.targets.Status), len(cs.wantTargets["test"])+len(cs.wantTargets["test1"]))
+
+			saved := newTargetsInfo()
+			data, err := ioutil.ReadFile(path.Join(dir, storeFileName))
+			r.NoError(err)
+			r.NoError(json.Unmarshal(data, &saved))
+			r.Equal(cs.wantSaved, saved.Generation)
+		})
+	}
+}
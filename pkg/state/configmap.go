@@ -0,0 +1,117 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package state
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// configMapKey is the key of BinaryData that data is saved to
+	configMapKey = "state.gz"
+	// maxConfigMapDataSize is the max compressed data size, ConfigMap is limited to 1MiB include its metadata
+	maxConfigMapDataSize = 1000 * 1024
+)
+
+// ConfigMapStore save data to a kubernetes ConfigMap
+// data is compressed since the size of ConfigMap is limited
+type ConfigMapStore struct {
+	cli       kubernetes.Interface
+	namespace string
+	name      string
+	maxSize   int
+}
+
+// NewConfigMapStore create a ConfigMapStore that save data to ConfigMap "namespace/name"
+func NewConfigMapStore(cli kubernetes.Interface, namespace, name string) *ConfigMapStore {
+	return &ConfigMapStore{
+		cli:       cli,
+		namespace: namespace,
+		name:      name,
+		maxSize:   maxConfigMapDataSize,
+	}
+}
+
+// Save compress data and save it to ConfigMap, ConfigMap will be created if not exist
+// ErrTooLarge is returned if compressed data exceeds the size limit of ConfigMap
+func (c *ConfigMapStore) Save(data []byte) error {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrapf(err, "compress")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrapf(err, "compress")
+	}
+
+	if buf.Len() > c.maxSize {
+		return errors.Wrapf(ErrTooLarge, "compressed size %d is more than %d bytes that configmap %s can hold", buf.Len(), c.maxSize, c.name)
+	}
+
+	cms := c.cli.CoreV1().ConfigMaps(c.namespace)
+	cm, err := cms.Get(context.TODO(), c.name, v12.GetOptions{})
+	if k8serr.IsNotFound(err) {
+		cm = &v1.ConfigMap{}
+		cm.Name = c.name
+		cm.Namespace = c.namespace
+		cm.BinaryData = map[string][]byte{configMapKey: buf.Bytes()}
+		_, err = cms.Create(context.TODO(), cm, v12.CreateOptions{})
+		return errors.Wrapf(err, "create configmap %s", c.name)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "get configmap %s", c.name)
+	}
+
+	cm.BinaryData = map[string][]byte{configMapKey: buf.Bytes()}
+	_, err = cms.Update(context.TODO(), cm, v12.UpdateOptions{})
+	return errors.Wrapf(err, "update configmap %s", c.name)
+}
+
+// Load return the decompressed data from ConfigMap, nil is returned if ConfigMap not exist
+func (c *ConfigMapStore) Load() ([]byte, error) {
+	cm, err := c.cli.CoreV1().ConfigMaps(c.namespace).Get(context.TODO(), c.name, v12.GetOptions{})
+	if k8serr.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "get configmap %s", c.name)
+	}
+
+	data := cm.BinaryData[configMapKey]
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "decompress")
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,54 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package state
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapStore(t *testing.T) {
+	r := require.New(t)
+	s := NewConfigMapStore(fake.NewSimpleClientset(), "default", "kvass-state")
+	data, err := s.Load()
+	r.NoError(err)
+	r.Nil(data)
+
+	// create
+	r.NoError(s.Save([]byte("a")))
+	data, err = s.Load()
+	r.NoError(err)
+	r.Equal("a", string(data))
+
+	// update
+	r.NoError(s.Save([]byte("b")))
+	data, err = s.Load()
+	r.NoError(err)
+	r.Equal("b", string(data))
+
+	// too large
+	s.maxSize = 10
+	err = s.Save([]byte("ccccccccccccccccccccccccccccccccccccccccc"))
+	r.Equal(ErrTooLarge, errors.Cause(err))
+	data, err = s.Load()
+	r.NoError(err)
+	r.Equal("b", string(data))
+}
@@ -0,0 +1,53 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package state
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// FileStore save data to local file
+type FileStore struct {
+	path string
+}
+
+// NewFileStore create a FileStore that save data to file "path"
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Save write data to a temp file and rename it to the target file
+func (f *FileStore) Save(data []byte) error {
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrapf(err, "write file")
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// Load return the content of file, nil is returned if file not exist
+func (f *FileStore) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
@@ -0,0 +1,31 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package state
+
+import "github.com/pkg/errors"
+
+// ErrTooLarge is returned by Store.Save if data exceeds the size limit of store
+var ErrTooLarge = errors.New("data is too large")
+
+// Store save and load state data
+type Store interface {
+	// Save overwrite the stored data with "data"
+	Save(data []byte) error
+	// Load return the stored data, nil is returned if nothing is stored
+	Load() ([]byte, error)
+}